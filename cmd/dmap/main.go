@@ -9,7 +9,6 @@ import (
 	"github.com/mimecast/dtail/internal/cli"
 	"github.com/mimecast/dtail/internal/clients"
 	"github.com/mimecast/dtail/internal/config"
-	"github.com/mimecast/dtail/internal/io/dlog"
 	"github.com/mimecast/dtail/internal/io/signal"
 	"github.com/mimecast/dtail/internal/omode"
 	"github.com/mimecast/dtail/internal/profiling"
@@ -31,6 +30,7 @@ func main() {
 	}
 	userName := user.Name()
 
+	flag.BoolVar(&args.Explain, "explain", false, "Print how the mapreduce query is run instead of running it")
 	flag.BoolVar(&args.NoColor, "noColor", false, "Disable ANSII terminal colors")
 	flag.BoolVar(&args.NoAuthKey, "no-auth-key", false, "Disable auth-key fast reconnect feature")
 	flag.BoolVar(&args.LogPayload, "log-payload", false, "Also tee retrieved payload into the client log file (default: file keeps diagnostics only)")
@@ -78,7 +78,8 @@ func main() {
 	client, err := clients.NewMaprClient(args, clients.DefaultMode)
 	if err != nil {
 		runtime.Stop()
-		dlog.Client.FatalPanic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	status := client.Start(
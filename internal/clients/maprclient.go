@@ -37,6 +37,8 @@ type MaprClient struct {
 	session *maprclient.SessionState
 	// Selected cumulative reporting mode.
 	mode MaprClientMode
+	// Whether to only explain the query instead of running it.
+	explain bool
 }
 
 // NewMaprClient returns a new mapreduce client.
@@ -47,7 +49,25 @@ func NewMaprClient(args config.Args, maprClientMode MaprClientMode) (*MaprClient
 
 	query, err := mapr.NewQuery(args.QueryStr)
 	if err != nil {
-		dlog.Client.FatalPanic(args.QueryStr, "Can't parse mapr query", err)
+		// The query error points at the offending token, so show it as it is
+		// instead of panicking.
+		return nil, fmt.Errorf("Can't parse mapr query: %s", mapr.FormatError(err))
+	}
+
+	// Register the log formats declared in the config first, as both the
+	// explanation and the plan time warnings need to know their fields.
+	clientRuntime := newClientRuntimeBoundary(config.CurrentRuntime())
+	if err := clientRuntime.RegisterLogFormats(args.Serverless); err != nil {
+		dlog.Client.Error("Unable to register mapr log formats", err)
+	}
+
+	if args.Explain || query.Explain {
+		// Don't connect to any server, Start explains the query only.
+		return &MaprClient{
+			baseClient: baseClient{Args: args, runtime: clientRuntime},
+			session:    maprclient.NewSessionState(query),
+			explain:    true,
+		}, nil
 	}
 
 	// Warn once, at plan time, about $-variables the selected parser cannot
@@ -65,7 +85,7 @@ func NewMaprClient(args config.Args, maprClientMode MaprClientMode) (*MaprClient
 			Args:       args,
 			throttleCh: make(chan struct{}, args.ConnectionsPerCPU*runtime.NumCPU()),
 			retry:      retry,
-			runtime:    newClientRuntimeBoundary(config.CurrentRuntime()),
+			runtime:    clientRuntime,
 		},
 		session: maprclient.NewSessionState(query),
 		mode:    maprClientMode,
@@ -83,6 +103,11 @@ func NewMaprClient(args config.Args, maprClientMode MaprClientMode) (*MaprClient
 
 // Start starts the mapreduce client.
 func (c *MaprClient) Start(ctx context.Context, statsCh <-chan string) (status int) {
+	if c.explain {
+		writeExplain(os.Stdout, c.session.Snapshot().Query)
+		return 0
+	}
+
 	go c.periodicReportResults(ctx)
 
 	status = c.baseClient.Start(ctx, statsCh)
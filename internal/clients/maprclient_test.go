@@ -2,11 +2,14 @@ package clients
 
 import (
 	"bytes"
+	"io"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/mimecast/dtail/internal/config"
+	"github.com/mimecast/dtail/internal/io/dlog"
 	"github.com/mimecast/dtail/internal/mapr"
 	maprclient "github.com/mimecast/dtail/internal/mapr/client"
 	"github.com/mimecast/dtail/internal/omode"
@@ -151,3 +154,67 @@ func TestWarnUnknownQueryVariables(t *testing.T) {
 		})
 	}
 }
+
+// TestNewMaprClientRegistersConfigLogFormats verifies that the log formats
+// declared in the config are known when the query is explained and when the
+// plan time warnings are written, i.e. before connecting to any server. The
+// warnings are only written for known log formats.
+func TestNewMaprClientRegistersConfigLogFormats(t *testing.T) {
+	originalClient, originalLogger, originalCommonLogger := config.Client, dlog.Client, dlog.Common
+	config.Client = &config.ClientConfig{MapreduceLogFormats: map[string]config.LogFormat{
+		"maprclientformat": {Regex: `^(?P<status>\d+) (?P<took>\S+)$`},
+	}}
+	dlog.Client, dlog.Common = &dlog.DLog{}, &dlog.DLog{}
+	t.Cleanup(func() {
+		config.Client, dlog.Client, dlog.Common = originalClient, originalLogger, originalCommonLogger
+	})
+
+	client, err := NewMaprClient(config.Args{
+		Mode:     omode.MapClient,
+		Explain:  true,
+		QueryStr: "select count($took) group by $hostname logformat maprclientformat",
+	}, DefaultMode)
+	if err != nil {
+		t.Fatalf("NewMaprClient() error = %v", err)
+	}
+	var buf bytes.Buffer
+	writeExplain(&buf, client.session.Snapshot().Query)
+	if got := buf.String(); !strings.Contains(got, "Parser:    maprclientformat\n") ||
+		!strings.Contains(got, "$took is not a known variable") {
+		t.Errorf("Unexpected explanation:\n%s", got)
+	}
+
+	stderr := captureStderr(t, func() {
+		if _, err := NewMaprClient(config.Args{
+			Mode:       omode.MapClient,
+			Serverless: true,
+			QueryStr:   "select count($took) group by $hostname logformat maprclientformat",
+		}, DefaultMode); err != nil {
+			t.Fatalf("NewMaprClient() error = %v", err)
+		}
+	})
+	// The fields of the format are barewords, so $took is reported.
+	if !strings.Contains(stderr, "$took is not a known variable") ||
+		strings.Contains(stderr, "$hostname") {
+		t.Errorf("Unexpected plan time warnings: %q", stderr)
+	}
+}
+
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() error = %v", err)
+	}
+	originalStderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = originalStderr }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Unable to read stderr: %v", err)
+	}
+	return string(out)
+}
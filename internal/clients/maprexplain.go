@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mimecast/dtail/internal/mapr"
+	"github.com/mimecast/dtail/internal/mapr/logformat"
+)
+
+// writeExplain writes how a mapreduce query is run instead of running it: the
+// parsed clauses, the log format parser, the fields the parser extracts, the
+// regex selecting the log lines on the servers and the plan time warnings. As
+// with warnUnknownQueryVariables, the client assumes the servers' default log
+// format to be "default".
+func writeExplain(w io.Writer, query *mapr.Query) {
+	fmt.Fprintf(w, "%-10s %s\n", "Query:", query.RawQuery)
+	query.WriteExplain(w)
+
+	logFormat := query.EffectiveLogFormat("")
+	if logformat.IsRegistered(logFormat) {
+		fmt.Fprintf(w, "%-10s %s\n", "Parser:", logFormat)
+	} else {
+		fmt.Fprintf(w, "%-10s %s (unknown, the servers fall back to the default parser)\n",
+			"Parser:", logFormat)
+	}
+
+	plan := query.ParserFieldPlan()
+	fields := make([]string, 0, len(plan.Fields))
+	for field := range plan.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	switch {
+	case plan.AllFields:
+		fmt.Fprintf(w, "%-10s %s\n", "Fields:", "(all)")
+	case len(fields) == 0:
+		fmt.Fprintf(w, "%-10s %s\n", "Fields:", "(none)")
+	default:
+		fmt.Fprintf(w, "%-10s %s\n", "Fields:", strings.Join(fields, ", "))
+	}
+
+	fmt.Fprintf(w, "%-10s %s\n", "Regex:", maprRegexForQuery(query))
+
+	warnings := logformat.PlanVariableWarnings(query, logFormat)
+	if len(warnings) == 0 {
+		fmt.Fprintf(w, "%-10s %s\n", "Warnings:", "(none)")
+		return
+	}
+	for i, warning := range warnings {
+		label := ""
+		if i == 0 {
+			label = "Warnings:"
+		}
+		fmt.Fprintf(w, "%-10s %s\n", label, warning)
+	}
+}
@@ -0,0 +1,35 @@
+package clients
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteExplain(t *testing.T) {
+	query := mustMaprClientQuery(t, "select $foo,count(status) from stats group by $foo")
+
+	var buf bytes.Buffer
+	writeExplain(&buf, query)
+	got := buf.String()
+	for _, want := range []string{
+		"Query:     select $foo,count(status) from stats group by $foo\n",
+		"Group by:  $foo\n",
+		"Parser:    default\n",
+		"Fields:    $foo, status\n",
+		"Regex:     \\|MAPREDUCE:STATS\\|\n",
+		"Warnings:  warning: $foo is not a known variable",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in explanation:\n%s", want, got)
+		}
+	}
+
+	query = mustMaprClientQuery(t, "select count(status) logformat nosuchformat")
+	buf.Reset()
+	writeExplain(&buf, query)
+	if got := buf.String(); !strings.Contains(got, "Parser:    nosuchformat (unknown") ||
+		!strings.Contains(got, "Warnings:  (none)") {
+		t.Errorf("Unexpected explanation:\n%s", got)
+	}
+}
@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/mimecast/dtail/internal/color"
 	"github.com/mimecast/dtail/internal/config"
 	"github.com/mimecast/dtail/internal/mapr"
+	"github.com/mimecast/dtail/internal/mapr/logformat"
 	serverHandlers "github.com/mimecast/dtail/internal/server/handlers"
 	sshserver "github.com/mimecast/dtail/internal/ssh/server"
 	user "github.com/mimecast/dtail/internal/user/server"
@@ -18,6 +20,7 @@ type clientRuntimeBoundary struct {
 	sshConnectTimeout time.Duration
 	interruptPause    time.Duration
 	serverCfg         *config.ServerConfig
+	clientCfg         *config.ClientConfig
 	output            *clientOutputFormatter
 }
 
@@ -38,6 +41,7 @@ func newClientRuntimeBoundary(cfg config.RuntimeConfig) *clientRuntimeBoundary {
 		sshConnectTimeout: sshConnectTimeout,
 		interruptPause:    time.Second * time.Duration(config.InterruptTimeoutS),
 		serverCfg:         cfg.Server,
+		clientCfg:         cfg.Client,
 		output:            newClientOutputFormatter(cfg.Client),
 	}
 }
@@ -57,6 +61,22 @@ func (r *clientRuntimeBoundary) InterruptPause() time.Duration {
 	return r.interruptPause
 }
 
+// RegisterLogFormats registers the mapr log formats declared in the client
+// config, so that the explanation and the plan time warnings know their
+// fields. In serverless mode
+// the ones declared in the server config are registered first, as the client
+// parses the logs itself then.
+func (r *clientRuntimeBoundary) RegisterLogFormats(serverless bool) error {
+	var errs []error
+	if serverless && r.serverCfg != nil {
+		errs = append(errs, logformat.RegisterConfigFormats(r.serverCfg.MapreduceLogFormats))
+	}
+	if r.clientCfg != nil {
+		errs = append(errs, logformat.RegisterConfigFormats(r.clientCfg.MapreduceLogFormats))
+	}
+	return errors.Join(errs...)
+}
+
 func (r *clientRuntimeBoundary) NewServerlessHandler(userName string) (serverHandlers.Handler, error) {
 	var permissionLookup user.PermissionLookup
 	if r.serverCfg != nil {
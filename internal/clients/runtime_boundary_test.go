@@ -7,6 +7,7 @@ import (
 
 	"github.com/mimecast/dtail/internal/color"
 	"github.com/mimecast/dtail/internal/config"
+	"github.com/mimecast/dtail/internal/mapr/logformat"
 )
 
 func TestNewClientRuntimeBoundaryDefaults(t *testing.T) {
@@ -42,6 +43,33 @@ func TestNewClientRuntimeBoundaryUsesConfiguredSSHSettings(t *testing.T) {
 	}
 }
 
+func TestClientRuntimeBoundaryRegisterLogFormats(t *testing.T) {
+	runtime := newClientRuntimeBoundary(config.RuntimeConfig{
+		Server: &config.ServerConfig{MapreduceLogFormats: map[string]config.LogFormat{
+			"boundaryserverformat": {Regex: `^(?P<status>\d+)`},
+		}},
+		Client: &config.ClientConfig{MapreduceLogFormats: map[string]config.LogFormat{
+			"boundaryclientformat": {Regex: `^(?P<status>\d+)`},
+		}},
+	})
+
+	if err := runtime.RegisterLogFormats(false); err != nil {
+		t.Fatalf("Unable to register log formats: %v", err)
+	}
+	if !logformat.IsRegistered("boundaryclientformat") {
+		t.Fatalf("Expected the client log format to be registered")
+	}
+	if logformat.IsRegistered("boundaryserverformat") {
+		t.Fatalf("Expected the server log format to be registered in serverless mode only")
+	}
+	if err := runtime.RegisterLogFormats(true); err != nil {
+		t.Fatalf("Unable to register log formats: %v", err)
+	}
+	if !logformat.IsRegistered("boundaryserverformat") {
+		t.Fatalf("Expected the server log format to be registered in serverless mode")
+	}
+}
+
 func TestClientOutputFormatterColorModes(t *testing.T) {
 	plain := newClientOutputFormatter(nil)
 	if got := plain.FormatInterruptMessage(1, "hello"); got != " hello" {
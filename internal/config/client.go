@@ -118,6 +118,10 @@ type ClientConfig struct {
 	// to stdout/terminal regardless of this setting. Only affects the default
 	// "fout" logger (stdout+file); see docs for other loggers.
 	LogPayload bool `json:",omitempty"`
+	// Additional mapr log formats by name, parsed with a regex each. They are
+	// used in serverless mode (in addition to the ones of the server config)
+	// and for the plan time warnings of the queries.
+	MapreduceLogFormats map[string]LogFormat `json:",omitempty"`
 }
 
 // Create a new default client configuration.
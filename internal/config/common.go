@@ -18,6 +18,9 @@ type CommonConfig struct {
 	LogRotation string
 	// The cache directory
 	CacheDir string
+	// The maximum number of distinct value combinations a 'select distinct'
+	// mapreduce query keeps, on every server and on the client.
+	MapreduceDistinctLimit int `json:",omitempty"`
 }
 
 // Create a new default configuration.
@@ -31,5 +34,6 @@ func newDefaultCommonConfig() *CommonConfig {
 		LogLevel:                   DefaultLogLevel,
 		LogRotation:                "daily",
 		CacheDir:                   "cache",
+		MapreduceDistinctLimit:     10000,
 	}
 }
@@ -37,6 +37,24 @@ type Continuous struct {
 	RestartOnDayChange bool `json:",omitempty"`
 }
 
+// LogFormat declares a mapr log format whose lines are parsed with a regex.
+// Every named capture group of the regex becomes a field, e.g. (?P<status>\d+)
+// the field status. Lines not matching the regex are ignored.
+type LogFormat struct {
+	// The regex with a named capture group per field.
+	Regex string
+	// The capture group of the timestamp, which is mapped onto $time, $date,
+	// $hour, $minute and $second.
+	TimeField string `json:",omitempty"`
+	// The Go layout of the timestamp, e.g. "02/Jan/2006:15:04:05 -0700". If
+	// empty, the layouts known to the bucket function are tried.
+	TimeLayout string `json:",omitempty"`
+	// Type hints of the numeric capture groups, one of int, float, duration
+	// (converted to seconds) and bytes (converted to a number of bytes).
+	// Values which aren't of the type are absent.
+	Types map[string]string `json:",omitempty"`
+}
+
 // ServerConfig represents the server configuration.
 type ServerConfig struct {
 	// The SSH server bind port.
@@ -53,6 +71,11 @@ type ServerConfig struct {
 	Permissions Permissions `json:",omitempty"`
 	// The mapr log format
 	MapreduceLogFormat string `json:",omitempty"`
+	// The key of the timestamp of the json mapr log format, e.g. @timestamp
+	// or a nested key such as meta.ts. Defaults to time.
+	MapreduceJSONTimeKey string `json:",omitempty"`
+	// Additional mapr log formats by name, parsed with a regex each.
+	MapreduceLogFormats map[string]LogFormat `json:",omitempty"`
 	// The default path of the server host key
 	HostKeyFile string
 	// The host key size in bits
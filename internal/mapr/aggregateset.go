@@ -3,14 +3,26 @@ package mapr
 import (
 	"context"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/mimecast/dtail/internal/io/dlog"
 	"github.com/mimecast/dtail/internal/io/pool"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 	"github.com/mimecast/dtail/internal/protocol"
 )
 
+// Suffixes of the keys of additional values, which some aggregations store
+// next to the key of the aggregation itself, e.g. "stddev(latency)#sumsq".
+const (
+	sumSquaresSuffix = "#sumsq"
+	countSuffix      = "#count"
+	firstOrderSuffix = "#order"
+	rateFromSuffix   = "#from"
+	rateToSuffix     = "#to"
+)
+
 // AggregateSet represents aggregated key/value pairs from the
 // MAPREDUCE log lines. These could be either string values or float
 // values.
@@ -18,6 +30,10 @@ type AggregateSet struct {
 	Samples int
 	FValues map[string]float64
 	SValues map[string]string
+	// Sketches of the count_distinct aggregations, created on demand.
+	distinct map[string]*hyperLogLog
+	// Sketches of the quantile aggregations, created on demand.
+	quantiles map[string]*ddSketch
 }
 
 // NewAggregateSet creates a new empty aggregate set.
@@ -38,39 +54,125 @@ func (s *AggregateSet) String() string {
 func (s *AggregateSet) Merge(query *Query, set *AggregateSet) error {
 	s.Samples += set.Samples
 	//dlog.Common.Trace("Merge", set)
-	for _, sc := range query.Select {
-		storage := sc.FieldStorage
-		switch sc.Operation {
-		case Count:
-			fallthrough
-		case Sum:
-			fallthrough
-		case Avg:
-			fallthrough
-		case Percentage:
-			fallthrough
-		case Percentile:
-			value := set.FValues[storage]
-			s.addFloat(storage, value)
-		case Min:
-			value := set.FValues[storage]
-			s.addFloatMin(storage, value)
-		case Max:
-			value := set.FValues[storage]
-			s.addFloatMax(storage, value)
-		case Last:
-			value := set.SValues[storage]
-			s.setString(storage, value)
-		case Len:
-			s.setString(storage, set.SValues[storage])
-			s.setFloat(storage, set.FValues[storage])
-		default:
-			return fmt.Errorf("Unknown aggregation method '%v'", sc.Operation)
+	for _, sc := range query.Aggregations() {
+		if err := s.MergeOperation(sc.FieldStorage, sc.Operation, set); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// MergeOperation merges the values of a single aggregation, stored under the
+// given key, of another aggregate set into this one.
+func (s *AggregateSet) MergeOperation(key string, agg AggregateOperation, set *AggregateSet) error {
+	switch agg {
+	case Count:
+		fallthrough
+	case Sum:
+		fallthrough
+	case Percentage:
+		fallthrough
+	case Percentile:
+		value := set.FValues[key]
+		s.addFloat(key, value)
+	case Avg:
+		// The sum and the count of the values.
+		for _, k := range []string{key, key + countSuffix} {
+			if value, ok := set.FValues[k]; ok {
+				s.addFloat(k, value)
+			}
+		}
+	case Min:
+		// A set without any value of the field must not count as a zero.
+		if value, ok := set.FValues[key]; ok {
+			s.addFloatMin(key, value)
+		}
+	case Max:
+		if value, ok := set.FValues[key]; ok {
+			s.addFloatMax(key, value)
+		}
+	case Last:
+		if value, ok := set.SValues[key]; ok {
+			s.setString(key, value)
+		}
+	case Len:
+		if value, ok := set.SValues[key]; ok {
+			s.setString(key, value)
+			s.setFloat(key, set.FValues[key])
+		}
+	case Variance:
+		fallthrough
+	case StdDev:
+		// Sum, sum of squares and count merge exactly, see addMoments.
+		for _, k := range momentKeys(key) {
+			if value, ok := set.FValues[k]; ok {
+				s.addFloat(k, value)
+			}
+		}
+	case First:
+		if value, ok := set.SValues[key]; ok {
+			s.mergeFirst(key, value, set.SValues[key+firstOrderSuffix])
+		}
+	case CountDistinct, Quantile:
+		s.mergeSketch(key, set)
+	case Histogram:
+		s.mergeHistogram(key, set.FValues)
+	case Delta:
+		s.addFloat(key, set.FValues[key])
+	case Rate:
+		s.addFloat(key, set.FValues[key])
+		if from, ok := set.FValues[key+rateFromSuffix]; ok {
+			s.mergeRateSpan(key, from, set.FValues[key+rateToSuffix])
+		}
+	default:
+		return fmt.Errorf("Unknown aggregation method '%v'", agg)
+	}
+	return nil
+}
+
+// mergeSketch merges the count_distinct or quantile sketch stored under the
+// given key of another aggregate set into this one.
+func (s *AggregateSet) mergeSketch(key string, set *AggregateSet) {
+	if h, ok := set.distinct[key]; ok {
+		s.distinctSketch(key).merge(h)
+	}
+	if d, ok := set.quantiles[key]; ok {
+		s.quantileSketch(key).merge(d)
+	}
+}
+
+// DistinctCount returns the estimated number of distinct values stored under
+// the given key.
+func (s *AggregateSet) DistinctCount(key string) uint64 {
+	h, ok := s.distinct[key]
+	if !ok {
+		return 0
+	}
+	return h.count()
+}
+
+// Variance returns the population variance of the values stored under the
+// given key.
+func (s *AggregateSet) Variance(key string) float64 {
+	count := s.FValues[key+countSuffix]
+	if count == 0 {
+		return 0
+	}
+	mean := s.FValues[key] / count
+	// Guard against tiny negative results due to floating point errors.
+	return math.Max(0, s.FValues[key+sumSquaresSuffix]/count-mean*mean)
+}
+
+// Quantile returns the estimated value at quantile q (between 0 and 1) of the
+// values stored under the given key.
+func (s *AggregateSet) Quantile(key string, q float64) float64 {
+	d, ok := s.quantiles[key]
+	if !ok {
+		return 0
+	}
+	return d.quantile(q)
+}
+
 // Serialize the aggregate set so it can be sent over the wire. Returns true
 // when the serialized message was successfully sent, and false when the
 // context was cancelled before the send completed. Callers that own the
@@ -100,6 +202,20 @@ func (s *AggregateSet) Serialize(ctx context.Context, groupKey string, ch chan<-
 		sb.WriteString(protocol.AggregateDelimiter)
 	}
 
+	for k, h := range s.distinct {
+		sb.WriteString(k)
+		sb.WriteString(protocol.AggregateKVDelimiter)
+		sb.WriteString(h.encode())
+		sb.WriteString(protocol.AggregateDelimiter)
+	}
+
+	for k, d := range s.quantiles {
+		sb.WriteString(k)
+		sb.WriteString(protocol.AggregateKVDelimiter)
+		sb.WriteString(d.encode())
+		sb.WriteString(protocol.AggregateDelimiter)
+	}
+
 	select {
 	case ch <- sb.String():
 		return true
@@ -141,6 +257,138 @@ func (s *AggregateSet) addFloatMax(key string, value float64) {
 	}
 }
 
+// Add a value to the sum, the sum of squares and the count of a key. Unlike
+// the variance itself, these can be merged exactly.
+func (s *AggregateSet) addMoments(key string, value float64) {
+	s.addFloat(key, value)
+	s.addFloat(key+sumSquaresSuffix, value*value)
+	s.addFloat(key+countSuffix, 1)
+}
+
+// The keys of the values stored by addMoments.
+func momentKeys(key string) []string {
+	return []string{key, key + sumSquaresSuffix, key + countSuffix}
+}
+
+// AggregateFirst keeps the value which comes first by the given order, e.g.
+// the timestamp of the log line. Values with an empty order come after all
+// others. On a tie the value aggregated first is kept, as the lines of a log
+// file are aggregated in order.
+func (s *AggregateSet) AggregateFirst(key, value, order string) {
+	current, ok := s.SValues[key+firstOrderSuffix]
+	if ok && !orderBefore(order, current) {
+		return
+	}
+	s.setString(key, value)
+	s.setString(key+firstOrderSuffix, order)
+}
+
+// Merge a first value of another aggregate set. On a tie the smaller value is
+// kept, so the result doesn't depend on the order the sets are merged in.
+func (s *AggregateSet) mergeFirst(key, value, order string) {
+	current, ok := s.SValues[key+firstOrderSuffix]
+	if ok && !orderBefore(order, current) &&
+		(orderBefore(current, order) || value >= s.SValues[key]) {
+		return
+	}
+	s.setString(key, value)
+	s.setString(key+firstOrderSuffix, order)
+}
+
+// orderBefore returns true if order a comes before order b, whereas an empty
+// order comes after all others. Orders are timestamps, which are compared by
+// their time, as the log formats keep them in different layouts, e.g. the
+// syslog or access log timestamps which don't sort as text. Timestamps which
+// can't be parsed come after the ones which can and are compared as text.
+func orderBefore(a, b string) bool {
+	if a == "" {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	aTime, aOK := funcs.ParseTime(a)
+	bTime, bOK := funcs.ParseTime(b)
+	switch {
+	case aOK && bOK:
+		return aTime.Before(bTime)
+	case aOK != bOK:
+		return aOK
+	default:
+		return a < b
+	}
+}
+
+// Get the count_distinct sketch of a key, create it if it doesn't exist yet.
+func (s *AggregateSet) distinctSketch(key string) *hyperLogLog {
+	h, ok := s.distinct[key]
+	if !ok {
+		if s.distinct == nil {
+			s.distinct = make(map[string]*hyperLogLog)
+		}
+		h = newHyperLogLog()
+		s.distinct[key] = h
+	}
+	return h
+}
+
+// Get the quantile sketch of a key, create it if it doesn't exist yet.
+func (s *AggregateSet) quantileSketch(key string) *ddSketch {
+	d, ok := s.quantiles[key]
+	if !ok {
+		if s.quantiles == nil {
+			s.quantiles = make(map[string]*ddSketch)
+		}
+		d = newDDSketch()
+		s.quantiles[key] = d
+	}
+	return d
+}
+
+// AggregateCounter adds the increase of a monotonic counter between two
+// consecutive samples, taken at the given Unix times in seconds, for the delta
+// and rate aggregations. A counter which decreased was reset, so the increase
+// is its value since the reset.
+func (s *AggregateSet) AggregateCounter(key string, agg AggregateOperation,
+	previous, value, previousTime, valueTime float64) {
+
+	increase := value - previous
+	if increase < 0 {
+		increase = value
+	}
+	s.addFloat(key, increase)
+	if agg == Rate {
+		s.mergeRateSpan(key, previousTime, valueTime)
+	}
+}
+
+// AvgCount returns the number of values of the average stored under the given
+// key. The lines of the group without a value of the field aren't counted.
+// Servers of older versions don't send the count, in which case it is the
+// number of samples of the group.
+func (s *AggregateSet) AvgCount(key string) float64 {
+	if count, ok := s.FValues[key+countSuffix]; ok {
+		return count
+	}
+	return float64(s.Samples)
+}
+
+// Extend the time span of the samples of a rate aggregation.
+func (s *AggregateSet) mergeRateSpan(key string, from, to float64) {
+	s.addFloatMin(key+rateFromSuffix, from)
+	s.addFloatMax(key+rateToSuffix, to)
+}
+
+// Rate returns the increase per second of the counter stored under the given
+// key, which is the total increase divided by the time span of the samples.
+func (s *AggregateSet) Rate(key string) float64 {
+	span := s.FValues[key+rateToSuffix] - s.FValues[key+rateFromSuffix]
+	if span <= 0 {
+		return 0
+	}
+	return s.FValues[key] / span
+}
+
 // Set a string.
 func (s *AggregateSet) setString(key, value string) {
 	s.SValues[key] = value
@@ -151,6 +399,74 @@ func (s *AggregateSet) setFloat(key string, value float64) {
 	s.FValues[key] = value
 }
 
+// AggregateSerialized aggregates the values of an aggregation as serialized
+// by a server, whereas the fields map the serialized keys to their values.
+// Some aggregations are serialized as multiple values, e.g. stddev as sum, sum
+// of squares and count. Returns false if the fields contain no value for the
+// aggregation.
+func (s *AggregateSet) AggregateSerialized(key string, agg AggregateOperation,
+	fields map[string]string) (bool, error) {
+
+	value, ok := fields[key]
+	if !ok {
+		return false, nil
+	}
+
+	switch agg {
+	case Variance:
+		fallthrough
+	case StdDev:
+		for _, k := range momentKeys(key) {
+			if err := s.Aggregate(k, agg, fields[k], true); err != nil {
+				return false, err
+			}
+		}
+	case First:
+		s.mergeFirst(key, value, fields[key+firstOrderSuffix])
+	case Avg:
+		if err := s.Aggregate(key, agg, value, true); err != nil {
+			return false, err
+		}
+		// The count of the values, which servers of older versions don't
+		// send, see AvgCount.
+		if count, ok := fields[key+countSuffix]; ok {
+			f, err := strconv.ParseFloat(count, 64)
+			if err != nil {
+				return false, err
+			}
+			s.addFloat(key+countSuffix, f)
+		}
+	case Rate:
+		var values [3]float64
+		for i, k := range []string{key, key + rateFromSuffix, key + rateToSuffix} {
+			f, err := strconv.ParseFloat(fields[k], 64)
+			if err != nil {
+				return false, err
+			}
+			values[i] = f
+		}
+		s.addFloat(key, values[0])
+		s.mergeRateSpan(key, values[1], values[2])
+	case Histogram:
+		// The total count and the count of every bucket.
+		for k, v := range fields {
+			if !isHistogramKey(key, k) {
+				continue
+			}
+			count, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return false, err
+			}
+			s.addFloat(k, count)
+		}
+	default:
+		if err := s.Aggregate(key, agg, value, true); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // Aggregate data to the aggregate set.
 func (s *AggregateSet) Aggregate(key string, agg AggregateOperation, value string, clientAggregation bool) (err error) {
 	var f float64
@@ -174,6 +490,31 @@ func (s *AggregateSet) Aggregate(key string, agg AggregateOperation, value strin
 		s.setString(key, value)
 		s.setFloat(key, float64(len(value)))
 		return
+	case First:
+		s.AggregateFirst(key, value, "")
+		return
+	case CountDistinct:
+		// The client receives the encoded sketches of the servers.
+		if clientAggregation {
+			var h *hyperLogLog
+			if h, err = decodeHyperLogLog(value); err != nil {
+				return
+			}
+			s.distinctSketch(key).merge(h)
+			return
+		}
+		s.distinctSketch(key).add(value)
+		return
+	case Quantile:
+		// The client receives the encoded sketches of the servers.
+		if clientAggregation {
+			var d *ddSketch
+			if d, err = decodeDDSketch(value); err != nil {
+				return
+			}
+			s.quantileSketch(key).merge(d)
+			return
+		}
 	default:
 	}
 
@@ -186,16 +527,31 @@ func (s *AggregateSet) Aggregate(key string, agg AggregateOperation, value strin
 	switch agg {
 	case Sum:
 		fallthrough
-	case Avg:
+	case Delta:
 		fallthrough
 	case Percentage:
 		fallthrough
 	case Percentile:
 		s.addFloat(key, f)
+	case Avg:
+		s.addFloat(key, f)
+		if !clientAggregation {
+			s.addFloat(key+countSuffix, 1)
+		}
 	case Min:
 		s.addFloatMin(key, f)
 	case Max:
 		s.addFloatMax(key, f)
+	case Variance:
+		fallthrough
+	case StdDev:
+		if clientAggregation {
+			s.addFloat(key, f)
+			return
+		}
+		s.addMoments(key, f)
+	case Quantile:
+		s.quantileSketch(key).add(f)
 	default:
 		err = fmt.Errorf("Unknown aggregation method '%v'", agg)
 	}
@@ -0,0 +1,372 @@
+package mapr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mimecast/dtail/internal/mapr/funcs"
+)
+
+// The arithmetic operators, in the order of their precedence.
+const arithOperators = "+-*/"
+
+// arithExpr is a parsed arithmetic expression, e.g. bytes/1024 in a 'set'
+// clause or sum(bytes)/count(req) in a 'select' clause. It is either an
+// operation on two sub-expressions, a negation or an operand.
+type arithExpr struct {
+	// One of arithOperators, 'n' for a negation, or 0 for an operand.
+	op          byte
+	left, right *arithExpr
+	operand     arithOperand
+}
+
+// arithOperand is the value of an expression leaf, e.g. a number, a field or
+// an aggregation.
+type arithOperand interface {
+	// value returns the operand's value. The argument depends on where the
+	// expression is evaluated, e.g. the fields of a log line.
+	value(in any) float64
+	String() string
+}
+
+// arithNumber is a numeric literal operand, e.g. 1024.
+type arithNumber float64
+
+func (n arithNumber) value(any) float64 { return float64(n) }
+func (n arithNumber) String() string    { return formatArithValue(float64(n)) }
+
+// makeArithOperand creates the operand of an expression leaf, e.g. a field
+// or an aggregation. It is never called for numeric literals.
+type makeArithOperand func(operand string) (arithOperand, error)
+
+func (e *arithExpr) String() string {
+	switch e.op {
+	case 0:
+		return e.operand.String()
+	case 'n':
+		return fmt.Sprintf("-(%s)", e.left)
+	default:
+		return fmt.Sprintf("(%s%c%s)", e.left, e.op, e.right)
+	}
+}
+
+// eval evaluates the expression. A division by zero results in NaN.
+func (e *arithExpr) eval(in any) float64 {
+	switch e.op {
+	case 0:
+		return e.operand.value(in)
+	case 'n':
+		return -e.left.eval(in)
+	}
+
+	left, right := e.left.eval(in), e.right.eval(in)
+	switch e.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	default:
+		if right == 0 {
+			return math.NaN()
+		}
+		return left / right
+	}
+}
+
+// operands returns all operands of the expression.
+func (e *arithExpr) operands() []arithOperand {
+	if e.op == 0 {
+		return []arithOperand{e.operand}
+	}
+	operands := e.left.operands()
+	if e.right != nil {
+		operands = append(operands, e.right.operands()...)
+	}
+	return operands
+}
+
+// formatArithValue formats the result of an expression.
+func formatArithValue(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// hasArithOperator returns true if the input contains an arithmetic operator
+// outside of function call parentheses and quoted strings. A leading sign,
+// e.g. of -1, is not considered to be an operator.
+func hasArithOperator(in string) bool {
+	return len(arithOperatorIndexes(in)) > 0
+}
+
+// arithOperatorIndexes returns the indexes of the arithmetic operators of the
+// input, as considered by hasArithOperator.
+func arithOperatorIndexes(in string) []int {
+	var indexes []int
+	// Whether each open parenthesis belongs to a function call, e.g. sum(x),
+	// or groups a sub-expression, e.g. (a+b).
+	var isCall []bool
+	var callDepth int
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case funcs.IsQuote(c):
+			if i = funcs.QuotedEnd(in, i); i < 0 {
+				return nil
+			}
+		case c == '(':
+			call := i > 0 && strings.IndexByte(arithOperators+"( ", in[i-1]) < 0
+			if call {
+				callDepth++
+			}
+			isCall = append(isCall, call)
+		case c == ')':
+			if len(isCall) > 0 {
+				if isCall[len(isCall)-1] {
+					callDepth--
+				}
+				isCall = isCall[:len(isCall)-1]
+			}
+		case callDepth == 0 && i > 0 && strings.IndexByte(arithOperators, c) >= 0:
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// joinArithTokens joins the tokens of arithmetic expressions which were split
+// at spaces or grouping parentheses, e.g. "sum(bytes)", "/", "count(req)".
+// A token is joined with its predecessor if either of them has a dangling
+// operator or parenthesis.
+func joinArithTokens(tokens []token) []token {
+	var joined []token
+	isOpen := func(t token) bool {
+		return t.isBareword && !t.quotesStripped && t.str != "" &&
+			(t.str == "(" || strings.IndexByte(arithOperators, t.str[len(t.str)-1]) >= 0)
+	}
+	isClosing := func(t token) bool {
+		return t.isBareword && !t.quotesStripped && t.str != "" &&
+			(t.str == ")" || strings.IndexByte(arithOperators, t.str[0]) >= 0)
+	}
+
+	for _, t := range tokens {
+		if len(joined) > 0 {
+			last := &joined[len(joined)-1]
+			// Never join the value of a 'set' clause with its '=', e.g. of
+			// "$foo = -1".
+			if last.str != "=" && (isOpen(*last) || isClosing(t)) {
+				last.str += " " + t.str
+				continue
+			}
+		}
+		joined = append(joined, t)
+	}
+	return joined
+}
+
+// arithParser is a recursive descent parser for arithmetic expressions:
+//
+//	EXPR := TERM [(+|-) TERM...]
+//	TERM := FACTOR [(*|/) FACTOR...]
+//	FACTOR := -FACTOR | (EXPR) | NUMBER | OPERAND
+type arithParser struct {
+	in          string
+	pos         int
+	makeOperand makeArithOperand
+}
+
+// parseArithExpr parses an arithmetic expression. Operands which aren't
+// numbers are created by the given function.
+func parseArithExpr(in string, makeOperand makeArithOperand) (*arithExpr, error) {
+	p := arithParser{in: in, makeOperand: makeOperand}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.in) {
+		return nil, p.errorf("unexpected '%c'", p.in[p.pos])
+	}
+	return e, nil
+}
+
+func (p *arithParser) errorf(format string, args ...any) error {
+	return errors.New(invalidQuery + fmt.Sprintf("Can't parse expression '%s': ", p.in) +
+		fmt.Sprintf(format, args...))
+}
+
+func (p *arithParser) skipSpaces() {
+	for p.pos < len(p.in) && p.in[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next non-space character, or 0 at the end of the input.
+func (p *arithParser) next() byte {
+	if p.skipSpaces(); p.pos < len(p.in) {
+		return p.in[p.pos]
+	}
+	return 0
+}
+
+func (p *arithParser) parseExpr() (*arithExpr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *arithParser) parseTerm() (*arithExpr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *arithParser) parseFactor() (*arithExpr, error) {
+	switch p.next() {
+	case 0:
+		return nil, p.errorf("unexpected end")
+	case '-':
+		p.pos++
+		e, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &arithExpr{op: 'n', left: e}, nil
+	case '(':
+		p.pos++
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return e, nil
+	}
+
+	operand := p.scanOperand()
+	if operand == "" {
+		return nil, p.errorf("unexpected '%c'", p.in[p.pos])
+	}
+	if f, err := strconv.ParseFloat(operand, 64); err == nil {
+		return &arithExpr{operand: arithNumber(f)}, nil
+	}
+	o, err := p.makeOperand(operand)
+	if err != nil {
+		return nil, err
+	}
+	return &arithExpr{operand: o}, nil
+}
+
+// scanOperand scans an operand up to the next operator, space or closing
+// parenthesis. Parentheses of function calls, e.g. of sum(bytes), and quoted
+// strings are part of the operand.
+func (p *arithParser) scanOperand() string {
+	start := p.pos
+	var depth int
+	for ; p.pos < len(p.in); p.pos++ {
+		switch c := p.in[p.pos]; {
+		case funcs.IsQuote(c):
+			if end := funcs.QuotedEnd(p.in, p.pos); end >= 0 {
+				p.pos = end
+			} else {
+				p.pos = len(p.in) - 1
+			}
+		case c == '(':
+			depth++
+		case c == ')' && depth == 0:
+			return p.in[start:p.pos]
+		case c == ')':
+			depth--
+		case depth == 0 && (c == ' ' || strings.IndexByte(arithOperators, c) >= 0):
+			return p.in[start:p.pos]
+		}
+	}
+	return p.in[start:]
+}
+
+// fieldOperand reads the numeric value of a field of a log line. It is NaN if
+// the field is missing or not a number.
+type fieldOperand string
+
+func (f fieldOperand) value(in any) float64 {
+	return parseArithValue(in.(map[string]string)[string(f)])
+}
+
+func (f fieldOperand) String() string { return string(f) }
+
+// callOperand is the numeric result of a function call for a log line, e.g.
+// len($line). It is NaN if a field of the call is absent.
+type callOperand struct {
+	call *funcs.Call
+}
+
+func (c callOperand) value(in any) float64 {
+	value, ok := c.call.Lookup(in.(map[string]string))
+	if !ok {
+		return math.NaN()
+	}
+	return parseArithValue(value)
+}
+
+func (c callOperand) String() string { return c.call.String() }
+
+// makeLineOperand creates the operand of a per line expression, e.g. of a
+// 'set' clause, which is either a function call or a field.
+func makeLineOperand(operand string) (arithOperand, error) {
+	if !funcs.IsCall(operand) {
+		return fieldOperand(operand), nil
+	}
+	call, err := funcs.NewCall(operand)
+	if err != nil {
+		return nil, errors.New(invalidQuery + err.Error())
+	}
+	return callOperand{call: call}, nil
+}
+
+// aggregationValues resolves the values of the aggregations of a 'select'
+// expression for a single result row.
+type aggregationValues func(sc *selectCondition) float64
+
+// aggregationOperand is the result of an aggregation, e.g. sum(bytes) of
+// sum(bytes)/count(req). It is evaluated after the results of all servers
+// were merged.
+type aggregationOperand struct {
+	sc selectCondition
+}
+
+func (a *aggregationOperand) value(in any) float64 {
+	return in.(aggregationValues)(&a.sc)
+}
+
+func (a *aggregationOperand) String() string { return a.sc.FieldStorage }
+
+func parseArithValue(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
@@ -0,0 +1,204 @@
+package mapr
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestArithExprEval(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]string{"bytes": "2048", "a": "3", "b": "4", "text": "foo"}
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"bytes / 1024", 2},
+		{"bytes/1024", 2},
+		{"a + b * 2", 11},
+		{"(a + b) * 2", 14},
+		{"a - b - 1", -2},
+		{"-a + 10", 7},
+		{"-(a - b)", 1},
+		{"2.5 * a", 7.5},
+	}
+	for _, tt := range tests {
+		expr, err := parseArithExpr(tt.expr, makeLineOperand)
+		if err != nil {
+			t.Errorf("Unable to parse expression %q: %v", tt.expr, err)
+			continue
+		}
+		if got := expr.eval(fields); got != tt.want {
+			t.Errorf("Expression %q evaluated to %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"a / 0", "text + 1", "missing * 2"} {
+		expr, err := parseArithExpr(in, makeLineOperand)
+		if err != nil {
+			t.Fatalf("Unable to parse expression %q: %v", in, err)
+		}
+		if got := expr.eval(fields); !math.IsNaN(got) {
+			t.Errorf("Expression %q evaluated to %v, want NaN", in, got)
+		}
+	}
+
+	for _, in := range []string{"a +", "(a + b", "a + b)", "a * / b", ""} {
+		if _, err := parseArithExpr(in, makeLineOperand); err == nil {
+			t.Errorf("Expected an error parsing expression %q", in)
+		}
+	}
+}
+
+func TestHasArithOperator(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]bool{
+		"bytes/1024":            true,
+		"sum(a)/count(b)":       true,
+		"(sum(a)+sum(b))":       true,
+		"-1":                    false,
+		"sum(a-b)":              false,
+		`md5sum("a+b")`:         false,
+		"foo":                   false,
+		"bucket($time, 5m) - 1": true,
+	} {
+		if got := hasArithOperator(in); got != want {
+			t.Errorf("hasArithOperator(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestSetClauseArithmetic(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select $kb,$total,$neg from stats " +
+		"set $kb = bytes / 1024, $total = ($kb + 1) * 2 $neg = -1 group by $kb")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if len(query.Set) != 3 {
+		t.Fatalf("Expected 3 set conditions, got %v", query.Set)
+	}
+
+	fields := map[string]string{"bytes": "4096"}
+	if err := query.SetClause(fields); err != nil {
+		t.Fatalf("SetClause failed: %v", err)
+	}
+	for field, want := range map[string]string{"$kb": "4", "$total": "10", "$neg": "-1"} {
+		if fields[field] != want {
+			t.Errorf("Got %s = %q, want %q", field, fields[field], want)
+		}
+	}
+
+	if !query.ParserFieldPlan().Needs("bytes") {
+		t.Errorf("Expected the parser field plan to include the expression's fields")
+	}
+}
+
+// TestSetClauseOperatorCharacters verifies how values containing operator
+// characters without spaces around them are resolved: a value with a field
+// operand, e.g. user-agent or bytes/1024, takes the field of that name if the
+// line has it and is an arithmetic expression otherwise, whereas a value of
+// numbers only, e.g. a date, is a field name or a literal.
+func TestSetClauseOperatorCharacters(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select $agent,$day,$double,$half,$kb from stats " +
+		"set $agent = user-agent, $day = 2021-10-02, $double = $day*2, " +
+		"$half = bytes(size)/2, $kb = bytes/1024 group by $agent")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	for i, want := range []fieldType{Arithmetic, Field, Arithmetic, Arithmetic, Arithmetic} {
+		if query.Set[i].rType != want {
+			t.Errorf("Got type %s for '%s', want %s", query.Set[i].rType, query.Set[i].rString, want)
+		}
+	}
+
+	fields := map[string]string{"user-agent": "curl/7.79.1", "2021-10-02": "1", "size": "1KB",
+		"bytes": "2048"}
+	if err := query.SetClause(fields); err != nil {
+		t.Fatalf("SetClause failed: %v", err)
+	}
+	for field, want := range map[string]string{
+		"$agent": "curl/7.79.1", "$day": "1", "$double": "2", "$half": "500", "$kb": "2",
+	} {
+		if fields[field] != want {
+			t.Errorf("Got %s = %q, want %q", field, fields[field], want)
+		}
+	}
+
+	fields = map[string]string{"user": "3", "agent": "1", "bytes/1024": "n/a"}
+	if err := query.SetClause(fields); err != nil {
+		t.Fatalf("SetClause failed: %v", err)
+	}
+	for field, want := range map[string]string{"$agent": "2", "$day": "2021-10-02", "$kb": "n/a"} {
+		if fields[field] != want {
+			t.Errorf("Got %s = %q, want %q", field, fields[field], want)
+		}
+	}
+	if _, ok := fields["$half"]; ok {
+		t.Errorf("Expected no $half without a size, got %q", fields["$half"])
+	}
+}
+
+func TestSelectArithmetic(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,sum(bytes) / count(req),sum(bytes),`foo-bar` " +
+		"group by path order by sum(bytes) / count(req)")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if len(query.Select) != 4 || query.Select[1].Operation != Expression ||
+		query.Select[3].Operation != Last {
+		t.Fatalf("Unexpected select conditions %v", query.Select)
+	}
+
+	var aggregations []string
+	for _, sc := range query.Aggregations() {
+		aggregations = append(aggregations, sc.FieldStorage)
+	}
+	want := "path,sum(bytes),count(req),foo-bar"
+	if strings.Join(aggregations, ",") != want {
+		t.Errorf("Got aggregations %v, want %s", aggregations, want)
+	}
+
+	group := NewGroupSet()
+	for path, requests := range map[string][]string{"/a": {"100", "300"}, "/b": {"50"}, "/c": nil} {
+		set := group.GetSet(path)
+		set.setString("path", path)
+		for _, bytes := range requests {
+			for _, sc := range query.Aggregations()[1:3] {
+				if err := set.Aggregate(sc.FieldStorage, sc.Operation, bytes, false); err != nil {
+					t.Fatalf("Aggregate failed: %v", err)
+				}
+			}
+		}
+	}
+
+	rows, _, err := group.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, row.groupKey+"="+row.values[1])
+	}
+	// Division by zero renders as NaN.
+	want = "/a=200.000000,/b=50.000000,/c=NaN"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got rows %v, want %s", got, want)
+	}
+
+	if query, err := NewQuery("select foo-bar"); err != nil || query.Select[0].Field != "foo-bar" {
+		t.Errorf("Expected field name with an operator, got %v, error: %v", query, err)
+	}
+	for _, queryStr := range []string{"select sum(bytes) / bytes", "select sum(bytes) / nosuchagg(bytes)"} {
+		if _, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected an error parsing query %q", queryStr)
+		}
+	}
+}
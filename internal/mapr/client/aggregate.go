@@ -67,12 +67,13 @@ func (a *Aggregate) Aggregate(message string) error {
 	set := a.group.GetSet(groupKey)
 	var addedSamples bool
 
-	for _, sc := range snapshot.Query.Select {
-		if val, ok := fields[sc.FieldStorage]; ok {
-			if err := set.Aggregate(sc.FieldStorage, sc.Operation, val, true); err != nil {
-				dlog.Client.Error(err)
-				continue
-			}
+	for _, sc := range snapshot.Query.Aggregations() {
+		ok, err := set.AggregateSerialized(sc.FieldStorage, sc.Operation, fields)
+		if err != nil {
+			dlog.Client.Error(err)
+			continue
+		}
+		if ok {
 			addedSamples = true
 		}
 	}
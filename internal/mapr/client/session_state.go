@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/mimecast/dtail/internal/config"
 	"github.com/mimecast/dtail/internal/mapr"
 )
 
@@ -30,7 +31,7 @@ type SessionState struct {
 func NewSessionState(query *mapr.Query) *SessionState {
 	return &SessionState{
 		query:     query,
-		global:    mapr.NewGlobalGroupSet(),
+		global:    newGlobalGroupSet(),
 		changedCh: make(chan struct{}, 1),
 	}
 }
@@ -63,7 +64,7 @@ func (s *SessionState) CommitQuery(rawQuery string, generation uint64) (*mapr.Qu
 	s.mu.Lock()
 	s.generation = generation
 	s.query = query
-	s.global = mapr.NewGlobalGroupSet()
+	s.global = newGlobalGroupSet()
 	s.lastResult = ""
 	s.mu.Unlock()
 
@@ -87,6 +88,15 @@ func (s *SessionState) CommitRenderedResult(generation uint64, result string) (c
 	return true, true
 }
 
+// newGlobalGroupSet returns a new global group set with the configured limits.
+func newGlobalGroupSet() *mapr.GlobalGroupSet {
+	global := mapr.NewGlobalGroupSet()
+	if config.Common != nil {
+		global.SetDistinctLimit(config.Common.MapreduceDistinctLimit)
+	}
+	return global
+}
+
 func (s *SessionState) notifyChange() {
 	select {
 	case s.changedCh <- struct{}{}:
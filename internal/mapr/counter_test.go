@@ -0,0 +1,65 @@
+package mapr
+
+import (
+	"testing"
+)
+
+func TestAggregateCounterResets(t *testing.T) {
+	t.Parallel()
+
+	set := NewAggregateSet()
+	// The counter is reset between 120 and 15, which counts as an increase
+	// of 15.
+	samples := []struct{ value, time float64 }{{100, 10}, {120, 20}, {15, 30}, {40, 40}}
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		set.AggregateCounter("delta(req)", Delta, prev.value, cur.value, prev.time, cur.time)
+		set.AggregateCounter("rate(req)", Rate, prev.value, cur.value, prev.time, cur.time)
+	}
+	if got := set.FValues["delta(req)"]; got != 60 {
+		t.Errorf("Got delta %v, want 60", got)
+	}
+	if got := set.Rate("rate(req)"); got != 2 {
+		t.Errorf("Got rate %v, want 2", got)
+	}
+	if _, ok := set.FValues["delta(req)"+rateFromSuffix]; ok {
+		t.Errorf("Expected no time span for the delta aggregation")
+	}
+	if got := NewAggregateSet().Rate("rate(req)"); got != 0 {
+		t.Errorf("Got rate %v without samples, want 0", got)
+	}
+}
+
+func TestGroupSetRateMerge(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select delta(req),rate(req) group by host")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if !query.ParserFieldPlan().Needs(FirstOrderField) {
+		t.Errorf("Expected the parser field plan to include %s", FirstOrderField)
+	}
+
+	// Two servers count 10 and 30 requests per second during the same
+	// period, which add up to 40 requests per second.
+	var servers []*AggregateSet
+	for _, perSecond := range []float64{10, 30} {
+		server := NewAggregateSet()
+		for _, sc := range query.Select {
+			server.AggregateCounter(sc.FieldStorage, sc.Operation, 0, perSecond*30, 100, 130)
+			server.AggregateCounter(sc.FieldStorage, sc.Operation, perSecond*30, perSecond*60, 130, 160)
+		}
+		server.Samples = 2
+		servers = append(servers, server)
+	}
+	group := mergeServerSets(t, query, servers)
+
+	rows, _, err := group.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	if got := rows[0].values; got[0] != "2400.000000" || got[1] != "40.000000" {
+		t.Errorf("Got delta and rate %v, want 2400 and 40", got)
+	}
+}
@@ -0,0 +1,223 @@
+package mapr
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// The relative accuracy of the quantiles estimated by a ddSketch, and the
+// maximum number of bins per sign. With 1% accuracy, 2048 bins cover roughly
+// the values from 1e-9 up to 1e9 before the smallest ones are collapsed.
+const (
+	ddRelativeAccuracy = 0.01
+	ddMaxBins          = 2048
+	ddEncodingVersion  = 1
+)
+
+var (
+	ddGamma    = (1 + ddRelativeAccuracy) / (1 - ddRelativeAccuracy)
+	ddLogGamma = math.Log(ddGamma)
+)
+
+// ddSketch is a mergeable sketch (DDSketch) estimating quantiles of the values
+// added to it with a relative error of ddRelativeAccuracy, used by the
+// quantile aggregations. Values are counted in logarithmically sized bins, so
+// that sketches of many servers can be merged exactly by adding up their bins.
+type ddSketch struct {
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+	count    uint64
+	min      float64
+	max      float64
+}
+
+func newDDSketch() *ddSketch {
+	return &ddSketch{
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// add a value to the sketch.
+func (d *ddSketch) add(value float64) {
+	switch {
+	case value > 0:
+		d.positive[ddKey(value)]++
+		ddCollapse(d.positive)
+	case value < 0:
+		d.negative[ddKey(-value)]++
+		ddCollapse(d.negative)
+	default:
+		d.zeros++
+	}
+	d.count++
+	d.min = math.Min(d.min, value)
+	d.max = math.Max(d.max, value)
+}
+
+// merge another sketch into this one.
+func (d *ddSketch) merge(other *ddSketch) {
+	for key, count := range other.positive {
+		d.positive[key] += count
+	}
+	for key, count := range other.negative {
+		d.negative[key] += count
+	}
+	ddCollapse(d.positive)
+	ddCollapse(d.negative)
+	d.zeros += other.zeros
+	d.count += other.count
+	d.min = math.Min(d.min, other.min)
+	d.max = math.Max(d.max, other.max)
+}
+
+// quantile returns the estimated value at the given quantile between 0 and 1.
+func (d *ddSketch) quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0
+	}
+	rank := q * float64(d.count-1)
+
+	var cumulative uint64
+	// Negative values: The larger the key, the smaller the value.
+	negativeKeys := ddSortedKeys(d.negative)
+	for i := len(negativeKeys) - 1; i >= 0; i-- {
+		cumulative += d.negative[negativeKeys[i]]
+		if float64(cumulative) > rank {
+			return d.clamp(-ddValue(negativeKeys[i]))
+		}
+	}
+	cumulative += d.zeros
+	if float64(cumulative) > rank {
+		return d.clamp(0)
+	}
+	for _, key := range ddSortedKeys(d.positive) {
+		cumulative += d.positive[key]
+		if float64(cumulative) > rank {
+			return d.clamp(ddValue(key))
+		}
+	}
+	return d.max
+}
+
+// clamp a bin value into the range of the values actually added.
+func (d *ddSketch) clamp(value float64) float64 {
+	return math.Max(d.min, math.Min(d.max, value))
+}
+
+// encode the sketch so that it can be sent over the wire.
+func (d *ddSketch) encode() string {
+	buf := make([]byte, 0, 32+(len(d.positive)+len(d.negative))*4)
+	buf = append(buf, ddEncodingVersion)
+	buf = binary.AppendUvarint(buf, d.zeros)
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(d.min))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(d.max))
+	for _, bins := range []map[int]uint64{d.positive, d.negative} {
+		buf = binary.AppendUvarint(buf, uint64(len(bins)))
+		for key, count := range bins {
+			buf = binary.AppendVarint(buf, int64(key))
+			buf = binary.AppendUvarint(buf, count)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// decodeDDSketch decodes a sketch previously encoded by encode.
+func decodeDDSketch(encoded string) (*ddSketch, error) {
+	errInvalid := errors.New("Unable to decode quantile sketch: invalid data")
+
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 1 || buf[0] != ddEncodingVersion {
+		return nil, errors.New("Unable to decode quantile sketch: unexpected version")
+	}
+	buf = buf[1:]
+
+	readUvarint := func() (uint64, bool) {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, false
+		}
+		buf = buf[n:]
+		return value, true
+	}
+
+	d := newDDSketch()
+	var ok bool
+	if d.zeros, ok = readUvarint(); !ok || len(buf) < 16 {
+		return nil, errInvalid
+	}
+	d.count = d.zeros
+	d.min = math.Float64frombits(binary.BigEndian.Uint64(buf))
+	d.max = math.Float64frombits(binary.BigEndian.Uint64(buf[8:]))
+	buf = buf[16:]
+
+	for _, bins := range []map[int]uint64{d.positive, d.negative} {
+		numBins, ok := readUvarint()
+		if !ok || numBins > ddMaxBins {
+			return nil, errInvalid
+		}
+		for i := uint64(0); i < numBins; i++ {
+			key, n := binary.Varint(buf)
+			if n <= 0 {
+				return nil, errInvalid
+			}
+			buf = buf[n:]
+			count, ok := readUvarint()
+			if !ok {
+				return nil, errInvalid
+			}
+			bins[int(key)] += count
+			d.count += count
+		}
+	}
+	if len(buf) != 0 {
+		return nil, errInvalid
+	}
+	return d, nil
+}
+
+// ddKey returns the bin of a positive value.
+func ddKey(value float64) int {
+	return int(math.Ceil(math.Log(value) / ddLogGamma))
+}
+
+// ddValue returns the representative value of a bin, which is within the
+// relative accuracy of all values of the bin.
+func ddValue(key int) float64 {
+	return 2 * math.Pow(ddGamma, float64(key)) / (ddGamma + 1)
+}
+
+// ddCollapse merges the bins of the smallest values into each other once
+// there are more than ddMaxBins. This bounds the memory used, while only the
+// accuracy of the lowest quantiles suffers. It collapses a few more bins than
+// required, so that it doesn't have to sort the bins again for every new one.
+func ddCollapse(bins map[int]uint64) {
+	if len(bins) <= ddMaxBins {
+		return
+	}
+	keys := ddSortedKeys(bins)
+	excess := len(keys) - ddMaxBins*7/8
+	target := keys[excess]
+	for _, key := range keys[:excess] {
+		bins[target] += bins[key]
+		delete(bins, key)
+	}
+}
+
+func ddSortedKeys(bins map[int]uint64) []int {
+	keys := make([]int, 0, len(bins))
+	for key := range bins {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
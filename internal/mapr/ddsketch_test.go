@@ -0,0 +1,166 @@
+package mapr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func assertQuantileEstimate(t *testing.T, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > math.Abs(want)*ddRelativeAccuracy+1e-9 {
+		t.Errorf("Estimated quantile value %f, want %f (+/- %.0f%%)", got, want,
+			ddRelativeAccuracy*100)
+	}
+}
+
+func TestDDSketchQuantile(t *testing.T) {
+	t.Parallel()
+
+	d := newDDSketch()
+	if got := d.quantile(0.5); got != 0 {
+		t.Errorf("Expected 0 for an empty sketch, got %f", got)
+	}
+
+	// Values 1..10000, so the value at quantile q is about q*10000.
+	for i := 1; i <= 10000; i++ {
+		d.add(float64(i))
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+		assertQuantileEstimate(t, d.quantile(q), 1+q*9999)
+	}
+	assertQuantileEstimate(t, d.quantile(0), 1)
+	assertQuantileEstimate(t, d.quantile(1), 10000)
+
+	// Negative values and zeros.
+	d = newDDSketch()
+	for i := -100; i <= 100; i++ {
+		d.add(float64(i))
+	}
+	assertQuantileEstimate(t, d.quantile(0), -100)
+	assertQuantileEstimate(t, d.quantile(0.25), -50)
+	assertQuantileEstimate(t, d.quantile(0.5), 0)
+	assertQuantileEstimate(t, d.quantile(0.75), 50)
+}
+
+func TestDDSketchBoundedBins(t *testing.T) {
+	t.Parallel()
+
+	d := newDDSketch()
+	for exp := -300.0; exp <= 300; exp += 0.01 {
+		d.add(math.Pow(10, exp))
+	}
+	if len(d.positive) > ddMaxBins {
+		t.Errorf("Expected at most %d bins, got %d", ddMaxBins, len(d.positive))
+	}
+	// The high quantiles are unaffected by collapsing the lowest bins.
+	assertQuantileEstimate(t, d.quantile(0.99), math.Pow(10, 294))
+}
+
+func TestDDSketchEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	for _, values := range [][]float64{nil, {0}, {-3.5, 0, 0, 12, 1e6}} {
+		d := newDDSketch()
+		for _, value := range values {
+			d.add(value)
+		}
+		decoded, err := decodeDDSketch(d.encode())
+		if err != nil {
+			t.Fatalf("Unable to decode sketch of %v: %v", values, err)
+		}
+		if decoded.count != d.count || decoded.zeros != d.zeros ||
+			decoded.min != d.min || decoded.max != d.max {
+			t.Errorf("Decoded sketch %+v differs from the original %+v", decoded, d)
+		}
+		for _, q := range []float64{0, 0.5, 1} {
+			if decoded.quantile(q) != d.quantile(q) {
+				t.Errorf("Decoded sketch of %v returns %f at quantile %f, want %f",
+					values, decoded.quantile(q), q, d.quantile(q))
+			}
+		}
+	}
+
+	for _, encoded := range []string{"", "not base64!", "AA==", "AQ=="} {
+		if _, err := decodeDDSketch(encoded); err == nil {
+			t.Errorf("Expected an error decoding sketch %q", encoded)
+		}
+	}
+}
+
+func TestQuantileSelectConditions(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,quantile(latency, 0.75),p99(latency) group by path")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	for i, want := range []float64{0.75, 0.99} {
+		sc := query.Select[i+1]
+		if sc.Operation != Quantile || sc.Field != "latency" || sc.quantile != want {
+			t.Errorf("Unexpected select condition %v with quantile %f", sc, sc.quantile)
+		}
+	}
+
+	for _, queryStr := range []string{
+		"select quantile(latency) group by path",
+		"select quantile(latency,1.5) group by path",
+		"select quantile(latency,high) group by path",
+	} {
+		if _, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected an error parsing query %q", queryStr)
+		}
+	}
+}
+
+func TestQuantileMergesAcrossServers(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,p99(latency),p50(latency) group by path")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// Each server sees every other latency of 1..1000, so no single server
+	// knows the fleet wide distribution.
+	group := NewGroupSet()
+	for offset := 1; offset <= 2; offset++ {
+		server := NewAggregateSet()
+		for latency := offset; latency <= 1000; latency += 2 {
+			for _, sc := range query.Select[1:] {
+				value := strconv.Itoa(latency)
+				if err := server.Aggregate(sc.FieldStorage, sc.Operation, value, false); err != nil {
+					t.Fatalf("Aggregate failed: %v", err)
+				}
+			}
+		}
+
+		// The client receives the sketches encoded, as sent by Serialize.
+		client := NewAggregateSet()
+		client.setString("path", "/api")
+		for _, sc := range query.Select[1:] {
+			encoded := server.quantiles[sc.FieldStorage].encode()
+			if err := client.Aggregate(sc.FieldStorage, sc.Operation, encoded, true); err != nil {
+				t.Fatalf("Client aggregate failed: %v", err)
+			}
+		}
+		if err := group.GetSet("/api").Merge(query, client); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+	}
+
+	rows, _, err := group.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Expected one row, got %d", len(rows))
+	}
+	for i, want := range []float64{990.01, 500.5} {
+		got, err := strconv.ParseFloat(rows[0].values[i+1], 64)
+		if err != nil {
+			t.Fatalf("Unable to parse result value: %v", err)
+		}
+		assertQuantileEstimate(t, got, want)
+	}
+}
@@ -8,7 +8,9 @@ const (
 	Field          fieldType = iota
 	String         fieldType = iota
 	Float          fieldType = iota
-	FunctionStack  fieldType = iota
+	FunctionCall   fieldType = iota
+	Arithmetic     fieldType = iota
+	List           fieldType = iota
 )
 
 func (w fieldType) String() string {
@@ -19,8 +21,12 @@ func (w fieldType) String() string {
 		return "String"
 	case Float:
 		return "Float"
-	case FunctionStack:
-		return "FunctionStack"
+	case FunctionCall:
+		return "FunctionCall"
+	case Arithmetic:
+		return "Arithmetic"
+	case List:
+		return "List"
 	default:
 		return "UndefFieldType"
 	}
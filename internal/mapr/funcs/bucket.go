@@ -0,0 +1,74 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// timeLayouts are the timestamp layouts understood by the time functions. They
+// cover the $time values produced by the built-in log format parsers.
+var timeLayouts = []string{
+	"0102-150405",     // DTail default log format
+	"20060102-150405", // DTail default log format with year
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700", // Common/combined access log format
+	time.Stamp,                   // Syslog (RFC 3164)
+}
+
+// ParseTime parses a timestamp in any of the layouts the time functions
+// understand, e.g. the $time of a log line. Numeric values are interpreted as
+// Unix epoch seconds.
+func ParseTime(value string) (time.Time, bool) {
+	t, _, ok := parseTime(value)
+	return t, ok
+}
+
+// parseTime parses a timestamp in any of the known layouts. Numeric values are
+// interpreted as Unix epoch seconds. It returns the layout used, which is
+// empty for epoch timestamps.
+func parseTime(value string) (time.Time, string, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, layout, true
+		}
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC(), "", true
+	}
+	return time.Time{}, "", false
+}
+
+// newBucket returns the callback of bucket(timestamp, width), which truncates a
+// timestamp to a multiple of the given width, e.g. bucket($time, 5m). The
+// result keeps the layout of the input timestamp, so buckets sort in time
+// order. Timestamps which can't be parsed result in an empty string.
+func newBucket(args []Argument) (CallbackFunc, error) {
+	if args[1].Type != LiteralArgument {
+		return nil, errors.New("bucket width must be a literal duration, e.g. 5m")
+	}
+	width, err := time.ParseDuration(args[1].Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket width: %w", err)
+	}
+	if width <= 0 {
+		return nil, fmt.Errorf("bucket width must be positive but is %s", width)
+	}
+
+	return func(args []string) string {
+		t, layout, ok := parseTime(args[0])
+		if !ok {
+			return ""
+		}
+		t = t.Truncate(width)
+		if layout == "" {
+			return strconv.FormatInt(t.Unix(), 10)
+		}
+		return t.Format(layout)
+	}, nil
+}
@@ -0,0 +1,48 @@
+package funcs
+
+import "testing"
+
+func TestBucket(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		input string
+		value string
+		want  string
+	}{
+		{input: "bucket($time, 5m)", value: "1002-071209", want: "1002-071000"},
+		{input: "bucket($time, 1h)", value: "1231-235959", want: "1231-230000"},
+		{input: "bucket($time, 5m)", value: "20211002-071209", want: "20211002-071000"},
+		{input: "bucket($time,1m)", value: "20211002-071209", want: "20211002-071200"},
+		{input: "bucket($time, 1h)", value: "2021-10-02T07:12:09.123Z", want: "2021-10-02T07:00:00Z"},
+		{input: "bucket($time, 15m)", value: "2021-10-02T07:44:09+02:00", want: "2021-10-02T07:30:00+02:00"},
+		{input: "bucket($time, 10s)", value: "02/Oct/2021:07:12:09 +0000", want: "02/Oct/2021:07:12:00 +0000"},
+		{input: "bucket($time, 1m)", value: "1633158729", want: "1633158720"},
+		{input: "bucket($time, 1m)", value: "not a timestamp", want: ""},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.input+"/"+tc.value, func(t *testing.T) {
+			t.Parallel()
+			call, err := NewCall(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error for input %q: %v", tc.input, err)
+			}
+			if got := call.Eval(map[string]string{"$time": tc.value}); got != tc.want {
+				t.Errorf("Eval(%q) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBucketInvalidWidth(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"bucket($time, 0s)", "bucket($time, -5m)",
+		"bucket($time, 5 minutes)", "bucket($time, width)"} {
+		if call, err := NewCall(input); err == nil {
+			t.Errorf("expected error for input %q but got none (call %v)", input, call)
+		}
+	}
+}
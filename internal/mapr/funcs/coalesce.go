@@ -0,0 +1,27 @@
+package funcs
+
+// coalesce implements coalesce(field, default), which returns the value of
+// the first argument which is present, e.g. coalesce(latency, 0) is 0 for the
+// log lines without a latency field. An empty value is present.
+func coalesce(c *Call, fields map[string]string) (string, bool) {
+	for _, arg := range c.Args {
+		if value, ok := arg.lookup(fields); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// lookup returns the value of the argument for the fields of a log line, or
+// false if the argument is a field which is absent.
+func (a Argument) lookup(fields map[string]string) (string, bool) {
+	switch a.Type {
+	case FieldArgument:
+		value, ok := fields[a.Value]
+		return value, ok
+	case CallArgument:
+		return a.call.Lookup(fields)
+	default:
+		return a.Value, true
+	}
+}
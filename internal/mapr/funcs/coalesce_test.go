@@ -0,0 +1,35 @@
+package funcs
+
+import "testing"
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]string{"latency": "12", "empty": ""}
+	for input, want := range map[string]string{
+		"coalesce(latency, 0)":                "12",
+		"coalesce(nosuchfield, 0)":            "0",
+		"coalesce(empty, 0)":                  "",
+		`coalesce(nosuchfield, "n/a")`:        "n/a",
+		"coalesce(foo, coalesce(bar, 1))":     "1",
+		"upper(coalesce(nosuchfield, \"x\"))": "X",
+	} {
+		call, err := NewCall(input)
+		if err != nil {
+			t.Fatalf("Unexpected error for input %q: %v", input, err)
+		}
+		if got, ok := call.Lookup(fields); !ok || got != want {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", input, got, ok, want)
+		}
+	}
+
+	call, err := NewCall("coalesce(foo, bar)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, ok := call.Lookup(fields); ok {
+		t.Errorf("Expected no result without any present argument, got %q", got)
+	}
+
+	testMalformedCalls(t, []string{"coalesce(foo)", "coalesce(a, b, c)"})
+}
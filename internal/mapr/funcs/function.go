@@ -2,86 +2,312 @@ package funcs
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
-// CallbackFunc is a function which can be executed by the mapreduce engine
-type CallbackFunc func(text string) string
+// CallbackFunc is a function which can be executed by the mapreduce engine.
+// It receives the already evaluated values of all call arguments.
+type CallbackFunc func(args []string) string
 
-// Function embeddes the function name to the callback function
+// Function describes a DTail function which can be called from a mapreduce
+// query.
 type Function struct {
-	// Name of the callback function
+	// Name of the function as used in the query.
 	Name string
+	// Minimum and maximum number of arguments accepted.
+	MinArgs int
+	MaxArgs int
+	// newCallback is invoked once per call site when the query is parsed. It
+	// can validate and pre-process literal arguments (e.g. parse a duration
+	// only once) and returns the Go-callback to run for every log line.
+	newCallback func(args []Argument) (CallbackFunc, error)
+	// lookup, if set, is run instead of the callback by functions which need
+	// to know whether the fields of their arguments are present, e.g.
+	// coalesce. It returns false if the result is absent.
+	lookup func(c *Call, fields map[string]string) (string, bool)
+}
+
+// ArgumentType determines how an argument of a function call is evaluated.
+type ArgumentType int
+
+// The possible argument types.
+const (
+	// FieldArgument reads the value of a field, e.g. $time or bytes.
+	FieldArgument ArgumentType = iota
+	// LiteralArgument is a constant, e.g. "foo", 42 or 5m.
+	LiteralArgument ArgumentType = iota
+	// CallArgument is the result of a nested function call.
+	CallArgument ArgumentType = iota
+)
+
+// Argument of a function call.
+type Argument struct {
+	Type ArgumentType
+	// Field name or literal value, depending on the argument type.
+	Value string
+	call  *Call
+}
+
+// String representation of the argument.
+func (a Argument) String() string {
+	switch a.Type {
+	case LiteralArgument:
+		return fmt.Sprintf("%q", a.Value)
+	case CallArgument:
+		return a.call.String()
+	default:
+		return a.Value
+	}
+}
+
+// Call is a parsed function call, e.g. bucket($time, 5m). Arguments can be
+// fields, literals or nested function calls.
+type Call struct {
+	Name string
+	Args []Argument
 	// The Go-callback function to call for this DTail function.
-	call CallbackFunc
+	call   CallbackFunc
+	lookup func(c *Call, fields map[string]string) (string, bool)
 }
 
-// FunctionStack is a list of functions stacked each other
-type FunctionStack []Function
+// NewCall parses the input string, e.g. foo(bar($line), "arg") and returns the
+// corresponding function call. It returns an error for malformed inputs such
+// as unbalanced parentheses (e.g. "foo(", "foo(bar)baz"), for unknown
+// functions and for calls with an invalid number of arguments.
+func NewCall(in string) (*Call, error) {
+	in = strings.TrimSpace(in)
+	index := strings.IndexByte(in, '(')
+	if index <= 0 || !strings.HasSuffix(in, ")") {
+		return nil, fmt.Errorf("unable to parse function '%s'", in)
+	}
+	if err := validateParenBalance(in[index:], in); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(in[:index])
+	function, err := lookupFunction(name)
+	if err != nil {
+		return nil, err
+	}
 
-// NewFunctionStack parses the input string, e.g. foo(bar("arg")) and returns
-// a corresponding function stack. It returns an error for malformed inputs
-// such as unbalanced parentheses (e.g. "foo(", "foo(bar)baz").
-func NewFunctionStack(in string) (FunctionStack, string, error) {
-	var fs FunctionStack
+	argStrs, err := splitArguments(in[index+1:len(in)-1], in)
+	if err != nil {
+		return nil, err
+	}
+	if len(argStrs) < function.MinArgs || len(argStrs) > function.MaxArgs {
+		return nil, fmt.Errorf("function '%s' expects %s but got %d in '%s'",
+			name, function.arity(), len(argStrs), in)
+	}
 
-	aux := in
-	for strings.HasSuffix(aux, ")") {
-		index := strings.Index(aux, "(")
-		if index <= 0 {
-			return fs, "", fmt.Errorf("unable to parse function '%s' at '%s'", in, aux)
+	c := Call{Name: name}
+	for _, argStr := range argStrs {
+		arg, err := newArgument(argStr, in)
+		if err != nil {
+			return nil, err
 		}
-		name := aux[0:index]
+		c.Args = append(c.Args, arg)
+	}
 
-		call, err := lookupCallback(name)
+	if function.lookup != nil {
+		c.lookup = function.lookup
+		return &c, nil
+	}
+	if c.call, err = function.newCallback(c.Args); err != nil {
+		return nil, fmt.Errorf("function '%s': %w", in, err)
+	}
+	return &c, nil
+}
+
+// IsCall returns true if the input looks like a call of a known function, e.g.
+// md5sum($line). It does not validate the arguments.
+func IsCall(in string) bool {
+	index := strings.IndexByte(in, '(')
+	if index <= 0 || !strings.HasSuffix(in, ")") {
+		return false
+	}
+	_, err := lookupFunction(strings.TrimSpace(in[:index]))
+	return err == nil
+}
+
+func newArgument(argStr, original string) (Argument, error) {
+	switch {
+	case argStr == "":
+		return Argument{}, fmt.Errorf("malformed function expression %q: empty argument", original)
+	case IsQuote(argStr[0]):
+		value, ok := Unquote(argStr)
+		if !ok {
+			return Argument{}, fmt.Errorf("malformed function expression %q: unexpected "+
+				"argument '%s'", original, argStr)
+		}
+		return Argument{Type: LiteralArgument, Value: value}, nil
+	case strings.HasSuffix(argStr, ")"):
+		call, err := NewCall(argStr)
 		if err != nil {
-			return fs, "", err
+			return Argument{}, err
 		}
-		fs = append(fs, Function{name, call})
-		// Strip the outer function name and its enclosing parens, leaving
-		// only the argument expression for the next iteration.
-		aux = aux[index+1 : len(aux)-1]
+		return Argument{Type: CallArgument, Value: argStr, call: call}, nil
+	case strings.ContainsAny(argStr, "()\"'"):
+		return Argument{}, fmt.Errorf("malformed function expression %q: unexpected "+
+			"argument '%s'", original, argStr)
+	case isLiteral(argStr):
+		return Argument{Type: LiteralArgument, Value: argStr}, nil
+	default:
+		return Argument{Type: FieldArgument, Value: argStr}, nil
+	}
+}
+
+// isLiteral determines whether an unquoted argument is a literal, such as a
+// number (42, -1.5) or a duration (5m), rather than a field name.
+func isLiteral(argStr string) bool {
+	switch c := argStr[0]; {
+	case '0' <= c && c <= '9':
+		return true
+	case c == '-' || c == '+' || c == '.':
+		return len(argStr) > 1
+	default:
+		return false
+	}
+}
+
+// splitArguments splits the argument list of a call at all top level commas.
+// Commas within nested calls or within quoted strings are preserved.
+func splitArguments(argList, original string) ([]string, error) {
+	if strings.TrimSpace(argList) == "" {
+		return nil, nil
 	}
 
-	// Validate that no unbalanced parentheses remain in the argument string.
-	// Inputs like "foo(bar)baz" leave "bar)baz" after stripping, and inputs
-	// ending with "(" (no closing ")") are accepted as plain field literals
-	// without this check — both produce silently wrong behavior.
-	if err := validateParenBalance(aux, in); err != nil {
-		return fs, "", err
+	var args []string
+	var depth, start int
+	for i := 0; i < len(argList); i++ {
+		switch c := argList[i]; {
+		case IsQuote(c):
+			if i = QuotedEnd(argList, i); i < 0 {
+				return nil, fmt.Errorf("malformed function expression %q: unterminated quote", original)
+			}
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(argList[start:i]))
+			start = i + 1
+		}
 	}
+	return append(args, strings.TrimSpace(argList[start:])), nil
+}
+
+// functions is the registry of all functions which can be called from a
+// mapreduce query, by their name.
+var functions = make(map[string]Function)
 
-	return fs, aux, nil
+func init() {
+	register(
+		unary("md5sum", Md5Sum),
+		unary("maskdigits", MaskDigits),
+		Function{Name: "bucket", MinArgs: 2, MaxArgs: 2, newCallback: newBucket},
+		// String functions
+		unary("lower", strings.ToLower),
+		unary("upper", strings.ToUpper),
+		Function{Name: "substr", MinArgs: 2, MaxArgs: 3, newCallback: newSubstr},
+		Function{Name: "split", MinArgs: 3, MaxArgs: 3, newCallback: newSplit},
+		Function{Name: "regex_extract", MinArgs: 3, MaxArgs: 3, newCallback: newRegexExtract},
+		Function{Name: "replace", MinArgs: 3, MaxArgs: 3, newCallback: newReplace},
+		// Numeric functions
+		Function{Name: "round", MinArgs: 1, MaxArgs: 2, newCallback: newRound},
+		unary("floor", Floor),
+		unary("abs", Abs),
+		unary("toint", ToInt),
+		// Time functions
+		Function{Name: "strftime", MinArgs: 2, MaxArgs: 2, newCallback: newStrftime},
+		Function{Name: "parse_duration", MinArgs: 1, MaxArgs: 2, newCallback: newParseDuration},
+		Function{Name: "duration", MinArgs: 1, MaxArgs: 2, newCallback: newParseDuration},
+		unary("bytes", Bytes),
+		// JSON functions
+		Function{Name: "json_get", MinArgs: 2, MaxArgs: 2, newCallback: newJSONGet},
+		// Missing value functions
+		Function{Name: "coalesce", MinArgs: 2, MaxArgs: 2, lookup: coalesce},
+	)
 }
 
-// lookupCallback maps a function name to its CallbackFunc implementation.
+// register adds functions to the registry, replacing any function of the
+// same name.
+func register(fns ...Function) {
+	for _, fn := range fns {
+		functions[fn.Name] = fn
+	}
+}
+
+// lookupFunction maps a function name to its Function implementation.
 // It returns an error for unrecognised names so callers get a clear message.
-func lookupCallback(name string) (CallbackFunc, error) {
-	switch name {
-	case "md5sum":
-		return Md5Sum, nil
-	case "maskdigits":
-		return MaskDigits, nil
+func lookupFunction(name string) (Function, error) {
+	function, ok := functions[name]
+	if !ok {
+		return Function{}, fmt.Errorf("unknown function '%s'", name)
+	}
+	return function, nil
+}
+
+// literalInt returns the value of an integer literal argument, e.g. the
+// length of substr($line, 0, 10).
+func literalInt(args []Argument, i int, what string) (int, error) {
+	if args[i].Type != LiteralArgument {
+		return 0, fmt.Errorf("%s must be a literal integer", what)
+	}
+	n, err := strconv.Atoi(args[i].Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a literal integer: %w", what, err)
+	}
+	return n, nil
+}
+
+// unary wraps a simple single argument string function.
+func unary(name string, fn func(string) string) Function {
+	return Function{
+		Name:    name,
+		MinArgs: 1,
+		MaxArgs: 1,
+		newCallback: func([]Argument) (CallbackFunc, error) {
+			return func(args []string) string {
+				return fn(args[0])
+			}, nil
+		},
+	}
+}
+
+func (f Function) arity() string {
+	switch {
+	case f.MinArgs == f.MaxArgs && f.MinArgs == 1:
+		return "1 argument"
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("%d arguments", f.MinArgs)
 	default:
-		var zero CallbackFunc
-		return zero, fmt.Errorf("unknown function '%s'", name)
+		return fmt.Sprintf("%d to %d arguments", f.MinArgs, f.MaxArgs)
 	}
 }
 
-// validateParenBalance checks that the remaining argument string contains no
-// unbalanced parentheses. A negative depth means a stray ')' was found; a
-// non-zero depth after the loop means an unclosed '(' was found. The original
-// full expression is included in the error message for context.
+// validateParenBalance checks that the argument string contains no unbalanced
+// parentheses outside of quoted strings. A negative depth means a stray ')'
+// was found; a non-zero depth after the loop means an unclosed '(' was found.
+// The original full expression is included in the error message for context.
 func validateParenBalance(aux, original string) error {
 	depth := 0
-	for _, r := range aux {
-		switch r {
-		case '(':
+	for i := 0; i < len(aux); i++ {
+		switch c := aux[i]; {
+		case IsQuote(c):
+			if end := QuotedEnd(aux, i); end >= 0 {
+				i = end
+			} else {
+				// Reported as an unterminated quote by splitArguments.
+				i = len(aux) - 1
+			}
+			continue
+		case c == '(':
 			depth++
-		case ')':
+		case c == ')':
 			depth--
 		}
-		if depth < 0 {
+		if depth < 0 || (depth == 0 && i < len(aux)-1 && aux[i] == ')') {
 			return fmt.Errorf("malformed function expression %q: unexpected ')' in argument", original)
 		}
 	}
@@ -91,12 +317,72 @@ func validateParenBalance(aux, original string) error {
 	return nil
 }
 
-// Call the function stack.
-func (fs FunctionStack) Call(str string) string {
-	for i := len(fs) - 1; i >= 0; i-- {
-		//dlog.Common.Debug("Call", fs[i].Name, str)
-		str = fs[i].call(str)
-		//dlog.Common.Debug("Call.result", fs[i].Name, str)
+// Fields returns the names of all fields read by the call, including the
+// fields read by nested calls.
+func (c *Call) Fields() []string {
+	var fields []string
+	for _, arg := range c.Args {
+		switch arg.Type {
+		case FieldArgument:
+			fields = append(fields, arg.Value)
+		case CallArgument:
+			fields = append(fields, arg.call.Fields()...)
+		}
+	}
+	return fields
+}
+
+// Eval evaluates the call for the fields of a log line. A field argument which
+// is not present in the fields is passed as its name, so that e.g.
+// md5sum(foo) hashes the string "foo" if there is no field foo.
+func (c *Call) Eval(fields map[string]string) string {
+	if c.lookup != nil {
+		value, _ := c.lookup(c, fields)
+		return value
+	}
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		switch arg.Type {
+		case FieldArgument:
+			value, ok := fields[arg.Value]
+			if !ok {
+				value = arg.Value
+			}
+			args[i] = value
+		case CallArgument:
+			args[i] = arg.call.Eval(fields)
+		default:
+			args[i] = arg.Value
+		}
+	}
+	return c.call(args)
+}
+
+// Lookup evaluates the call like Eval, but returns false if the result is
+// absent: if a field argument, also one of a nested call, is not present in
+// the fields, e.g. of len(foo) without a field foo, or if a function which
+// looks up its arguments itself has no result, e.g. coalesce(foo, bar) if
+// neither foo nor bar is present.
+func (c *Call) Lookup(fields map[string]string) (string, bool) {
+	if c.lookup != nil {
+		return c.lookup(c, fields)
+	}
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		value, ok := arg.lookup(fields)
+		if !ok {
+			return "", false
+		}
+		args[i] = value
+	}
+	return c.call(args), true
+}
+
+// String representation of the call.
+func (c *Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, arg.String())
 	}
-	return str
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(args, ","))
 }
@@ -1,13 +1,16 @@
 package funcs
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
-func TestFunctionStackValid(t *testing.T) {
+func TestCallValid(t *testing.T) {
 	t.Parallel()
 
 	type want struct {
-		arg string
-		// result of calling the returned function stack on the original input
+		fields []string
+		// result of evaluating the call with the original input as $line
 		callResult string
 	}
 
@@ -17,21 +20,38 @@ func TestFunctionStackValid(t *testing.T) {
 	}{
 		{
 			input: "md5sum($line)",
-			want:  want{arg: "$line", callResult: "b38699013d79e50d9d122433753959c1"},
+			want:  want{fields: []string{"$line"}, callResult: "b38699013d79e50d9d122433753959c1"},
 		},
 		{
 			input: "maskdigits(md5sum(maskdigits($line)))",
-			want:  want{arg: "$line", callResult: ".fac.bbe..bb.........d...a.c..b."},
+			want:  want{fields: []string{"$line"}, callResult: ".fac.bbe..bb.........d...a.c..b."},
 		},
 		{
-			// An argument containing nested parens that are balanced is valid.
 			input: "md5sum($foo)",
-			want:  want{arg: "$foo"},
+			want:  want{fields: []string{"$foo"}},
 		},
 		{
-			// Plain field with no function wrapper is a degenerate stack (empty).
-			input: "$line",
-			want:  want{arg: "$line"},
+			// A field which is not present is passed as its name.
+			input: "maskdigits(foo42)",
+			want:  want{fields: []string{"foo42"}, callResult: "foo.."},
+		},
+		{
+			input: "bucket($line, 5m)",
+			want:  want{fields: []string{"$line"}},
+		},
+		{
+			// Commas within quoted literals do not separate arguments.
+			input: "md5sum(\"a,b\")",
+			want:  want{callResult: "b345e1dc09f20fdefdea469f09167892"},
+		},
+		{
+			input: "md5sum('a,b')",
+			want:  want{callResult: "b345e1dc09f20fdefdea469f09167892"},
+		},
+		{
+			// Escaped quotes within quoted literals.
+			input: `replace("say \"hi\"", "\"", '\'')`,
+			want:  want{callResult: "say 'hi'"},
 		},
 	}
 
@@ -39,27 +59,27 @@ func TestFunctionStackValid(t *testing.T) {
 		tc := tc
 		t.Run(tc.input, func(t *testing.T) {
 			t.Parallel()
-			fs, arg, err := NewFunctionStack(tc.input)
+			call, err := NewCall(tc.input)
 			if err != nil {
-				t.Fatalf("unexpected error for input %q: %v (stack %v)", tc.input, err, fs)
+				t.Fatalf("unexpected error for input %q: %v", tc.input, err)
 			}
-			if arg != tc.want.arg {
-				t.Errorf("arg: got %q, want %q", arg, tc.want.arg)
+			if got := call.Fields(); strings.Join(got, ",") != strings.Join(tc.want.fields, ",") {
+				t.Errorf("fields: got %q, want %q", got, tc.want.fields)
 			}
 			if tc.want.callResult != "" {
-				got := fs.Call(tc.input)
+				got := call.Eval(map[string]string{"$line": tc.input})
 				if got != tc.want.callResult {
-					t.Errorf("Call(%q) = %q, want %q", tc.input, got, tc.want.callResult)
+					t.Errorf("Eval(%q) = %q, want %q", tc.input, got, tc.want.callResult)
 				}
 			}
 		})
 	}
 }
 
-// TestFunctionStackMalformed verifies that NewFunctionStack rejects expressions
-// that are structurally invalid. Before the fix, several of these were silently
+// TestCallMalformed verifies that NewCall rejects expressions that are
+// structurally invalid. Before the fix, several of these were silently
 // accepted and produced wrong results.
-func TestFunctionStackMalformed(t *testing.T) {
+func TestCallMalformed(t *testing.T) {
 	t.Parallel()
 
 	cases := []string{
@@ -77,16 +97,98 @@ func TestFunctionStackMalformed(t *testing.T) {
 		// Empty outer call — the name portion is empty (index == 0) which
 		// is caught by the existing index <= 0 guard.
 		"()",
+		// Plain field with no function wrapper.
+		"$line",
+		// Unknown function and wrong number of arguments.
+		"nosuchfunction($line)",
+		"md5sum($line, $line)",
+		"md5sum()",
+		"bucket($time)",
+		// Empty argument and unterminated quote.
+		"bucket($time,)",
+		"md5sum(\"foo)",
+		"md5sum('foo)",
 	}
 
 	for _, input := range cases {
 		input := input
 		t.Run(input, func(t *testing.T) {
 			t.Parallel()
-			fs, _, err := NewFunctionStack(input)
+			call, err := NewCall(input)
 			if err == nil {
-				t.Errorf("expected error for malformed input %q but got none (stack %v)", input, fs)
+				t.Errorf("expected error for malformed input %q but got none (call %v)", input, call)
+			}
+		})
+	}
+}
+
+// callCase is a function call evaluated for the given $line value.
+type callCase struct {
+	input string
+	line  string
+	want  string
+}
+
+func testCalls(t *testing.T, cases []callCase) {
+	t.Helper()
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.input+"/"+tc.line, func(t *testing.T) {
+			t.Parallel()
+			call, err := NewCall(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error for input %q: %v", tc.input, err)
+			}
+			if got := call.Eval(map[string]string{"$line": tc.line}); got != tc.want {
+				t.Errorf("Eval(%q) with $line %q = %q, want %q", tc.input, tc.line, got, tc.want)
 			}
 		})
 	}
 }
+
+func testMalformedCalls(t *testing.T, inputs []string) {
+	t.Helper()
+	for _, input := range inputs {
+		if call, err := NewCall(input); err == nil {
+			t.Errorf("expected error for malformed input %q but got none (call %v)", input, call)
+		}
+	}
+}
+
+func TestCallLookupAbsentField(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]string{"foo": "abc", "$time": "20211002-071143"}
+	for input, want := range map[string]string{
+		"upper(foo)":               "ABC",
+		"bucket($time,1m)":         "20211002-071100",
+		"upper(coalesce(bar,foo))": "ABC",
+	} {
+		call, err := NewCall(input)
+		if err != nil {
+			t.Fatalf("Unexpected error for input %q: %v", input, err)
+		}
+		if got, ok := call.Lookup(fields); !ok || got != want {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", input, got, ok, want)
+		}
+	}
+
+	// A call, or a nested call, with an absent field has no result, whereas
+	// Eval passes the name of the field.
+	for _, input := range []string{"upper(bar)", "lower(upper(bar))", "bucket(bar,1m)"} {
+		call, err := NewCall(input)
+		if err != nil {
+			t.Fatalf("Unexpected error for input %q: %v", input, err)
+		}
+		if got, ok := call.Lookup(fields); ok {
+			t.Errorf("Expected no result of %q without a field bar, got %q", input, got)
+		}
+	}
+	call, err := NewCall("upper(bar)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := call.Eval(fields); got != "BAR" {
+		t.Errorf("Eval(upper(bar)) = %q, want BAR", got)
+	}
+}
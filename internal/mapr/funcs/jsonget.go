@@ -0,0 +1,61 @@
+package funcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// newJSONGet returns the callback of json_get(json, path), which returns the
+// value at a dot separated path of a JSON document, e.g.
+// json_get($line, "request.headers.host"). Array elements are addressed by
+// their index, e.g. "items.0.id". Strings are returned without quotes, objects
+// and arrays as compact JSON. Invalid documents, missing paths and null
+// values result in an empty string.
+func newJSONGet([]Argument) (CallbackFunc, error) {
+	return func(args []string) string {
+		decoder := json.NewDecoder(strings.NewReader(args[0]))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return ""
+		}
+
+		if args[1] != "" {
+			for _, key := range strings.Split(args[1], ".") {
+				switch v := value.(type) {
+				case map[string]any:
+					value = v[key]
+				case []any:
+					i, err := strconv.Atoi(key)
+					if err != nil || i < 0 || i >= len(v) {
+						return ""
+					}
+					value = v[i]
+				default:
+					return ""
+				}
+			}
+		}
+
+		switch v := value.(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		case bool:
+			return strconv.FormatBool(v)
+		default:
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(v); err != nil {
+				return ""
+			}
+			return strings.TrimSuffix(buf.String(), "\n")
+		}
+	}, nil
+}
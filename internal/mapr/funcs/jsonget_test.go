@@ -0,0 +1,27 @@
+package funcs
+
+import "testing"
+
+func TestJSONGet(t *testing.T) {
+	t.Parallel()
+
+	doc := `{"user":{"id":42,"name":"paul","admin":false},"tags":["a","b"],` +
+		`"ratio":1e-3,"none":null,"html":"<b>"}`
+
+	testCalls(t, []callCase{
+		{input: `json_get($line, "user.name")`, line: doc, want: "paul"},
+		{input: `json_get($line, "user.id")`, line: doc, want: "42"},
+		{input: `json_get($line, "user.admin")`, line: doc, want: "false"},
+		{input: `json_get($line, "ratio")`, line: doc, want: "1e-3"},
+		{input: `json_get($line, "tags.1")`, line: doc, want: "b"},
+		{input: `json_get($line, "tags")`, line: doc, want: `["a","b"]`},
+		{input: `json_get($line, "html")`, line: doc, want: "<b>"},
+		{input: `json_get($line, "none")`, line: doc, want: ""},
+		{input: `json_get($line, "user.missing")`, line: doc, want: ""},
+		{input: `json_get($line, "tags.5")`, line: doc, want: ""},
+		{input: `json_get($line, "user.name.first")`, line: doc, want: ""},
+		{input: `json_get($line, "user")`, line: "not json", want: ""},
+	})
+
+	testMalformedCalls(t, []string{`json_get($line)`})
+}
@@ -0,0 +1,65 @@
+package funcs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// The numeric functions return an empty string for inputs which aren't
+// numbers, like the time functions do for invalid timestamps.
+
+// newRound returns the callback of round(number[, digits]), which rounds a
+// number half away from zero to the given number of decimal digits (0 by
+// default).
+func newRound(args []Argument) (CallbackFunc, error) {
+	var digits int
+	if len(args) > 1 {
+		var err error
+		if digits, err = literalInt(args, 1, "round digits"); err != nil {
+			return nil, err
+		}
+		if digits < 0 {
+			return nil, fmt.Errorf("round digits must not be negative but is %d", digits)
+		}
+	}
+	scale := math.Pow(10, float64(digits))
+
+	return func(args []string) string {
+		f, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatFloat(math.Round(f*scale)/scale, 'f', digits, 64)
+	}, nil
+}
+
+// Floor returns the greatest integer value less than or equal to a number.
+func Floor(input string) string {
+	return applyFloat(input, math.Floor)
+}
+
+// Abs returns the absolute value of a number.
+func Abs(input string) string {
+	return applyFloat(input, math.Abs)
+}
+
+// ToInt converts a number to an integer, truncating any decimal digits.
+func ToInt(input string) string {
+	if _, err := strconv.ParseInt(input, 10, 64); err == nil {
+		return input
+	}
+	f, err := strconv.ParseFloat(input, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return ""
+	}
+	return strconv.FormatInt(int64(f), 10)
+}
+
+func applyFloat(input string, fn func(float64) float64) string {
+	f, err := strconv.ParseFloat(input, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatFloat(fn(f), 'f', -1, 64)
+}
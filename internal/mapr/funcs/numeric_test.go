@@ -0,0 +1,28 @@
+package funcs
+
+import "testing"
+
+func TestNumericFunctions(t *testing.T) {
+	t.Parallel()
+
+	testCalls(t, []callCase{
+		{input: "round($line)", line: "2.5", want: "3"},
+		{input: "round($line)", line: "-2.5", want: "-3"},
+		{input: "round($line, 2)", line: "3.14159", want: "3.14"},
+		{input: "round($line)", line: "abc", want: ""},
+		{input: "floor($line)", line: "2.7", want: "2"},
+		{input: "floor($line)", line: "-2.2", want: "-3"},
+		{input: "abs($line)", line: "-42.5", want: "42.5"},
+		{input: "toint($line)", line: "12.9", want: "12"},
+		{input: "toint($line)", line: "-12.9", want: "-12"},
+		{input: "toint($line)", line: "9007199254740993", want: "9007199254740993"},
+		{input: "toint($line)", line: "", want: ""},
+	})
+
+	testMalformedCalls(t, []string{
+		"round()",
+		"round($line, $digits)",
+		"round($line, -1)",
+		"abs($line, 1)",
+	})
+}
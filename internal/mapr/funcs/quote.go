@@ -0,0 +1,60 @@
+package funcs
+
+import "strings"
+
+// IsQuote returns true if the character starts a quoted literal, which can be
+// enclosed in double or single quotes.
+func IsQuote(c byte) bool {
+	return c == '"' || c == '\''
+}
+
+// QuotedEnd returns the index of the quote closing the quoted literal which
+// starts at in[start], or -1 if the literal is unterminated. A backslash
+// escapes the following character, so e.g. \" doesn't close the literal.
+func QuotedEnd(in string, start int) int {
+	quote := in[start]
+	for i := start + 1; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// Unquote returns the value of a quoted literal, e.g. say "hi" for
+// "say \"hi\"". The escape sequences \\, \n, \t and a backslash followed by
+// the quote are replaced. Any other backslash is kept as it is, so that e.g.
+// the regex "\d+" needs no escaping.
+func Unquote(in string) (string, bool) {
+	if len(in) < 2 || !IsQuote(in[0]) || QuotedEnd(in, 0) != len(in)-1 {
+		return "", false
+	}
+	quote, body := in[0], in[1:len(in)-1]
+	if strings.IndexByte(body, '\\') < 0 {
+		return body, true
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			sb.WriteByte(body[i])
+			continue
+		}
+		switch next := body[i+1]; next {
+		case '\\', quote:
+			sb.WriteByte(next)
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		default:
+			sb.WriteByte('\\')
+			continue
+		}
+		i++
+	}
+	return sb.String(), true
+}
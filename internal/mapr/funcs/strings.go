@@ -0,0 +1,107 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// newSubstr returns the callback of substr(text, start[, length]), which
+// returns length characters of the text beginning at the zero based start.
+// Without a length, it returns the rest of the text.
+func newSubstr(args []Argument) (CallbackFunc, error) {
+	start, err := literalInt(args, 1, "substr start")
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("substr start must not be negative but is %d", start)
+	}
+	length := -1
+	if len(args) > 2 {
+		if length, err = literalInt(args, 2, "substr length"); err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("substr length must not be negative but is %d", length)
+		}
+	}
+
+	return func(args []string) string {
+		runes := []rune(args[0])
+		if start >= len(runes) {
+			return ""
+		}
+		runes = runes[start:]
+		if length >= 0 && length < len(runes) {
+			runes = runes[:length]
+		}
+		return string(runes)
+	}, nil
+}
+
+// newSplit returns the callback of split(text, separator, n), which splits the
+// text at every separator and returns the zero based n-th part. It returns an
+// empty string if there are not enough parts.
+func newSplit(args []Argument) (CallbackFunc, error) {
+	n, err := literalInt(args, 2, "split index")
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("split index must not be negative but is %d", n)
+	}
+
+	return func(args []string) string {
+		if args[1] == "" {
+			return ""
+		}
+		parts := strings.SplitN(args[0], args[1], n+2)
+		if n >= len(parts) {
+			return ""
+		}
+		return parts[n]
+	}, nil
+}
+
+// newRegexExtract returns the callback of regex_extract(text, regex, group),
+// which returns the given capture group of the first match of the regex, or
+// the whole match for group 0. It returns an empty string if the regex doesn't
+// match. The regex is compiled only once.
+func newRegexExtract(args []Argument) (CallbackFunc, error) {
+	if args[1].Type != LiteralArgument {
+		return nil, errors.New("regex_extract regex must be a quoted string")
+	}
+	re, err := regexp.Compile(args[1].Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex_extract regex: %w", err)
+	}
+	group, err := literalInt(args, 2, "regex_extract group")
+	if err != nil {
+		return nil, err
+	}
+	if group < 0 || group > re.NumSubexp() {
+		return nil, fmt.Errorf("regex_extract group %d doesn't exist in regex '%s'",
+			group, args[1].Value)
+	}
+
+	return func(args []string) string {
+		match := re.FindStringSubmatch(args[0])
+		if match == nil {
+			return ""
+		}
+		return match[group]
+	}, nil
+}
+
+// newReplace returns the callback of replace(text, old, new), which replaces
+// all occurrences of old in the text with new.
+func newReplace([]Argument) (CallbackFunc, error) {
+	return func(args []string) string {
+		if args[1] == "" {
+			return args[0]
+		}
+		return strings.ReplaceAll(args[0], args[1], args[2])
+	}, nil
+}
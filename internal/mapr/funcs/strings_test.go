@@ -0,0 +1,38 @@
+package funcs
+
+import "testing"
+
+func TestStringFunctions(t *testing.T) {
+	t.Parallel()
+
+	testCalls(t, []callCase{
+		{input: "lower($line)", line: "GET /Index", want: "get /index"},
+		{input: "upper($line)", line: "get", want: "GET"},
+		{input: "substr($line, 4)", line: "GET /index", want: "/index"},
+		{input: "substr($line, 4, 2)", line: "GET /index", want: "/i"},
+		{input: "substr($line, 1, 2)", line: "äöü", want: "öü"},
+		{input: "substr($line, 20, 2)", line: "GET", want: ""},
+		{input: `split($line, "/", 1)`, line: "/api/v1/users", want: "api"},
+		{input: `split($line, "/", 3)`, line: "/api/v1/users", want: "users"},
+		{input: `split($line, "/", 4)`, line: "/api/v1/users", want: ""},
+		{input: `split($line, ", ", 1)`, line: "a, b, c", want: "b"},
+		{input: `regex_extract($line, "user=(\w+)", 1)`, line: "id=1 user=paul", want: "paul"},
+		{input: `regex_extract($line, "\d+", 0)`, line: "took 123ms", want: "123"},
+		{input: `regex_extract($line, "user=(\w+)", 1)`, line: "id=1", want: ""},
+		{input: `replace($line, "-", "_")`, line: "a-b-c", want: "a_b_c"},
+		{input: `upper(substr(lower($line), 0, 3))`, line: "GeT /", want: "GET"},
+	})
+
+	testMalformedCalls(t, []string{
+		"lower($line, $line)",
+		"substr($line)",
+		"substr($line, $start)",
+		"substr($line, -1)",
+		`split($line, "/")`,
+		`split($line, "/", $n)`,
+		`regex_extract($line, $re, 1)`,
+		`regex_extract($line, "(", 1)`,
+		`regex_extract($line, "(a)", 2)`,
+		`replace($line, "a")`,
+	})
+}
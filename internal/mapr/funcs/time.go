@@ -0,0 +1,122 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strftimeLayouts maps the supported strftime directives to Go time layouts.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'j': "002",
+	'z': "-0700",
+	'Z': "MST",
+	'F': "2006-01-02",
+	'T': "15:04:05",
+}
+
+// newStrftime returns the callback of strftime(timestamp, format), which
+// formats a timestamp using strftime directives, e.g.
+// strftime($time, "%Y-%m-%d %H:00"). %s formats the Unix epoch seconds and %%
+// a literal %. Timestamps which can't be parsed result in an empty string.
+func newStrftime(args []Argument) (CallbackFunc, error) {
+	if args[1].Type != LiteralArgument {
+		return nil, errors.New("strftime format must be a quoted string")
+	}
+
+	// Translate the format once into the parts of the result. Literal text
+	// is never passed to time.Format, which would interpret e.g. "1" as the
+	// month.
+	var parts []func(t time.Time) string
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			text := literal.String()
+			parts = append(parts, func(time.Time) string { return text })
+			literal.Reset()
+		}
+	}
+
+	format := args[1].Value
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		if i++; i == len(format) {
+			return nil, errors.New("strftime format ends with an incomplete directive")
+		}
+		switch directive := format[i]; directive {
+		case '%':
+			literal.WriteByte('%')
+		case 's':
+			flush()
+			parts = append(parts, func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) })
+		default:
+			layout, ok := strftimeLayouts[directive]
+			if !ok {
+				return nil, fmt.Errorf("unsupported strftime directive '%%%c'", directive)
+			}
+			flush()
+			parts = append(parts, func(t time.Time) string { return t.Format(layout) })
+		}
+	}
+	flush()
+
+	return func(args []string) string {
+		t, _, ok := parseTime(args[0])
+		if !ok {
+			return ""
+		}
+		var sb strings.Builder
+		for _, part := range parts {
+			sb.WriteString(part(t))
+		}
+		return sb.String()
+	}, nil
+}
+
+// newParseDuration returns the callback of parse_duration(duration[, unit]),
+// which turns a duration such as "123ms" or "1m30s" into a number of the given
+// unit, e.g. "ms", or seconds by default. Plain numbers are taken as they are.
+// Durations which can't be parsed result in an empty string.
+func newParseDuration(args []Argument) (CallbackFunc, error) {
+	unit := time.Second
+	if len(args) > 1 {
+		// The unit can be given as a bareword, e.g. parse_duration(took, ms).
+		if args[1].Type == CallArgument {
+			return nil, errors.New("parse_duration unit must be a literal, e.g. ms")
+		}
+		var err error
+		if unit, err = time.ParseDuration("1" + args[1].Value); err != nil {
+			return nil, fmt.Errorf("invalid parse_duration unit: %w", err)
+		}
+	}
+
+	return func(args []string) string {
+		if _, err := strconv.ParseFloat(args[0], 64); err == nil {
+			return args[0]
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatFloat(float64(d)/float64(unit), 'f', -1, 64)
+	}, nil
+}
@@ -0,0 +1,29 @@
+package funcs
+
+import "testing"
+
+func TestTimeFunctions(t *testing.T) {
+	t.Parallel()
+
+	testCalls(t, []callCase{
+		{input: `strftime($line, "%Y-%m-%d %H:%M:%S")`, line: "20211002-071209", want: "2021-10-02 07:12:09"},
+		{input: `strftime($line, "%F %H:00")`, line: "2021-10-02T07:12:09Z", want: "2021-10-02 07:00"},
+		{input: `strftime($line, "%s")`, line: "2021-10-02T07:12:09Z", want: "1633158729"},
+		{input: `strftime($line, "%d/%b 100%%")`, line: "1633158729", want: "02/Oct 100%"},
+		{input: `strftime($line, "%Y")`, line: "not a timestamp", want: ""},
+		{input: "parse_duration($line)", line: "123ms", want: "0.123"},
+		{input: "parse_duration($line)", line: "1m30s", want: "90"},
+		{input: "parse_duration($line, ms)", line: "1.5s", want: "1500"},
+		{input: "parse_duration($line, ms)", line: "42", want: "42"},
+		{input: "parse_duration($line)", line: "soon", want: ""},
+	})
+
+	testMalformedCalls(t, []string{
+		"strftime($line)",
+		"strftime($line, $format)",
+		`strftime($line, "%Q")`,
+		`strftime($line, "%")`,
+		"parse_duration($line, lower(ms))",
+		"parse_duration($line, parsec)",
+	})
+}
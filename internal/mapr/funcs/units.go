@@ -0,0 +1,69 @@
+package funcs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteUnits maps the byte size units, in lower case, to their number of bytes.
+// The units with a decimal prefix are powers of 1000, the ones with a binary
+// prefix powers of 1024.
+var byteUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseUnitValue parses a number which may carry a unit. Durations, e.g. 123ms
+// or 1m3s, are returned as a number of the given duration unit, and byte
+// sizes, e.g. 4.2MB or 1KiB, as a number of bytes. Plain numbers are returned
+// as they are.
+func ParseUnitValue(value string, durationUnit time.Duration) (float64, bool) {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return float64(d) / float64(durationUnit), true
+	}
+	return parseByteSize(value)
+}
+
+// parseByteSize parses a byte size, e.g. 4.2MB, 512 KiB or 10b.
+func parseByteSize(value string) (float64, bool) {
+	i := strings.LastIndexAny(value, "0123456789.")
+	if i < 0 || i == len(value)-1 {
+		return 0, false
+	}
+	factor, ok := byteUnits[strings.ToLower(strings.TrimSpace(value[i+1:]))]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value[:i+1]), 64)
+	if err != nil {
+		return 0, false
+	}
+	return f * factor, true
+}
+
+// Bytes turns a byte size such as "4.2MB" or "1KiB" into a number of bytes.
+// Plain numbers are taken as they are. Sizes which can't be parsed result in
+// an empty string.
+func Bytes(input string) string {
+	if _, err := strconv.ParseFloat(input, 64); err == nil {
+		return input
+	}
+	f, ok := parseByteSize(input)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
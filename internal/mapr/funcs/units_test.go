@@ -0,0 +1,44 @@
+package funcs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUnitValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value string
+		unit  time.Duration
+		want  float64
+	}{
+		{"42", time.Second, 42},
+		{"123ms", time.Second, 0.123},
+		{"1m3s", time.Second, 63},
+		{"1m3s", time.Millisecond, 63000},
+		{"4.2MB", time.Second, 4.2e6},
+		{"1KiB", time.Second, 1024},
+		{"512 kb", time.Second, 512000},
+		{"10b", time.Second, 10},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseUnitValue(tt.value, tt.unit); !ok || got != tt.want {
+			t.Errorf("ParseUnitValue(%q, %v) = %v, %v, want %v", tt.value, tt.unit, got, ok, tt.want)
+		}
+	}
+	for _, value := range []string{"", "MB", "4.2XB", "soon", "1.2.3"} {
+		if got, ok := ParseUnitValue(value, time.Second); ok {
+			t.Errorf("Expected %q not to be a number, got %v", value, got)
+		}
+	}
+
+	testCalls(t, []callCase{
+		{input: "bytes($line)", line: "4.2MB", want: "4200000"},
+		{input: "bytes($line)", line: "2GiB", want: "2147483648"},
+		{input: "bytes($line)", line: "123", want: "123"},
+		{input: "bytes($line)", line: "lots", want: ""},
+		{input: "duration($line)", line: "1m3s", want: "63"},
+		{input: "duration($line, ms)", line: "123ms", want: "123"},
+	})
+}
@@ -2,6 +2,8 @@ package mapr
 
 import (
 	"fmt"
+
+	"github.com/mimecast/dtail/internal/io/dlog"
 )
 
 // GlobalGroupSet is used on the dtail client to merge multiple group sets
@@ -9,12 +11,18 @@ import (
 type GlobalGroupSet struct {
 	GroupSet
 	semaphore chan struct{}
+	// The maximum number of groups of a 'select distinct' query. Further
+	// distinct value combinations are dropped.
+	distinctLimit int
+	// Whether distinct value combinations were dropped already.
+	distinctDropped bool
 }
 
 // NewGlobalGroupSet creates a new empty global group set.
 func NewGlobalGroupSet() *GlobalGroupSet {
 	g := GlobalGroupSet{
-		semaphore: make(chan struct{}, 1),
+		semaphore:     make(chan struct{}, 1),
+		distinctLimit: DefaultDistinctLimit,
 	}
 	g.InitSet()
 	return &g
@@ -25,6 +33,14 @@ func (g *GlobalGroupSet) String() string {
 	return fmt.Sprintf("GlobalGroupSet(%s)", g.GroupSet.String())
 }
 
+// SetDistinctLimit sets the maximum number of distinct value combinations of a
+// 'select distinct' query. A limit below 1 keeps the default.
+func (g *GlobalGroupSet) SetDistinctLimit(limit int) {
+	if limit > 0 {
+		g.distinctLimit = limit
+	}
+}
+
 // Merge (blocking) a group set into the global group set.
 func (g *GlobalGroupSet) Merge(query *Query, group *GroupSet) error {
 	g.semaphore <- struct{}{}
@@ -48,6 +64,9 @@ func (g *GlobalGroupSet) MergeNoblock(query *Query, group *GroupSet) (bool, erro
 // Merge a group set into the global group set.
 func (g *GlobalGroupSet) merge(query *Query, group *GroupSet) error {
 	for groupKey, set := range group.sets {
+		if query.Distinct && !g.hasDistinctRoom(groupKey) {
+			continue
+		}
 		s := g.GetSet(groupKey)
 		if err := s.Merge(query, set); err != nil {
 			return err
@@ -56,6 +75,22 @@ func (g *GlobalGroupSet) merge(query *Query, group *GroupSet) error {
 	return nil
 }
 
+// hasDistinctRoom returns true if the group of a 'select distinct' query can be
+// merged. The servers send every distinct value combination only once, but
+// the same combination may come from many servers, so only combinations not
+// seen yet count against the limit.
+func (g *GlobalGroupSet) hasDistinctRoom(groupKey string) bool {
+	if _, ok := g.sets[groupKey]; ok || len(g.sets) < g.distinctLimit {
+		return true
+	}
+	if !g.distinctDropped {
+		g.distinctDropped = true
+		dlog.Common.Warn("Reached the limit of distinct value combinations, dropping "+
+			"further ones", "limit", g.distinctLimit)
+	}
+	return false
+}
+
 // IsEmpty determines whether the global group set has any data in it.
 func (g *GlobalGroupSet) IsEmpty() bool {
 	return g.NumSets() == 0
@@ -1,10 +1,16 @@
 package mapr
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"math"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/mimecast/dtail/internal/protocol"
 )
 
 // GroupSet represents a map of aggregate sets. The group sets
@@ -21,12 +27,28 @@ type result struct {
 	groupKey     string
 	values       []string
 	columnWidths []int
-	orderBy      float64
+	// The values of the 'order by' keys, in the order of Query.OrderBy.
+	orderBy []orderValue
+	// The bucket counts of the histogram columns, by column index.
+	histograms map[int][]float64
+}
+
+// orderValue is the value of a result row for one of the 'order by' keys.
+type orderValue struct {
+	value float64
+	// Non-numeric values, e.g. the timestamps of bucket($time,1m), are
+	// ordered as strings.
+	str       string
+	nonNumber bool
 }
 
 type resultStats struct {
 	percentageTotals map[string]float64
 	percentileValues map[string][]float64
+	// The upper bounds of the buckets of every histogram, which are the same
+	// for all rows, in the terminal and in an outfile.
+	histogramBounds        map[string][]float64
+	outfileHistogramBounds map[string][]float64
 }
 
 // NewGroupSet returns a new empty group set.
@@ -110,7 +132,6 @@ func (g *GroupSet) result(query *Query, gathercolumnWidths bool) ([]result, []in
 	// Helpers for calculating the ASCII table output (output is the terminal and
 	// not a CSV file).
 	columnWidths := make([]int, len(query.Select))
-	var valueStrLen int
 	stats := g.makeResultStats(query)
 
 	// Collect and sort group keys lexicographically so that the row slice is
@@ -120,23 +141,30 @@ func (g *GroupSet) result(query *Query, gathercolumnWidths bool) ([]result, []in
 
 	for _, groupKey := range keys {
 		set := g.sets[groupKey]
-		result := result{groupKey: groupKey}
+		result := result{groupKey: groupKey, orderBy: make([]orderValue, len(query.OrderBy))}
 
-		for i, sc := range query.Select {
-			if valueStrLen, err = g.resultSelect(query, &sc, set, &result, &stats); err != nil {
+		for _, sc := range query.Select {
+			if err = g.resultSelect(query, &sc, set, &result, &stats); err != nil {
 				return rows, columnWidths, err
 			}
+		}
 
-			// Do we want to gather the table withs? This is required to print out a decent
-			// ASCII formated table (table output is the terminal and not a CSV file).
-			if !gathercolumnWidths {
-				continue
-			}
-			if columnWidths[i] < len(sc.FieldStorage) {
-				columnWidths[i] = len(sc.FieldStorage)
-			}
-			if columnWidths[i] < valueStrLen {
-				columnWidths[i] = valueStrLen
+		// The having clause can only be evaluated once all values of the row
+		// are known, and filtered rows must not affect the table widths.
+		if !query.HavingClause(result.values) {
+			continue
+		}
+
+		// Do we want to gather the table withs? This is required to print out a decent
+		// ASCII formated table (table output is the terminal and not a CSV file).
+		if gathercolumnWidths {
+			for i, sc := range query.Select {
+				if columnWidths[i] < len(sc.name()) {
+					columnWidths[i] = len(sc.name())
+				}
+				if columnWidths[i] < len(result.values[i]) {
+					columnWidths[i] = len(result.values[i])
+				}
 			}
 		}
 		rows = append(rows, result)
@@ -159,16 +187,44 @@ func sortedGroupKeys(sets map[string]*AggregateSet) []string {
 	return keys
 }
 
-func (*GroupSet) resultSelect(query *Query, sc *selectCondition, set *AggregateSet,
-	result *result, stats *resultStats) (int, error) {
+func (g *GroupSet) resultSelect(query *Query, sc *selectCondition, set *AggregateSet,
+	result *result, stats *resultStats) error {
 
-	var valueStr string
-	var value float64
+	value, valueStr, nonNumber, err := g.resultValue(sc, set, stats)
+	if err != nil {
+		return err
+	}
+	if sc.Operation == Histogram {
+		if result.histograms == nil {
+			result.histograms = make(map[int][]float64)
+		}
+		result.histograms[len(result.values)] = set.histogramCounts(sc.FieldStorage,
+			stats.outfileHistogramBounds[sc.FieldStorage])
+	}
+
+	for i, key := range query.OrderBy {
+		if sc.FieldStorage == key.Field {
+			result.orderBy[i] = orderValue{value: value, str: valueStr, nonNumber: nonNumber}
+		}
+	}
+	result.values = append(result.values, valueStr)
+
+	return nil
+}
+
+// resultValue returns the value of a select condition for an aggregate set,
+// both as a number and as rendered in the result. nonNumber is true if the
+// value is not a number, e.g. a last() string.
+func (g *GroupSet) resultValue(sc *selectCondition, set *AggregateSet,
+	stats *resultStats) (value float64, valueStr string, nonNumber bool, err error) {
 
 	switch sc.Operation {
 	case Count:
 		value = set.FValues[sc.FieldStorage]
 		valueStr = fmt.Sprintf("%d", int(value))
+	case CountDistinct:
+		value = float64(set.DistinctCount(sc.FieldStorage))
+		valueStr = fmt.Sprintf("%d", int(value))
 	case Len:
 		fallthrough
 	case Sum:
@@ -179,16 +235,21 @@ func (*GroupSet) resultSelect(query *Query, sc *selectCondition, set *AggregateS
 		value = set.FValues[sc.FieldStorage]
 		valueStr = fmt.Sprintf("%f", value)
 	case Last:
+		fallthrough
+	case First:
 		valueStr = set.SValues[sc.FieldStorage]
-		value, _ = strconv.ParseFloat(valueStr, 64)
+		var parseErr error
+		if value, parseErr = strconv.ParseFloat(valueStr, 64); parseErr != nil {
+			nonNumber = true
+		}
 	case Avg:
 		// Guard against division by zero when an empty aggregate set (Samples==0)
 		// is received from the server. Without this guard, 0/0 yields NaN, which
 		// propagates as the string "NaN" into CSV/terminal output.
-		if set.Samples == 0 {
+		if count := set.AvgCount(sc.FieldStorage); count == 0 {
 			value = 0
 		} else {
-			value = set.FValues[sc.FieldStorage] / float64(set.Samples)
+			value = set.FValues[sc.FieldStorage] / count
 		}
 		valueStr = fmt.Sprintf("%f", value)
 	case Percentage:
@@ -200,29 +261,68 @@ func (*GroupSet) resultSelect(query *Query, sc *selectCondition, set *AggregateS
 			value = (value / total) * 100
 		}
 		valueStr = fmt.Sprintf("%f", value)
+	case Variance:
+		value = set.Variance(sc.FieldStorage)
+		valueStr = fmt.Sprintf("%f", value)
+	case StdDev:
+		value = math.Sqrt(set.Variance(sc.FieldStorage))
+		valueStr = fmt.Sprintf("%f", value)
+	case Quantile:
+		value = set.Quantile(sc.FieldStorage, sc.quantile)
+		valueStr = fmt.Sprintf("%f", value)
+	case Delta:
+		value = set.FValues[sc.FieldStorage]
+		valueStr = fmt.Sprintf("%f", value)
+	case Rate:
+		value = set.Rate(sc.FieldStorage)
+		valueStr = fmt.Sprintf("%f", value)
+	case Histogram:
+		// The value is the total count, whereas the terminal shows the counts
+		// of the buckets as a bar.
+		value = set.FValues[sc.FieldStorage]
+		valueStr = histogramBar(set.histogramCounts(sc.FieldStorage,
+			stats.histogramBounds[sc.FieldStorage]))
 	case Percentile:
 		value = percentileRank(set.FValues[sc.FieldStorage], stats.percentileValues[sc.FieldStorage])
 		valueStr = fmt.Sprintf("%f", value)
+	case Expression:
+		// The aggregations are merged already, so compute the expression from
+		// their results. A division by zero results in NaN.
+		value = sc.expr.eval(aggregationValues(func(operand *selectCondition) float64 {
+			operandValue, _, operandNonNumber, operandErr := g.resultValue(operand, set, stats)
+			if operandErr != nil {
+				err = operandErr
+			}
+			if operandNonNumber {
+				return math.NaN()
+			}
+			return operandValue
+		}))
+		valueStr = fmt.Sprintf("%f", value)
 	default:
-		return 0, fmt.Errorf("Unknown aggregation method '%v'", sc.Operation)
+		err = fmt.Errorf("Unknown aggregation method '%v'", sc.Operation)
 	}
-
-	if sc.FieldStorage == query.OrderBy {
-		result.orderBy = value
-	}
-	result.values = append(result.values, valueStr)
-
-	return len(valueStr), nil
+	return
 }
 
 func (g *GroupSet) makeResultStats(query *Query) resultStats {
 	stats := resultStats{
 		percentageTotals: make(map[string]float64),
 		percentileValues: make(map[string][]float64),
+		histogramBounds:  make(map[string][]float64),
+
+		outfileHistogramBounds: make(map[string][]float64),
+	}
+
+	for _, sc := range query.Aggregations() {
+		if sc.Operation == Histogram {
+			stats.histogramBounds[sc.FieldStorage] = g.histogramBounds(sc)
+			stats.outfileHistogramBounds[sc.FieldStorage] = g.outfileHistogramBounds(query, sc)
+		}
 	}
 
 	for _, set := range g.sets {
-		for _, sc := range query.Select {
+		for _, sc := range query.Aggregations() {
 			value := set.FValues[sc.FieldStorage]
 			switch sc.Operation {
 			case Percentage:
@@ -240,6 +340,31 @@ func (g *GroupSet) makeResultStats(query *Query) resultStats {
 	return stats
 }
 
+// histogramBounds returns the upper bounds of the buckets of a histogram. The
+// explicit buckets are always all included, whereas the exponential ones are
+// those with values in any of the groups.
+func (g *GroupSet) histogramBounds(sc selectCondition) []float64 {
+	if sc.histogram.base == 0 {
+		return append(slices.Clone(sc.histogram.bounds), math.Inf(1))
+	}
+	var bounds []float64
+	for _, set := range g.sets {
+		bounds = mergeBounds(bounds, set.histogramBounds(sc.FieldStorage))
+	}
+	return bounds
+}
+
+// outfileHistogramBounds returns the upper bounds of the buckets of a histogram
+// as columns of an outfile. An outfile in append mode gets the CSV header only
+// once, so the exponential buckets are fixed then, see
+// histogramBuckets.outfileBounds.
+func (g *GroupSet) outfileHistogramBounds(query *Query, sc selectCondition) []float64 {
+	if sc.histogram.base == 0 || !query.HasOutfile() || !query.Outfile.AppendMode {
+		return g.histogramBounds(sc)
+	}
+	return sc.histogram.outfileBounds()
+}
+
 func percentileRank(value float64, sortedValues []float64) float64 {
 	if len(sortedValues) == 0 {
 		return 0
@@ -252,16 +377,70 @@ func percentileRank(value float64, sortedValues []float64) float64 {
 }
 
 func (*GroupSet) resultOrderBy(query *Query, rows []result) {
-	if query.OrderBy == "" {
+	if len(query.OrderBy) == 0 {
 		return
 	}
-	if query.ReverseOrder {
-		sort.SliceStable(rows, func(i, j int) bool {
-			return rows[i].orderBy < rows[j].orderBy
-		})
-	} else {
-		sort.SliceStable(rows, func(i, j int) bool {
-			return rows[i].orderBy > rows[j].orderBy
-		})
+	// Later keys only break the ties of the earlier ones.
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, key := range query.OrderBy {
+			c := compareOrderBy(rows[i].orderBy[k], rows[j].orderBy[k])
+			if c == 0 {
+				continue
+			}
+			if key.Ascending {
+				return c < 0
+			}
+			return c > 0
+		}
+		return false
+	})
+}
+
+// resultLimit returns the rows to report after ordering. It skips the first
+// 'offset' rows and keeps at most 'limit' rows, or 'limit' rows per distinct
+// value of the 'limit per' fields.
+func (*GroupSet) resultLimit(query *Query, rows []result) []result {
+	if len(query.LimitPer) > 0 && query.Limit >= 0 {
+		var columns []int
+		for _, field := range query.LimitPer {
+			for i, sc := range query.Select {
+				if sc.FieldStorage == field {
+					columns = append(columns, i)
+					break
+				}
+			}
+		}
+		counts := make(map[string]int)
+		limited := make([]result, 0, len(rows))
+		for _, row := range rows {
+			partition := make([]string, len(columns))
+			for i, column := range columns {
+				partition[i] = row.values[column]
+			}
+			key := strings.Join(partition, protocol.AggregateGroupKeyCombinator)
+			if counts[key] < query.Limit {
+				counts[key]++
+				limited = append(limited, row)
+			}
+		}
+		rows = limited
+	}
+
+	if query.Offset >= len(rows) {
+		return nil
+	}
+	rows = rows[query.Offset:]
+	if len(query.LimitPer) == 0 && query.Limit >= 0 && query.Limit < len(rows) {
+		rows = rows[:query.Limit]
+	}
+	return rows
+}
+
+// compareOrderBy compares two order by values numerically, or as strings if
+// any of them is not a number.
+func compareOrderBy(a, b orderValue) int {
+	if a.nonNumber || b.nonNumber {
+		return strings.Compare(a.str, b.str)
 	}
+	return cmp.Compare(a.value, b.value)
 }
@@ -0,0 +1,76 @@
+package mapr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseQuerySelectAlias(t *testing.T) {
+	t.Parallel()
+
+	q, err := NewQuery("select path as p, count(path) as hits, sum(bytes) / count(path) AS avg_bytes " +
+		"group by path order by hits having hits > 1 limit 1 per p")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	want := []struct{ storage, alias string }{
+		{"path", "p"}, {"count(path)", "hits"}, {"sum(bytes) / count(path)", "avg_bytes"},
+	}
+	if len(q.Select) != len(want) {
+		t.Fatalf("Unexpected select conditions %v", q.Select)
+	}
+	for i, w := range want {
+		if q.Select[i].FieldStorage != w.storage || q.Select[i].Alias != w.alias {
+			t.Errorf("Got select condition %v, want %s as %s", q.Select[i], w.storage, w.alias)
+		}
+	}
+	if q.OrderBy[0].Field != "count(path)" || q.LimitPer[0] != "path" {
+		t.Errorf("Expected aliases to be resolved, got order by %v and limit per %v",
+			q.OrderBy, q.LimitPer)
+	}
+
+	for _, queryStr := range []string{
+		"select count(path) as",
+		"select count(path) as hits, sum(bytes) as hits",
+		"select count(path) as path, path",
+		"select count(path) as hits order by nohits",
+	} {
+		if q, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected a parse error: %s\n%v", queryStr, q)
+		}
+	}
+}
+
+func TestGroupSetSelectAliasResult(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "alias.csv")
+	query, err := NewQuery("select path,count(path) as hits group by path " +
+		"having hits >= 150 rorder by hits outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	if err := newHavingTestGroupSet(t).WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "path,hits\n/a,150\n/c,300\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+
+	table, _, err := newHavingTestGroupSet(t).Result(query, -1, nil)
+	if err != nil {
+		t.Fatalf("Result() returned unexpected error: %v", err)
+	}
+	header := strings.Split(table, "\n")[0]
+	if !strings.Contains(header, "hits") || strings.Contains(header, "count(path)") {
+		t.Errorf("Expected the alias in the table header: %q", header)
+	}
+}
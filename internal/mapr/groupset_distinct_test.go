@@ -0,0 +1,105 @@
+package mapr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mimecast/dtail/internal/protocol"
+)
+
+func TestParseQueryDistinct(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select distinct $hostname,status,bucket($time,1m) from stats")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if !query.Distinct {
+		t.Errorf("Expected a distinct query")
+	}
+	if got := strings.Join(query.GroupBy, ","); got != "$hostname,status,bucket($time,1m)" {
+		t.Errorf("Expected to group by all selected fields, got %s", got)
+	}
+	if len(query.Set) != 1 {
+		t.Errorf("Expected a set condition for the function call, got %v", query.Set)
+	}
+
+	// A field named distinct needs backticks, unless it is selected only.
+	for queryStr, want := range map[string]string{
+		"select `distinct`,foo":    "distinct,foo",
+		"select distinct":          "distinct",
+		"select DISTINCT foo":      "foo",
+		"select distinct foo as f": "foo",
+	} {
+		query, err := NewQuery(queryStr)
+		if err != nil {
+			t.Errorf("Unable to parse query %q: %v", queryStr, err)
+			continue
+		}
+		var fields []string
+		for _, sc := range query.Select {
+			fields = append(fields, sc.Field)
+		}
+		if strings.Join(fields, ",") != want {
+			t.Errorf("Query %q: got select fields %v, want %s", queryStr, fields, want)
+		}
+	}
+
+	for _, queryStr := range []string{
+		"select distinct foo,count(bar)",
+		"select distinct last(foo)",
+		"select distinct foo group by foo",
+		"select distinct sum(bytes) / 2",
+	} {
+		if _, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected an error parsing query %q", queryStr)
+		}
+	}
+}
+
+func TestGlobalGroupSetDistinct(t *testing.T) {
+	quietCommonLogger(t)
+
+	query, err := NewQuery("select distinct $hostname,status")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	newServerGroup := func(combinations ...string) *GroupSet {
+		group := NewGroupSet()
+		for _, combination := range combinations {
+			values := strings.Split(combination, " ")
+			set := group.GetSet(strings.Join(values, protocol.AggregateGroupKeyCombinator))
+			set.Samples = 1
+			for i, sc := range query.Aggregations() {
+				set.setString(sc.FieldStorage, values[i])
+			}
+		}
+		return group
+	}
+
+	global := NewGlobalGroupSet()
+	global.SetDistinctLimit(3)
+	// The same combination from two servers is listed once.
+	if err := global.Merge(query, newServerGroup("web1 200", "web1 500")); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if err := global.Merge(query, newServerGroup("web1 200", "web2 200")); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	// Combinations beyond the limit are dropped.
+	if err := global.Merge(query, newServerGroup("web2 500", "web1 200")); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	rows, _, err := global.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, strings.Join(row.values, " "))
+	}
+	if want := "web1 200,web1 500,web2 200"; strings.Join(got, ",") != want {
+		t.Errorf("Got rows %v, want %s", got, want)
+	}
+}
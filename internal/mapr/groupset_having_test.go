@@ -0,0 +1,130 @@
+package mapr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mimecast/dtail/internal/io/dlog"
+)
+
+func newHavingTestGroupSet(t *testing.T) *GroupSet {
+	t.Helper()
+
+	groupSet := NewGroupSet()
+	for path, count := range map[string]int{"/a": 150, "/b": 20, "/c": 300, "/d": 101} {
+		set := groupSet.GetSet(path)
+		for range count {
+			if err := set.Aggregate("count(path)", Count, "1", false); err != nil {
+				t.Fatalf("Aggregate failed for %s: %v", path, err)
+			}
+			set.Samples++
+		}
+		set.setString("path", path)
+	}
+	return groupSet
+}
+
+func TestGroupSetHavingFiltersRows(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,count(path) group by path " +
+		"having count(path) > 100 order by count(path) limit 2")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	rows, _, err := newHavingTestGroupSet(t).result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, row := range rows {
+		got = append(got, row.groupKey)
+	}
+	// The limit is applied when rendering, after the having filter and ordering.
+	want := []string{"/c", "/a", "/d"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Got rows %v, want %v", got, want)
+	}
+
+	output, numRows, err := newHavingTestGroupSet(t).Result(query, -1, nil)
+	if err != nil {
+		t.Fatalf("Result() returned unexpected error: %v", err)
+	}
+	if numRows != 3 {
+		t.Errorf("Expected 3 rows after having filter, got %d", numRows)
+	}
+	if strings.Contains(output, "/b") || strings.Contains(output, "/d") {
+		t.Errorf("Expected only the top two filtered rows in output:\n%s", output)
+	}
+}
+
+func TestGroupSetHavingWithBooleanExpression(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,count(path) group by path " +
+		"having count(path) < 100 or path eq \"/c\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	rows, _, err := newHavingTestGroupSet(t).result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	if len(rows) != 2 || rows[0].groupKey != "/b" || rows[1].groupKey != "/c" {
+		t.Errorf("Expected rows /b and /c, got %v", rows)
+	}
+}
+
+// quietCommonLogger installs a zero-value logger for the duration of the test,
+// as writing outfiles logs via dlog.Common which is nil unless started.
+func quietCommonLogger(t *testing.T) {
+	t.Helper()
+
+	originalLogger := dlog.Common
+	dlog.Common = &dlog.DLog{}
+	t.Cleanup(func() {
+		dlog.Common = originalLogger
+	})
+}
+
+func TestGroupSetHavingOutfile(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "having.csv")
+	query, err := NewQuery("select path,count(path) group by path " +
+		"having count(path) >= 150 outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	if err := newHavingTestGroupSet(t).WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "path,count(path)\n/a,150\n/c,300\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+}
+
+func TestParseQueryHavingRequiresSelectedField(t *testing.T) {
+	errorQueries := []string{
+		"select path,count(path) group by path having sum(bytes) > 10",
+		"select path,count(path) group by path having",
+		"select path,count(path) group by path having count(path) >",
+	}
+
+	for _, queryStr := range errorQueries {
+		if q, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected a parse error: %s\n%v", queryStr, q)
+		}
+	}
+}
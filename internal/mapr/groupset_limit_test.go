@@ -0,0 +1,173 @@
+package mapr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGroupSetResultLimit(t *testing.T) {
+	t.Parallel()
+
+	const selectQuery = "select $hostname,path,count(path) group by $hostname,path " +
+		"order by count(path) desc "
+	tests := []struct {
+		clauses string
+		want    string
+	}{
+		{"", "web1 /b 7,web2 /a 5,web2 /b 5,web1 /c 3,web1 /a 1"},
+		{"limit 2", "web1 /b 7,web2 /a 5"},
+		{"limit 2 offset 1", "web2 /a 5,web2 /b 5"},
+		{"limit 5 offset 3", "web1 /c 3,web1 /a 1"},
+		{"limit 5 offset 5", ""},
+		{"limit 1 per $hostname", "web1 /b 7,web2 /a 5"},
+		{"limit 2 per $hostname offset 1", "web2 /a 5,web2 /b 5,web1 /c 3"},
+		{"limit 1 per path", "web1 /b 7,web2 /a 5,web1 /c 3"},
+		{"limit 1 per $hostname, path", "web1 /b 7,web2 /a 5,web2 /b 5,web1 /c 3,web1 /a 1"},
+		{"limit 0 per $hostname", ""},
+	}
+	for _, tc := range tests {
+		query, err := NewQuery(selectQuery + tc.clauses)
+		if err != nil {
+			t.Fatalf("Unable to parse query: %v", err)
+		}
+		groupSet := newOrderByTestGroupSet(t)
+		rows, _, err := groupSet.result(query, false)
+		if err != nil {
+			t.Fatalf("result() returned unexpected error: %v", err)
+		}
+		var got []string
+		for _, row := range groupSet.resultLimit(query, rows) {
+			got = append(got, strings.Join(row.values, " "))
+		}
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("Got rows %v, want %s: %s", got, tc.want, tc.clauses)
+		}
+	}
+}
+
+func TestGroupSetResultLimitPerRendering(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"order by count(path) desc limit 1 per $hostname")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// The default terminal row limit must not cut the per host limited rows.
+	output, numRows, err := newOrderByTestGroupSet(t).Result(query, 1, nil)
+	if err != nil {
+		t.Fatalf("Result() returned unexpected error: %v", err)
+	}
+	if numRows != 5 {
+		t.Errorf("Expected 5 rows before limiting, got %d", numRows)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[2], "/b") || !strings.Contains(lines[3], "/a") {
+		t.Errorf("Expected the top path of every host in output:\n%s", output)
+	}
+}
+
+func TestGroupSetResultLimitOutfile(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "limit.csv")
+	query, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"rorder by count(path) limit 1 per $hostname offset 1 outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	if err := newOrderByTestGroupSet(t).WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "$hostname,path,count(path)\nweb2,/a,5\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+}
+
+// TestGroupSetResultLimitOutfilePlain verifies that a plain limit keeps
+// limiting the outfile to the first rows, just as it did before 'per' and
+// 'offset' were added.
+func TestGroupSetResultLimitOutfilePlain(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "limit.csv")
+	query, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"order by count(path) desc limit 2 outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	if err := newOrderByTestGroupSet(t).WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "$hostname,path,count(path)\nweb1,/b,7\nweb2,/a,5\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+}
+
+func TestParseQueryLimitPerAndOffset(t *testing.T) {
+	t.Parallel()
+
+	q, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"limit 5 per $hostname offset 10")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if q.Limit != 5 || len(q.LimitPer) != 1 || q.LimitPer[0] != "$hostname" || q.Offset != 10 {
+		t.Errorf("Unexpected limit %d per %v offset %d", q.Limit, q.LimitPer, q.Offset)
+	}
+
+	for _, queryStr := range []string{
+		"select path,count(path) group by path limit 5 per",
+		"select path,count(path) group by path limit 5 by path",
+		"select path,count(path) group by path limit 5 per $hostname",
+		"select path,count(path) group by path limit 5 offset",
+		"select path,count(path) group by path limit 5 offset -1",
+		"select path,count(path) group by path limit 5 offset foo",
+		"select path,count(path) group by path limit 5 offset 1 2",
+		"select path,count(path) group by path limit 5 offset 1 per path",
+	} {
+		if q, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected a parse error: %s\n%v", queryStr, q)
+		}
+	}
+}
+
+// TestParseQueryOffsetField verifies that 'offset' is only a keyword at the end
+// of the 'limit' clause, so that it can be used as a field name elsewhere, e.g.
+// of Kafka consumer logs.
+func TestParseQueryOffsetField(t *testing.T) {
+	t.Parallel()
+
+	q, err := NewQuery("select topic,offset,max(offset) from stats where offset > 10 " +
+		"group by topic,offset order by offset limit 5 per `offset` offset 2")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if q.GroupKey != "topic,offset" || q.Select[2].Field != "offset" {
+		t.Errorf("Expected offset as a field, got %v", q)
+	}
+	if len(q.OrderBy) != 1 || q.OrderBy[0].Field != "offset" {
+		t.Errorf("Expected to order by offset, got %v", q.OrderBy)
+	}
+	if q.Limit != 5 || len(q.LimitPer) != 1 || q.LimitPer[0] != "offset" || q.Offset != 2 {
+		t.Errorf("Unexpected limit %d per %v offset %d", q.Limit, q.LimitPer, q.Offset)
+	}
+	if !q.Where.eval(map[string]string{"offset": "11"}) || q.Where.eval(map[string]string{"offset": "9"}) {
+		t.Errorf("Expected a where condition on offset, got %v", q.Where)
+	}
+}
@@ -0,0 +1,84 @@
+package mapr
+
+import (
+	"testing"
+)
+
+func TestGroupSetMissingValues(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select avg(latency),min(latency),max(latency),count(status) group by host")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// Only some of the lines have a latency, and the second server has none
+	// at all. The lines without a latency must neither lower the average nor
+	// count as a minimum of zero.
+	var servers []*AggregateSet
+	for _, lines := range [][]map[string]string{
+		{{"latency": "10", "status": "200"}, {"status": "500"}, {"latency": "30", "status": "200"}},
+		{{"status": "404"}},
+	} {
+		server := NewAggregateSet()
+		for _, fields := range lines {
+			for _, sc := range query.Select {
+				if value, ok := fields[sc.Field]; ok {
+					if err := server.Aggregate(sc.FieldStorage, sc.Operation, value, false); err != nil {
+						t.Fatalf("Aggregate failed: %v", err)
+					}
+				}
+			}
+			server.Samples++
+		}
+		servers = append(servers, server)
+	}
+	group := mergeServerSets(t, query, servers)
+
+	rows, _, err := group.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	for i, want := range []string{"20.000000", "10.000000", "30.000000", "4"} {
+		if got := rows[0].values[i]; got != want {
+			t.Errorf("Got %s = %s, want %s", query.Select[i].FieldStorage, got, want)
+		}
+	}
+
+	// The sets of servers which don't send the count of the values fall back
+	// to the number of samples.
+	set := NewAggregateSet()
+	set.Samples = 4
+	set.FValues["avg(latency)"] = 40
+	if got := set.AvgCount("avg(latency)"); got != 4 {
+		t.Errorf("Got average count %v, want the 4 samples", got)
+	}
+}
+
+func TestSetClauseMissingValues(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select avg($latency) set $latency = coalesce(latency, 0), " +
+		"$kb = bytes / 1024, $took = coalesce(took, latency), $status = status")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// The fields map is reused, the previous results must not stick.
+	fields := map[string]string{"$kb": "1", "$took": "2"}
+	if err := query.SetClause(fields); err != nil {
+		t.Fatalf("SetClause failed: %v", err)
+	}
+	if fields["$latency"] != "0" {
+		t.Errorf("Expected the coalesce default, got %q", fields["$latency"])
+	}
+	for _, field := range []string{"$kb", "$took"} {
+		if value, ok := fields[field]; ok {
+			t.Errorf("Expected %s to be absent, got %q", field, value)
+		}
+	}
+	// A plain bareword is still taken literally if there is no such field.
+	if fields["$status"] != "status" {
+		t.Errorf("Expected the literal status, got %q", fields["$status"])
+	}
+}
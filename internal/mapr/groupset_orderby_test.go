@@ -0,0 +1,136 @@
+package mapr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newOrderByTestGroupSet returns a group set of requests per host and path.
+func newOrderByTestGroupSet(t *testing.T) *GroupSet {
+	t.Helper()
+
+	groupSet := NewGroupSet()
+	for _, row := range []struct {
+		host, path string
+		count      int
+	}{
+		{"web2", "/a", 5},
+		{"web1", "/a", 1},
+		{"web1", "/b", 7},
+		{"web2", "/b", 5},
+		{"web1", "/c", 3},
+	} {
+		set := groupSet.GetSet(row.host + "," + row.path)
+		set.setString("$hostname", row.host)
+		set.setString("path", row.path)
+		for range row.count {
+			if err := set.Aggregate("count(path)", Count, "1", false); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+		}
+	}
+	return groupSet
+}
+
+func TestParseQueryOrderByKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		query string
+		want  []OrderKey
+	}{
+		{"select a,count(b) group by a order by count(b)",
+			[]OrderKey{{Field: "count(b)"}}},
+		{"select a,count(b) group by a rorder by count(b)",
+			[]OrderKey{{Field: "count(b)", Ascending: true}}},
+		{"select a,count(b) group by a order by a asc, count(b) desc",
+			[]OrderKey{{Field: "a", Ascending: true}, {Field: "count(b)"}}},
+		{"select a,count(b) group by a rorder by a count(b) desc",
+			[]OrderKey{{Field: "a", Ascending: true}, {Field: "count(b)"}}},
+		{"select a,sum(b) / count(b) group by a order by sum(b) / count(b) ASC",
+			[]OrderKey{{Field: "sum(b) / count(b)", Ascending: true}}},
+	}
+	for _, tc := range tests {
+		q, err := NewQuery(tc.query)
+		if err != nil {
+			t.Fatalf("Unable to parse query: %v", err)
+		}
+		if len(q.OrderBy) != len(tc.want) {
+			t.Fatalf("Got order keys %v, want %v: %s", q.OrderBy, tc.want, tc.query)
+		}
+		for i, want := range tc.want {
+			if q.OrderBy[i] != want {
+				t.Errorf("Got order key %v, want %v: %s", q.OrderBy[i], want, tc.query)
+			}
+		}
+	}
+
+	for _, queryStr := range []string{
+		"select a,count(b) group by a order by",
+		"select a,count(b) group by a order by asc",
+		"select a,count(b) group by a order by a asc desc",
+		"select a,count(b) group by a order by a, sum(b)",
+	} {
+		if q, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected a parse error: %s\n%v", queryStr, q)
+		}
+	}
+}
+
+func TestGroupSetResultMultiKeyOrderBy(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"order by $hostname asc, count(path) desc")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	rows, _, err := newOrderByTestGroupSet(t).result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, strings.Join(row.values, " "))
+	}
+	want := "web1 /b 7,web1 /c 3,web1 /a 1,web2 /a 5,web2 /b 5"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Got rows %v, want %s", got, want)
+	}
+
+	table, _, err := newOrderByTestGroupSet(t).Result(query, 10, nil)
+	if err != nil {
+		t.Fatalf("Result() returned unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) != 7 || !strings.Contains(lines[2], "web1") ||
+		!strings.Contains(lines[2], "/b") || !strings.Contains(lines[6], "web2") {
+		t.Errorf("Unexpected table order:\n%s", table)
+	}
+}
+
+func TestGroupSetMultiKeyOrderByOutfile(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "orderby.csv")
+	query, err := NewQuery("select $hostname,path,count(path) group by $hostname,path " +
+		"order by count(path) asc, path desc outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	if err := newOrderByTestGroupSet(t).WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "$hostname,path,count(path)\nweb1,/a,1\nweb1,/c,3\nweb2,/b,5\nweb2,/a,5\nweb1,/b,7\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+}
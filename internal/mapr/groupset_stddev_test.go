@@ -0,0 +1,119 @@
+package mapr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// serializedFields returns the fields of an aggregate set as the client
+// receives them from the server, see AggregateSet.Serialize.
+func serializedFields(set *AggregateSet) map[string]string {
+	fields := make(map[string]string, len(set.FValues)+len(set.SValues))
+	for k, v := range set.FValues {
+		fields[k] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for k, v := range set.SValues {
+		fields[k] = v
+	}
+	return fields
+}
+
+// mergeServerSets merges the given server side aggregate sets into one group,
+// the same way the client merges the sets received from many servers.
+func mergeServerSets(t *testing.T, query *Query, servers []*AggregateSet) *GroupSet {
+	t.Helper()
+
+	group := NewGroupSet()
+	for _, server := range servers {
+		client := NewAggregateSet()
+		fields := serializedFields(server)
+		for _, sc := range query.Select {
+			if _, err := client.AggregateSerialized(sc.FieldStorage, sc.Operation, fields); err != nil {
+				t.Fatalf("AggregateSerialized failed for %s: %v", sc.FieldStorage, err)
+			}
+		}
+		if err := group.GetSet("host").Merge(query, client); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+	}
+	return group
+}
+
+func TestGroupSetStdDevVarianceMergeExactly(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select stddev(latency),variance(latency) group by host")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// The values 2,4,4,4,5,5,7,9 have a mean of 5 and a population standard
+	// deviation of 2, but are split unevenly across three servers.
+	var servers []*AggregateSet
+	for _, values := range [][]string{{"2", "4"}, {"4", "4", "5", "5"}, {"7", "9"}} {
+		server := NewAggregateSet()
+		for _, value := range values {
+			for _, sc := range query.Select {
+				if err := server.Aggregate(sc.FieldStorage, sc.Operation, value, false); err != nil {
+					t.Fatalf("Aggregate failed: %v", err)
+				}
+			}
+			server.Samples++
+		}
+		servers = append(servers, server)
+	}
+
+	rows, _, err := mergeServerSets(t, query, servers).result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	for i, want := range []float64{2, 4} {
+		got, err := strconv.ParseFloat(rows[0].values[i], 64)
+		if err != nil {
+			t.Fatalf("Unable to parse result value: %v", err)
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("Got %s = %f, want %f", query.Select[i].FieldStorage, got, want)
+		}
+	}
+}
+
+func TestGroupSetFirstIsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select first(status) group by host")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	storage := query.Select[0].FieldStorage
+
+	newServer := func(lines ...[2]string) *AggregateSet {
+		server := NewAggregateSet()
+		for _, line := range lines {
+			server.AggregateFirst(storage, line[0], line[1])
+		}
+		return server
+	}
+	// Lines without a timestamp come last; on a tie within a log file the
+	// earlier line wins.
+	a := newServer([2]string{"a-late", "20211002-071300"}, [2]string{"a-notime", ""},
+		[2]string{"a-early", "20211002-071209"}, [2]string{"a-tie", "20211002-071209"})
+	b := newServer([2]string{"b-early", "20211002-071209"})
+	c := newServer([2]string{"c-notime", ""})
+
+	for _, servers := range [][]*AggregateSet{{a, b, c}, {c, b, a}, {b, c, a}} {
+		rows, _, err := mergeServerSets(t, query, servers).result(query, false)
+		if err != nil {
+			t.Fatalf("result() returned unexpected error: %v", err)
+		}
+		// Both servers saw a line at the same second, the smaller value wins.
+		if got := rows[0].values[0]; got != "a-early" {
+			t.Errorf("Got first value %q, want %q", got, "a-early")
+		}
+	}
+
+	if !query.ParserFieldPlan().Needs(FirstOrderField) {
+		t.Errorf("Expected the parser field plan to include %s", FirstOrderField)
+	}
+}
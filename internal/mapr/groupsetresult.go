@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/mimecast/dtail/internal/io/dlog"
@@ -17,8 +18,11 @@ func (g *GroupSet) Result(query *Query, rowsLimit int, renderer ResultRenderer)
 	if err != nil {
 		return "", 0, err
 	}
+	numRows := len(rows)
+	rows = g.resultLimit(query, rows)
 	if query.Limit != -1 {
-		rowsLimit = query.Limit
+		// The limit clause was applied already and overrides the default.
+		rowsLimit = -1
 	}
 	lastColumn := len(query.Select) - 1
 
@@ -33,7 +37,7 @@ func (g *GroupSet) Result(query *Query, rowsLimit int, renderer ResultRenderer)
 	g.resultWriteFormattedHeaderRowSeparator(query, renderer, sb, lastColumn, columnWidths)
 	g.resultWriteFormattedData(query, renderer, sb, lastColumn, rowsLimit, columnWidths, rows)
 
-	return sb.String(), len(rows), nil
+	return sb.String(), numRows, nil
 }
 
 // Write a nicely formatted header for the result data.
@@ -42,7 +46,7 @@ func (g *GroupSet) resultWriteFormattedHeader(query *Query, renderer ResultRende
 
 	for i, sc := range query.Select {
 		format := fmt.Sprintf(" %%%ds ", columnWidths[i])
-		str := fmt.Sprintf(format, sc.FieldStorage)
+		str := fmt.Sprintf(format, sc.name())
 
 		g.resultWriteFormattedHeaderEntry(query, renderer, sb, sc, str)
 		if i == lastColumn {
@@ -64,7 +68,7 @@ func (g *GroupSet) resultWriteFormattedHeaderEntry(query *Query, renderer Result
 			break
 		}
 	}
-	renderer.WriteHeaderEntry(sb, str, sc.FieldStorage == query.OrderBy, isGroupKey)
+	renderer.WriteHeaderEntry(sb, str, query.isOrderKey(sc.FieldStorage), isGroupKey)
 }
 
 func (g *GroupSet) resultWriteFormattedHeaderEntrySeparator(renderer ResultRenderer, sb *strings.Builder) {
@@ -142,6 +146,7 @@ func (g *GroupSet) WriteResult(query *Query, finalResult bool) error {
 	if err != nil {
 		return err
 	}
+	rows = g.resultLimit(query, rows)
 
 	// By default, also write the CSV header.
 	writeHeader := true
@@ -183,11 +188,16 @@ func (g *GroupSet) resultWriteUnformatted(query *Query, rows []result, fd *os.Fi
 	}
 
 	// And now write the data
-	for i, r := range rows {
-		if i == query.Limit {
-			break
-		}
+	for _, r := range rows {
 		for j, value := range r.values {
+			// A histogram has one column per bucket.
+			if counts, ok := r.histograms[j]; ok {
+				columns := make([]string, len(counts))
+				for i, count := range counts {
+					columns[i] = strconv.FormatFloat(count, 'f', -1, 64)
+				}
+				value = strings.Join(columns, protocol.CSVDelimiter)
+			}
 			if _, err := fd.WriteString(value); err != nil {
 				return err
 			}
@@ -221,7 +231,12 @@ func (g *GroupSet) resultWriteUnformatted(query *Query, rows []result, fd *os.Fi
 
 func (g *GroupSet) resultWriteUnformattedHeader(query *Query, fd *os.File, lastColumn int) (err error) {
 	for i, sc := range query.Select {
-		if _, err = fd.WriteString(sc.FieldStorage); err != nil {
+		name := sc.name()
+		if sc.Operation == Histogram {
+			name = strings.Join(histogramColumnNames(name, g.outfileHistogramBounds(query, sc)),
+				protocol.CSVDelimiter)
+		}
+		if _, err = fd.WriteString(name); err != nil {
 			return
 		}
 		if i == lastColumn {
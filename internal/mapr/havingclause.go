@@ -0,0 +1,22 @@
+package mapr
+
+// HavingClause interprets the having clause of the mapreduce query against the
+// rendered values of a single result row (one value per select condition). It
+// is only evaluated on the client once the results of all servers have been
+// merged, as filtering a partial per-server aggregate would not be meaningful.
+// Values can be referred to by their alias as well.
+func (q *Query) HavingClause(values []string) bool {
+	if q.Having == nil {
+		return true
+	}
+	fields := make(map[string]string, len(q.Select))
+	for i, sc := range q.Select {
+		if i < len(values) {
+			fields[sc.FieldStorage] = values[i]
+			if sc.Alias != "" {
+				fields[sc.Alias] = values[i]
+			}
+		}
+	}
+	return q.Having.eval(fields)
+}
@@ -0,0 +1,231 @@
+package mapr
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Suffix of the keys of the histogram buckets, which are stored next to the
+// total count of the histogram, e.g. "histogram(latency,10,50)#le10".
+const histogramSuffix = "#le"
+
+// The range of the values which the fixed columns of an exponential histogram
+// in an outfile cover, see histogramBuckets.outfileBounds.
+const (
+	histogramExpOutfileMin = 1e-6
+	histogramExpOutfileMax = 1e12
+)
+
+// The smallest base of histogram_exp(field,BASE), which bounds the number of
+// buckets, e.g. to the 437 columns of the range above in an outfile.
+const histogramExpMinBase = 1.1
+
+// The characters of the ASCII bars of a histogram in the terminal, from an
+// empty bucket to the fullest bucket of the row.
+const histogramBarLevels = " .:-=+*#%@"
+
+// histogramBuckets are the buckets of the histogram aggregations. Every value
+// is counted in the bucket with the smallest upper bound greater or equal to
+// the value.
+type histogramBuckets struct {
+	// The upper bounds of histogram(field,BOUND1,BOUND2...), in ascending
+	// order. Values above the largest bound are counted in the +Inf bucket.
+	bounds []float64
+	// The base of histogram_exp(field,BASE), whose upper bounds are the
+	// powers of the base. Values of zero and below are counted in the 0
+	// bucket.
+	base float64
+}
+
+// parseHistogramBuckets parses the arguments of histogram(field,BOUND1...)
+// or, if exponential, histogram_exp(field,BASE). It returns the field and the
+// buckets, or ok=false if the arguments are invalid. The field can be a
+// function call with arguments of its own, so the numbers are taken from the
+// end of the arguments.
+func parseHistogramBuckets(args string, exponential bool) (field string, buckets histogramBuckets, ok bool) {
+	field = args
+	var numbers []float64
+	for {
+		comma := strings.LastIndexByte(field, ',')
+		if comma < 0 {
+			break
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(field[comma+1:]), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			break
+		}
+		numbers = append([]float64{f}, numbers...)
+		field = strings.TrimSpace(field[:comma])
+		if exponential {
+			break
+		}
+	}
+	if len(numbers) == 0 || field == "" {
+		return
+	}
+
+	if exponential {
+		buckets.base = numbers[0]
+		return field, buckets, buckets.base >= histogramExpMinBase
+	}
+	for i := 1; i < len(numbers); i++ {
+		if numbers[i] <= numbers[i-1] {
+			return
+		}
+	}
+	buckets.bounds = numbers
+	return field, buckets, true
+}
+
+// bucket returns the upper bound of the bucket of a value.
+func (h histogramBuckets) bucket(value float64) float64 {
+	if h.base == 0 {
+		i := sort.SearchFloat64s(h.bounds, value)
+		if i == len(h.bounds) {
+			return math.Inf(1)
+		}
+		return h.bounds[i]
+	}
+	if value <= 0 {
+		return 0
+	}
+	exponent := math.Ceil(math.Log(value) / math.Log(h.base))
+	// Guard against floating point errors, so that the powers of the base
+	// are counted in their own bucket.
+	if math.Pow(h.base, exponent-1) >= value {
+		exponent--
+	}
+	return math.Pow(h.base, exponent)
+}
+
+// outfileBounds returns the upper bounds of the fixed buckets of an
+// exponential histogram in an outfile: 0, the powers of the base covering
+// histogramExpOutfileMin to histogramExpOutfileMax and +Inf. Smaller positive
+// values are counted in the smallest power, larger ones in +Inf. Unlike in the
+// terminal, the buckets don't depend on the values, so that the rows appended
+// to an outfile at every interval match its CSV header.
+func (h histogramBuckets) outfileBounds() []float64 {
+	bounds := []float64{0}
+	// The powers are computed like in bucket, so that they are the same.
+	exponent := math.Round(math.Log(h.bucket(histogramExpOutfileMin)) / math.Log(h.base))
+	for {
+		bound := math.Pow(h.base, exponent)
+		bounds = append(bounds, bound)
+		if bound >= histogramExpOutfileMax {
+			break
+		}
+		exponent++
+	}
+	return append(bounds, math.Inf(1))
+}
+
+// histogramKey returns the key of the count of a histogram bucket.
+func histogramKey(key string, bound float64) string {
+	return key + histogramSuffix + strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// isHistogramKey returns true if k is the total count or the count of a
+// bucket of the histogram stored under the given key.
+func isHistogramKey(key, k string) bool {
+	return k == key || (strings.HasPrefix(k, key+histogramSuffix) && len(k) > len(key))
+}
+
+// AggregateHistogram counts a value of a histogram aggregation in the bucket
+// with the given upper bound, see HistogramBucket.
+func (s *AggregateSet) AggregateHistogram(key string, bound float64) {
+	s.addFloat(key, 1)
+	s.addFloat(histogramKey(key, bound), 1)
+}
+
+// mergeHistogram adds the counts of the histogram stored under the given key
+// of another aggregate set, or as serialized by a server, to this one.
+func (s *AggregateSet) mergeHistogram(key string, values map[string]float64) {
+	for k, count := range values {
+		if isHistogramKey(key, k) {
+			s.addFloat(k, count)
+		}
+	}
+}
+
+// histogramBounds returns the upper bounds of the buckets of a histogram
+// stored under the given key of the aggregate set, in ascending order.
+func (s *AggregateSet) histogramBounds(key string) []float64 {
+	var bounds []float64
+	for k := range s.FValues {
+		if k == key || !isHistogramKey(key, k) {
+			continue
+		}
+		bound, err := strconv.ParseFloat(k[len(key)+len(histogramSuffix):], 64)
+		if err == nil {
+			bounds = append(bounds, bound)
+		}
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+// histogramCounts returns the counts of the buckets with the given upper
+// bounds of a histogram stored under the given key. A bucket whose bound isn't
+// one of them is counted in the one with the next larger bound, e.g. in the
+// fixed buckets of an outfile.
+func (s *AggregateSet) histogramCounts(key string, bounds []float64) []float64 {
+	counts := make([]float64, len(bounds))
+	for _, bound := range s.histogramBounds(key) {
+		if i := sort.SearchFloat64s(bounds, bound); i < len(bounds) {
+			counts[i] += s.FValues[histogramKey(key, bound)]
+		}
+	}
+	return counts
+}
+
+// mergeBounds returns the union of two sorted lists of bucket bounds.
+func mergeBounds(a, b []float64) []float64 {
+	merged := make([]float64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			merged, a = append(merged, a[0]), a[1:]
+		case a[0] > b[0]:
+			merged, b = append(merged, b[0]), b[1:]
+		default:
+			merged, a, b = append(merged, a[0]), a[1:], b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// histogramBar renders the counts of the buckets as an ASCII bar, one
+// character per bucket, whose height is relative to the fullest bucket.
+// Non-empty buckets are always visible.
+func histogramBar(counts []float64) string {
+	var fullest float64
+	for _, count := range counts {
+		fullest = math.Max(fullest, count)
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('[')
+	top := len(histogramBarLevels) - 1
+	for _, count := range counts {
+		level := 0
+		if count > 0 {
+			level = max(1, int(math.Round(count/fullest*float64(top))))
+		}
+		sb.WriteByte(histogramBarLevels[level])
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// histogramColumnNames returns the names of the CSV columns of the buckets
+// of a histogram, e.g. "latency<=10" or "latency<=+Inf".
+func histogramColumnNames(name string, bounds []float64) []string {
+	names := make([]string, len(bounds))
+	for i, bound := range bounds {
+		names[i] = name + "<=" + strconv.FormatFloat(bound, 'g', -1, 64)
+	}
+	return names
+}
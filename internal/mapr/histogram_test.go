@@ -0,0 +1,213 @@
+package mapr
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	t.Parallel()
+
+	field, buckets, ok := parseHistogramBuckets("latency, 10, 50, 100", false)
+	if !ok || field != "latency" {
+		t.Fatalf("Unable to parse histogram buckets, got field %q", field)
+	}
+	for value, want := range map[float64]float64{
+		-1: 10, 10: 10, 10.5: 50, 100: 100, 101: math.Inf(1),
+	} {
+		if got := buckets.bucket(value); got != want {
+			t.Errorf("Got bucket %v for %v, want %v", got, value, want)
+		}
+	}
+
+	field, buckets, ok = parseHistogramBuckets("parse_duration(took,ms),2", true)
+	if !ok || field != "parse_duration(took,ms)" {
+		t.Fatalf("Unable to parse exponential histogram buckets, got field %q", field)
+	}
+	for value, want := range map[float64]float64{
+		-3: 0, 0: 0, 0.3: 0.5, 1: 1, 3: 4, 4: 4, 1024: 1024, 1025: 2048,
+	} {
+		if got := buckets.bucket(value); got != want {
+			t.Errorf("Got exponential bucket %v for %v, want %v", got, value, want)
+		}
+	}
+
+	for _, args := range []string{"latency", "latency,50,10", "latency,10,10", ",10"} {
+		if _, _, ok := parseHistogramBuckets(args, false); ok {
+			t.Errorf("Expected invalid histogram buckets %q", args)
+		}
+	}
+	for _, args := range []string{"latency", "latency,1", "latency,0.5", "latency,1.01"} {
+		if _, _, ok := parseHistogramBuckets(args, true); ok {
+			t.Errorf("Expected invalid exponential histogram buckets %q", args)
+		}
+	}
+
+	for _, queryStr := range []string{"select histogram(latency)", "select histogram_exp(latency,1)",
+		"select histogram(latency,b)"} {
+		if _, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected an error parsing query %q", queryStr)
+		}
+	}
+
+	// Small bases would make for thousands of buckets, e.g. of outfile columns.
+	_, err := NewQuery("select histogram_exp(latency,1.01)")
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || !strings.Contains(err.Error(), "base of at least 1.1") {
+		t.Errorf("Expected a query error about the base, got %v", err)
+	}
+	if _, err := NewQuery("select histogram_exp(latency,1.1)"); err != nil {
+		t.Errorf("Unable to parse query with the smallest base: %v", err)
+	}
+	if got := len(histogramBuckets{base: histogramExpMinBase}.outfileBounds()); got != 437 {
+		t.Errorf("Got %d outfile buckets of the smallest base, want 437", got)
+	}
+
+	if got := histogramBar([]float64{0, 1, 50, 100}); got != "[ .+@]" {
+		t.Errorf("Got histogram bar %q", got)
+	}
+}
+
+func TestGroupSetHistogramMerge(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select histogram(latency,10,100),histogram_exp(latency,10) " +
+		"group by host order by histogram(latency,10,100)")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	// The histograms of the servers merge by adding up the bucket counts.
+	var servers []*AggregateSet
+	for _, values := range [][]float64{{5, 50}, {7, 500, 1000}, {3}} {
+		server := NewAggregateSet()
+		for _, value := range values {
+			for _, sc := range query.Select {
+				server.AggregateHistogram(sc.FieldStorage, sc.HistogramBucket(value))
+			}
+			server.Samples++
+		}
+		servers = append(servers, server)
+	}
+	group := mergeServerSets(t, query, servers)
+
+	set := group.sets["host"]
+	for storage, want := range map[string][]float64{
+		query.Select[0].FieldStorage: {3, 1, 2},
+		query.Select[1].FieldStorage: {3, 1, 2},
+	} {
+		bounds := set.histogramBounds(storage)
+		if got := set.histogramCounts(storage, bounds); !slices.Equal(got, want) {
+			t.Errorf("Got %s bucket counts %v for bounds %v, want %v", storage, got, bounds, want)
+		}
+		if set.FValues[storage] != 6 {
+			t.Errorf("Got %s total count %v, want 6", storage, set.FValues[storage])
+		}
+	}
+
+	rows, _, err := group.result(query, false)
+	if err != nil {
+		t.Fatalf("result() returned unexpected error: %v", err)
+	}
+	if got := strings.Join(rows[0].values, " "); got != "[@-*] [@-*]" {
+		t.Errorf("Got histogram bars %q", got)
+	}
+	if rows[0].orderBy[0].value != 6 {
+		t.Errorf("Expected to order by the total count, got %v", rows[0].orderBy[0].value)
+	}
+}
+
+func TestGroupSetHistogramOutfile(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "histogram.csv")
+	query, err := NewQuery("select path,histogram(latency,10,100) as latency,histogram_exp(bytes,2) " +
+		"group by path outfile \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	group := NewGroupSet()
+	for path, values := range map[string][]float64{"/a": {1, 20, 30}, "/b": {200, 3}} {
+		set := group.GetSet(path)
+		set.setString("path", path)
+		for _, value := range values {
+			for _, sc := range query.Select[1:] {
+				set.AggregateHistogram(sc.FieldStorage, sc.HistogramBucket(value))
+			}
+		}
+	}
+
+	if err := group.WriteResult(query, true); err != nil {
+		t.Fatalf("WriteResult() returned unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	want := "path,latency<=10,latency<=100,latency<=+Inf," +
+		"histogram_exp(bytes,2)<=1,histogram_exp(bytes,2)<=4,histogram_exp(bytes,2)<=32," +
+		"histogram_exp(bytes,2)<=256\n" +
+		"/a,1,2,0,1,0,2,0\n" +
+		"/b,1,0,1,0,1,0,1\n"
+	if string(data) != want {
+		t.Errorf("Got outfile content %q, want %q", string(data), want)
+	}
+}
+
+// TestGroupSetHistogramOutfileAppend verifies that the rows appended at every
+// interval match the CSV header, even if the values of the intervals fall into
+// different exponential buckets.
+func TestGroupSetHistogramOutfileAppend(t *testing.T) {
+	quietCommonLogger(t)
+
+	outfile := filepath.Join(t.TempDir(), "histogram.csv")
+	query, err := NewQuery("select path,histogram_exp(bytes,10) as bytes " +
+		"group by path outfile append \"" + outfile + "\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+
+	for _, values := range [][]float64{{3, 20}, {0.5, 5000, 1e15}} {
+		group := NewGroupSet()
+		set := group.GetSet("/a")
+		set.setString("path", "/a")
+		for _, value := range values {
+			set.AggregateHistogram(query.Select[1].FieldStorage, query.Select[1].HistogramBucket(value))
+		}
+		if err := group.WriteResult(query, false); err != nil {
+			t.Fatalf("WriteResult() returned unexpected error: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("Unable to read outfile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two rows, got:\n%s", data)
+	}
+	header := strings.Split(lines[0], ",")
+	for i, want := range map[int][]string{1: {"bytes<=10", "bytes<=100"}, 2: {"bytes<=1",
+		"bytes<=10000", "bytes<=+Inf"}} {
+		row := strings.Split(lines[i], ",")
+		if len(row) != len(header) {
+			t.Fatalf("Row %d has %d columns, but the header has %d:\n%s", i, len(row), len(header), data)
+		}
+		var got []string
+		for j, count := range row[1:] {
+			if count != "0" {
+				got = append(got, header[j+1])
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Got non-empty buckets %v in row %d, want %v", got, i, want)
+		}
+	}
+}
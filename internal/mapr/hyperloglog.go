@@ -0,0 +1,155 @@
+package mapr
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// The HyperLogLog precision: 2^12 registers, which gives a standard error of
+// about 1.6% while keeping a dense sketch at 4KiB per group and field.
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+)
+
+// Encoding formats of a serialized hyperLogLog sketch.
+const (
+	hllDense  byte = 0
+	hllSparse byte = 1
+)
+
+// hyperLogLog is a mergeable sketch estimating the number of distinct values
+// added to it, used by the count_distinct aggregation. Sketches of many
+// servers can be merged into one without losing precision, and the memory
+// used stays bounded regardless of the cardinality.
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{}
+}
+
+// add a value to the sketch.
+func (h *hyperLogLog) add(value string) {
+	hash := hllHash(value)
+	index := hash >> (64 - hllPrecision)
+	// The guard bit caps the rank at 64-hllPrecision+1.
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if h.registers[index] < rank {
+		h.registers[index] = rank
+	}
+}
+
+// merge another sketch into this one.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, rank := range other.registers {
+		if h.registers[i] < rank {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// count returns the estimated number of distinct values.
+func (h *hyperLogLog) count() uint64 {
+	var sum float64
+	var zeros int
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Use linear counting for small cardinalities, where the raw estimate is
+	// known to be biased.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// encode the sketch so that it can be sent over the wire. Sketches with only
+// a few registers set are encoded sparsely as index/rank pairs.
+func (h *hyperLogLog) encode() string {
+	var used int
+	for _, rank := range h.registers {
+		if rank > 0 {
+			used++
+		}
+	}
+
+	if used*3 >= hllRegisters {
+		buf := make([]byte, 0, 2+hllRegisters)
+		buf = append(buf, hllDense, hllPrecision)
+		buf = append(buf, h.registers[:]...)
+		return base64.StdEncoding.EncodeToString(buf)
+	}
+
+	buf := make([]byte, 0, 2+used*3)
+	buf = append(buf, hllSparse, hllPrecision)
+	for i, rank := range h.registers {
+		if rank > 0 {
+			buf = binary.BigEndian.AppendUint16(buf, uint16(i))
+			buf = append(buf, rank)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// decodeHyperLogLog decodes a sketch previously encoded by encode.
+func decodeHyperLogLog(encoded string) (*hyperLogLog, error) {
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 || buf[1] != hllPrecision {
+		return nil, errors.New("Unable to decode count_distinct sketch: unexpected header")
+	}
+
+	h := newHyperLogLog()
+	data := buf[2:]
+	switch buf[0] {
+	case hllDense:
+		if len(data) != hllRegisters {
+			return nil, errors.New("Unable to decode count_distinct sketch: unexpected length")
+		}
+		copy(h.registers[:], data)
+	case hllSparse:
+		if len(data)%3 != 0 {
+			return nil, errors.New("Unable to decode count_distinct sketch: unexpected length")
+		}
+		for i := 0; i < len(data); i += 3 {
+			index := binary.BigEndian.Uint16(data[i:])
+			if int(index) >= hllRegisters {
+				return nil, errors.New("Unable to decode count_distinct sketch: register out of range")
+			}
+			h.registers[index] = data[i+2]
+		}
+	default:
+		return nil, errors.New("Unable to decode count_distinct sketch: unknown format")
+	}
+	return h, nil
+}
+
+// hllHash hashes a value. The hash must be identical on all servers, so that
+// their sketches can be merged. FNV-1a is finalized with the murmur3 mixer,
+// as its high bits alone are not distributed evenly enough for HyperLogLog.
+func hllHash(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
@@ -0,0 +1,92 @@
+package mapr
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func assertDistinctEstimate(t *testing.T, got uint64, want int) {
+	t.Helper()
+	// Allow for four times the standard error of the sketch.
+	tolerance := math.Max(1, float64(want)*4*1.04/math.Sqrt(hllRegisters))
+	if math.Abs(float64(got)-float64(want)) > tolerance {
+		t.Errorf("Estimated %d distinct values, want %d (+/- %.0f)", got, want, tolerance)
+	}
+}
+
+func TestHyperLogLogCount(t *testing.T) {
+	t.Parallel()
+
+	for _, cardinality := range []int{0, 1, 10, 1000, 100000} {
+		h := newHyperLogLog()
+		for i := 0; i < cardinality; i++ {
+			// Add every value twice, duplicates must not be counted.
+			h.add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+			h.add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		}
+		assertDistinctEstimate(t, h.count(), cardinality)
+	}
+}
+
+func TestHyperLogLogEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	// Small cardinalities are encoded sparsely, large ones densely.
+	for _, cardinality := range []int{0, 50, 50000} {
+		h := newHyperLogLog()
+		for i := 0; i < cardinality; i++ {
+			h.add(fmt.Sprintf("user%d", i))
+		}
+		decoded, err := decodeHyperLogLog(h.encode())
+		if err != nil {
+			t.Fatalf("Unable to decode sketch of %d values: %v", cardinality, err)
+		}
+		if decoded.registers != h.registers {
+			t.Errorf("Decoded sketch of %d values differs from the original", cardinality)
+		}
+	}
+
+	for _, encoded := range []string{"", "not base64!", "AAA=", "AgwA"} {
+		if _, err := decodeHyperLogLog(encoded); err == nil {
+			t.Errorf("Expected an error decoding sketch %q", encoded)
+		}
+	}
+}
+
+func TestCountDistinctMergesAcrossServers(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery("select path,count_distinct(ip) group by path")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if query.Select[1].Operation != CountDistinct {
+		t.Fatalf("Expected count_distinct operation, got %v", query.Select[1].Operation)
+	}
+	storage := query.Select[1].FieldStorage
+
+	// Two servers seeing overlapping client IPs: 0..5999 and 4000..9999.
+	global := NewAggregateSet()
+	for _, ipRange := range [][2]int{{0, 6000}, {4000, 10000}} {
+		server := NewAggregateSet()
+		for i := ipRange[0]; i < ipRange[1]; i++ {
+			ip := fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+			if err := server.Aggregate(storage, CountDistinct, ip, false); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+		}
+
+		// The client receives the sketch encoded, as sent by Serialize.
+		client := NewAggregateSet()
+		encoded := server.distinct[storage].encode()
+		if err := client.Aggregate(storage, CountDistinct, encoded, true); err != nil {
+			t.Fatalf("Client aggregate failed: %v", err)
+		}
+		if err := global.Merge(query, client); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+	}
+
+	assertDistinctEstimate(t, global.DistinctCount(storage), 10000)
+}
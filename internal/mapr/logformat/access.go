@@ -0,0 +1,214 @@
+package logformat
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The layout of the timestamps of the access logs, e.g. [10/Oct/2000:13:55:36 -0700].
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogParser parses web server access logs in the combined log format,
+// e.g.
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326 "http://example.org/" "Mozilla/5.0"
+//
+// The referer and user agent are optional, so the common log format is
+// understood too. Tokens following the user agent are either key=value pairs,
+// which become fields as they are, or the request time.
+type accessLogParser struct {
+	defaultParser
+	// The unit of the request time in seconds. Apache logs it in microseconds
+	// (%D), nginx in seconds ($request_time).
+	requestTimeUnit float64
+}
+
+var _ Parser = (*accessLogParser)(nil)
+
+// newCombinedParser returns the parser of the Apache combined log format.
+func newCombinedParser(hostname, timeZoneName string, timeZoneOffset int) (*accessLogParser, error) {
+	return newAccessLogParser(hostname, timeZoneName, timeZoneOffset, 1e-6)
+}
+
+// newNginxParser returns the parser of the nginx combined log format.
+func newNginxParser(hostname, timeZoneName string, timeZoneOffset int) (*accessLogParser, error) {
+	return newAccessLogParser(hostname, timeZoneName, timeZoneOffset, 1)
+}
+
+func newAccessLogParser(hostname, timeZoneName string, timeZoneOffset int,
+	requestTimeUnit float64) (*accessLogParser, error) {
+
+	defaultParser, err := newDefaultParser(hostname, timeZoneName, timeZoneOffset)
+	if err != nil {
+		return &accessLogParser{}, err
+	}
+	return &accessLogParser{defaultParser: *defaultParser, requestTimeUnit: requestTimeUnit}, nil
+}
+
+func (p *accessLogParser) MakeFields(maprLine, _ string) (map[string]string, error) {
+	s := accessLogScanner{line: maprLine}
+	remoteAddr, ok := s.token()
+	if !ok {
+		return nil, ErrIgnoreFields
+	}
+	if _, ok = s.token(); !ok { // The RFC 1413 identity, always "-".
+		return nil, ErrIgnoreFields
+	}
+	remoteUser, ok := s.token()
+	if !ok {
+		return nil, ErrIgnoreFields
+	}
+	timestamp, ok := s.delimited('[', ']')
+	if !ok {
+		return nil, ErrIgnoreFields
+	}
+	request, ok := s.delimited('"', '"')
+	if !ok {
+		return nil, ErrIgnoreFields
+	}
+	status, ok := s.token()
+	if !ok || !isDigits(status) {
+		return nil, ErrIgnoreFields
+	}
+	bytes, ok := s.token()
+	if !ok {
+		return nil, ErrIgnoreFields
+	}
+
+	fields := make(map[string]string, p.fieldsCapacity)
+	p.addDefaultFields(fields, maprLine)
+	p.addAccessTime(fields, timestamp)
+	p.addDynamicField(fields, "remote_addr", remoteAddr)
+	p.addDynamicField(fields, "remote_user", remoteUser)
+	p.addRequest(fields, request)
+	p.addDynamicField(fields, "status", status)
+	if bytes == "-" {
+		// No body was sent.
+		bytes = "0"
+	}
+	p.addDynamicField(fields, "bytes", bytes)
+
+	if referer, ok := s.delimited('"', '"'); ok {
+		p.addDynamicField(fields, "referer", referer)
+		if userAgent, ok := s.delimited('"', '"'); ok {
+			p.addDynamicField(fields, "user_agent", userAgent)
+		}
+	}
+	p.addTrailingFields(fields, &s)
+
+	return fields, nil
+}
+
+// addAccessTime maps the timestamp of the request onto the time fields of the
+// default log format, see defaultParser.addTimeFields.
+func (p *accessLogParser) addAccessTime(fields map[string]string, timestamp string) {
+	if !p.wantTimestamp() {
+		return
+	}
+	t, err := time.Parse(accessLogTimeLayout, timestamp)
+	if err != nil {
+		if p.wantTime {
+			fields["$time"] = timestamp
+		}
+		return
+	}
+	p.addTimeFields(fields, t)
+}
+
+// addRequest splits the request line, e.g. "GET /index.html HTTP/1.0", into
+// the method, path and protocol. Malformed requests, e.g. "-", have none of
+// them.
+func (p *accessLogParser) addRequest(fields map[string]string, request string) {
+	method, rest, ok := strings.Cut(request, " ")
+	if !ok || method == "" {
+		return
+	}
+	path, protocol, _ := strings.Cut(rest, " ")
+	p.addDynamicField(fields, "method", method)
+	p.addDynamicField(fields, "path", path)
+	if protocol != "" {
+		p.addDynamicField(fields, "protocol", protocol)
+	}
+}
+
+// addTrailingFields adds the tokens following the user agent. key=value
+// pairs are added as they are, with rt as an alias of request_time, and the
+// first other token is the request time.
+func (p *accessLogParser) addTrailingFields(fields map[string]string, s *accessLogScanner) {
+	haveRequestTime := false
+	for {
+		token, ok := s.token()
+		if !ok {
+			return
+		}
+		key, value, isPair := strings.Cut(token, "=")
+		value = strings.Trim(value, `"`)
+		switch {
+		case isPair && key != "rt" && key != "request_time":
+			p.addDynamicField(fields, key, value)
+			continue
+		case isPair:
+		case haveRequestTime:
+			continue
+		default:
+			value = token
+		}
+		haveRequestTime = true
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			p.addDynamicField(fields, "request_time",
+				strconv.FormatFloat(f*p.requestTimeUnit, 'f', -1, 64))
+		}
+	}
+}
+
+// accessLogScanner reads the space separated tokens of an access log line.
+type accessLogScanner struct {
+	line string
+	pos  int
+}
+
+func (s *accessLogScanner) skipSpace() {
+	for s.pos < len(s.line) && s.line[s.pos] == ' ' {
+		s.pos++
+	}
+}
+
+// token returns the next space separated token.
+func (s *accessLogScanner) token() (string, bool) {
+	s.skipSpace()
+	start := s.pos
+	for s.pos < len(s.line) && s.line[s.pos] != ' ' {
+		s.pos++
+	}
+	return s.line[start:s.pos], s.pos > start
+}
+
+// delimited returns the next token enclosed in the given delimiters, e.g. a
+// quoted string. Escaped delimiters, e.g. \", are skipped but kept as they are.
+func (s *accessLogScanner) delimited(open, closing byte) (string, bool) {
+	s.skipSpace()
+	if s.pos >= len(s.line) || s.line[s.pos] != open {
+		return "", false
+	}
+	for i := s.pos + 1; i < len(s.line); i++ {
+		switch s.line[i] {
+		case '\\':
+			i++
+		case closing:
+			value := s.line[s.pos+1 : i]
+			s.pos = i + 1
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
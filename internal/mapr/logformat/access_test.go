@@ -0,0 +1,179 @@
+package logformat
+
+import (
+	"testing"
+
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		logFormat string
+		input     string
+		want      map[string]string
+		absent    []string
+	}{
+		{
+			logFormat: "combined",
+			input: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 ` +
+				`"http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)" 1500`,
+			want: map[string]string{
+				"remote_addr":  "127.0.0.1",
+				"remote_user":  "frank",
+				"method":       "GET",
+				"path":         "/apache_pb.gif",
+				"protocol":     "HTTP/1.0",
+				"status":       "200",
+				"bytes":        "2326",
+				"referer":      "http://www.example.com/start.html",
+				"user_agent":   "Mozilla/4.08 [en] (Win98; I ;Nav)",
+				"request_time": "0.0015",
+				"$time":        "20001010-135536",
+				"$date":        "20001010",
+				"$hour":        "13",
+				"$minute":      "55",
+				"$second":      "36",
+			},
+		},
+		{
+			// The common log format has no referer and user agent.
+			logFormat: "combined",
+			input:     `::1 - - [02/Oct/2021:07:23:42 +0000] "POST /login HTTP/1.1" 302 -`,
+			want:      map[string]string{"remote_addr": "::1", "path": "/login", "status": "302", "bytes": "0"},
+			absent:    []string{"referer", "user_agent", "request_time"},
+		},
+		{
+			logFormat: "nginx",
+			input: `10.0.0.1 - - [02/Oct/2021:07:23:42 +0200] "GET /search?q=\"x y\" HTTP/2.0" 404 153 ` +
+				`"-" "curl/7.79.1" 0.042 upstream=10.0.0.9:80 uct="0.001"`,
+			want: map[string]string{
+				"path":         `/search?q=\"x`,
+				"status":       "404",
+				"referer":      "-",
+				"user_agent":   "curl/7.79.1",
+				"request_time": "0.042",
+				"upstream":     "10.0.0.9:80",
+				"uct":          "0.001",
+				"$hour":        "07",
+			},
+		},
+		{
+			logFormat: "nginx",
+			input:     `10.0.0.1 - - [02/Oct/2021:07:23:42 +0200] "-" 400 0 "-" "-" rt=1.5`,
+			want:      map[string]string{"status": "400", "request_time": "1.5"},
+			absent:    []string{"method", "path", "protocol"},
+		},
+	}
+
+	for _, tt := range tests {
+		parser, err := NewParser(tt.logFormat, nil)
+		if err != nil {
+			t.Fatalf("Unable to create parser: %s", err.Error())
+		}
+		fields, err := parser.MakeFields(tt.input, "")
+		if err != nil {
+			t.Errorf("Parser unable to make fields of '%s': %s", tt.input, err.Error())
+			continue
+		}
+		for field, want := range tt.want {
+			if val, ok := fields[field]; !ok {
+				t.Errorf("Expected field '%s' in '%s', but no such field there", field, tt.input)
+			} else if val != want {
+				t.Errorf("Expected '%s' stored in field '%s', but got '%s' in '%s'",
+					want, field, val, tt.input)
+			}
+		}
+		for _, field := range tt.absent {
+			if _, ok := fields[field]; ok {
+				t.Errorf("Expected field '%s' to be absent in '%s'", field, tt.input)
+			}
+		}
+	}
+
+	parser, err := NewParser("nginx", nil)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+	for _, input := range []string{
+		"",
+		"INFO|20211002-072342|1|access_test.go:0|MAPREDUCE:STATS|foo=bar",
+		`127.0.0.1 - - 10/Oct/2000:13:55:36 "GET / HTTP/1.0" 200 1`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0 200 1`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" OK 1`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200`,
+	} {
+		if _, err := parser.MakeFields(input, ""); err != ErrIgnoreFields {
+			t.Errorf("Expected to ignore invalid line '%s', got %v", input, err)
+		}
+	}
+}
+
+func TestAccessLogFormatQuerySpecificFields(t *testing.T) {
+	q, err := mapr.NewQuery(`select count(path),avg(request_time) group by status,bucket($time,1h) ` +
+		`logformat nginx`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	if q.Table != "." {
+		t.Errorf("Expected the nginx log format to default the table to '.', got '%s'", q.Table)
+	}
+	parser, err := NewParser("nginx", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	fields, err := parser.MakeFields(`10.0.0.1 - - [02/Oct/2021:07:23:42 +0200] "GET / HTTP/1.1" 200 612 `+
+		`"-" "curl/7.79.1" 0.005`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	for field, want := range map[string]string{
+		"path": "/", "status": "200", "request_time": "0.005", "$time": "20211002-072342",
+	} {
+		if val := fields[field]; val != want {
+			t.Errorf("Expected '%s' stored in query-specific field '%s', but got '%s'", want, field, val)
+		}
+	}
+	for _, field := range []string{"remote_addr", "bytes", "user_agent", "method", "$date"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected query-specific field '%s' to be omitted", field)
+		}
+	}
+}
+
+func BenchmarkAccessLogParserMakeFields(b *testing.B) {
+	input := `10.0.0.1 - - [02/Oct/2021:07:23:42 +0200] "GET /api/v1/items?page=2 HTTP/1.1" 200 5120 ` +
+		`"https://example.org/" "Mozilla/5.0 (X11; Linux x86_64)" 0.012`
+
+	b.Run("all_fields", func(b *testing.B) {
+		parser, err := NewParser("nginx", nil)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+
+	b.Run("query_specific", func(b *testing.B) {
+		q, err := mapr.NewQuery(`select count(path) group by status logformat nginx`)
+		if err != nil {
+			b.Fatalf("Unable to create query: %s", err.Error())
+		}
+		parser, err := NewParser("nginx", q)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+}
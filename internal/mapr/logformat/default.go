@@ -3,8 +3,10 @@ package logformat
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mimecast/dtail/internal/mapr"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 	"github.com/mimecast/dtail/internal/protocol"
 )
 
@@ -170,6 +172,70 @@ func (p *defaultParser) addDefaultFields(fields map[string]string, maprLine stri
 	}
 }
 
+// addTimestampFields maps a timestamp of another log format (e.g. RFC 3339)
+// onto the time fields of the default log format. $time keeps the timestamp as
+// it is, the other fields are only set if it can be parsed, with the given
+// layout or, if empty, any of the layouts known to funcs.ParseTime.
+func (p *defaultParser) addTimestampFields(fields map[string]string, value, layout string) {
+	if p.wantTime {
+		fields["$time"] = value
+	}
+	if !p.wantDate && !p.wantHour && !p.wantMinute && !p.wantSecond {
+		return
+	}
+	var t time.Time
+	var ok bool
+	if layout == "" {
+		t, ok = funcs.ParseTime(value)
+	} else {
+		var err error
+		t, err = time.Parse(layout, value)
+		ok = err == nil
+	}
+	if !ok {
+		return
+	}
+	if p.wantDate {
+		fields["$date"] = t.Format("20060102")
+	}
+	if p.wantHour {
+		fields["$hour"] = t.Format("15")
+	}
+	if p.wantMinute {
+		fields["$minute"] = t.Format("04")
+	}
+	if p.wantSecond {
+		fields["$second"] = t.Format("05")
+	}
+}
+
+// addTimeFields sets the time fields of the default log format to a parsed
+// timestamp of another log format. $time is in the same format as in the
+// default log format, e.g. 20211002-072342, so that it sorts and buckets the
+// same way.
+func (p *defaultParser) addTimeFields(fields map[string]string, t time.Time) {
+	if p.wantTime {
+		fields["$time"] = t.Format("20060102-150405")
+	}
+	if p.wantDate {
+		fields["$date"] = t.Format("20060102")
+	}
+	if p.wantHour {
+		fields["$hour"] = t.Format("15")
+	}
+	if p.wantMinute {
+		fields["$minute"] = t.Format("04")
+	}
+	if p.wantSecond {
+		fields["$second"] = t.Format("05")
+	}
+}
+
+// wantTimestamp returns true if the query references any of the time fields.
+func (p *defaultParser) wantTimestamp() bool {
+	return p.wantTime || p.wantDate || p.wantHour || p.wantMinute || p.wantSecond
+}
+
 func (p *defaultParser) addDynamicField(fields map[string]string, key string, value string) {
 	if p.allDynamicFields {
 		fields[key] = value
@@ -0,0 +1,312 @@
+package logformat
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/mimecast/dtail/internal/config"
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+// DefaultJSONTimeKey is the key of the timestamp of the json log format,
+// unless configured otherwise with MapreduceJSONTimeKey.
+const DefaultJSONTimeKey = "time"
+
+var errInvalidJSON = errors.New("invalid JSON log line")
+
+// jsonParser parses JSON Lines (NDJSON) logs, one JSON object per line. Nested
+// objects are flattened into dotted field names, e.g. {"req":{"path":"/"}}
+// becomes the field req.path. Only the fields the query references are
+// decoded, all other values are skipped without allocating.
+type jsonParser struct {
+	defaultParser
+	// The (possibly dotted) key of the timestamp, mapped onto $time, $date,
+	// $hour, $minute and $second.
+	timeKey     string
+	wantTimeKey bool
+	// The objects which contain a referenced field, e.g. req for req.path.
+	// Other objects are skipped as a whole.
+	objects map[string]struct{}
+}
+
+var _ Parser = (*jsonParser)(nil)
+
+func newJSONParser(hostname, timeZoneName string, timeZoneOffset int) (*jsonParser, error) {
+	defaultParser, err := newDefaultParser(hostname, timeZoneName, timeZoneOffset)
+	if err != nil {
+		return &jsonParser{}, err
+	}
+	timeKey := DefaultJSONTimeKey
+	if config.Server != nil && config.Server.MapreduceJSONTimeKey != "" {
+		timeKey = config.Server.MapreduceJSONTimeKey
+	}
+	p := &jsonParser{defaultParser: *defaultParser, timeKey: timeKey}
+	p.configureObjects()
+	return p, nil
+}
+
+func (p *jsonParser) setQuery(query *mapr.Query) {
+	p.defaultParser.setQuery(query)
+	p.configureObjects()
+}
+
+// configureObjects determines which objects have to be decoded for the fields
+// of the query, including the timestamp.
+func (p *jsonParser) configureObjects() {
+	p.wantTimeKey = p.wantTimestamp()
+	p.objects = make(map[string]struct{})
+	addObjects := func(field string) {
+		for i := strings.IndexByte(field, '.'); i > 0; {
+			p.objects[field[:i]] = struct{}{}
+			next := strings.IndexByte(field[i+1:], '.')
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+	for field := range p.dynamicFields {
+		addObjects(field)
+	}
+	if p.wantTimeKey {
+		addObjects(p.timeKey)
+	}
+}
+
+func (p *jsonParser) MakeFields(maprLine, _ string) (map[string]string, error) {
+	fields := make(map[string]string, p.fieldsCapacity)
+	p.addDefaultFields(fields, maprLine)
+
+	var path [64]byte
+	s := jsonScanner{line: maprLine, path: path[:0]}
+	s.skipSpace()
+	if err := s.object(p, fields); err != nil || s.pos < len(s.line) {
+		return nil, ErrIgnoreFields
+	}
+	return fields, nil
+}
+
+// wantField returns true if the value at the path has to be decoded. The
+// lookups convert the path without allocating.
+func (p *jsonParser) wantField(path []byte) bool {
+	if p.allDynamicFields || (p.wantTimeKey && string(path) == p.timeKey) {
+		return true
+	}
+	_, ok := p.dynamicFields[string(path)]
+	return ok
+}
+
+// wantObject returns true if the object at the path contains a field which
+// has to be decoded.
+func (p *jsonParser) wantObject(path []byte) bool {
+	if p.allDynamicFields {
+		return true
+	}
+	_, ok := p.objects[string(path)]
+	return ok
+}
+
+// addField stores a decoded value, and the time fields of the timestamp.
+func (p *jsonParser) addField(fields map[string]string, path []byte, value string) {
+	if p.wantTimeKey && string(path) == p.timeKey {
+		p.addTimestampFields(fields, value, "")
+	}
+	p.addDynamicField(fields, string(path), value)
+}
+
+// jsonScanner walks through a JSON document without building it up in memory.
+type jsonScanner struct {
+	line string
+	pos  int
+	// The dotted path of the current value.
+	path []byte
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.line) {
+		switch s.line[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips the given byte, and the white space following it.
+func (s *jsonScanner) consume(c byte) bool {
+	if s.pos >= len(s.line) || s.line[s.pos] != c {
+		return false
+	}
+	s.pos++
+	s.skipSpace()
+	return true
+}
+
+// object scans the object at the current path and adds the wanted fields. The
+// path is empty for the top level object.
+func (s *jsonScanner) object(p *jsonParser, fields map[string]string) error {
+	if !s.consume('{') {
+		return errInvalidJSON
+	}
+	if s.consume('}') {
+		return nil
+	}
+	prefix := len(s.path)
+	if prefix > 0 {
+		s.path = append(s.path, '.')
+	}
+	base := len(s.path)
+	defer func() { s.path = s.path[:prefix] }()
+	for {
+		key, err := s.string()
+		if err != nil {
+			return err
+		}
+		s.skipSpace()
+		if !s.consume(':') {
+			return errInvalidJSON
+		}
+		s.path = append(s.path[:base], key...)
+		if err := s.value(p, fields); err != nil {
+			return err
+		}
+		s.skipSpace()
+		if s.consume('}') {
+			return nil
+		}
+		if !s.consume(',') {
+			return errInvalidJSON
+		}
+	}
+}
+
+// value scans the value at the path, and adds it to the fields if wanted.
+// Nested objects are flattened, whereas arrays are kept as JSON. An object is
+// kept as JSON as well if the query references it by its own path. Strings are
+// unquoted and numbers keep their form, e.g. 1e3. Null values are absent.
+func (s *jsonScanner) value(p *jsonParser, fields map[string]string) error {
+	if s.pos >= len(s.line) {
+		return errInvalidJSON
+	}
+	switch s.line[s.pos] {
+	case '{':
+		start := s.pos
+		var err error
+		if p.wantObject(s.path) {
+			err = s.object(p, fields)
+		} else {
+			err = s.skip()
+		}
+		if err != nil {
+			return err
+		}
+		if !p.allDynamicFields && p.wantField(s.path) {
+			p.addField(fields, s.path, strings.TrimRight(s.line[start:s.pos], " \t\n\r"))
+		}
+		return nil
+	case '"':
+		if !p.wantField(s.path) {
+			return s.skipString()
+		}
+		value, err := s.string()
+		if err != nil {
+			return err
+		}
+		p.addField(fields, s.path, value)
+		return nil
+	}
+
+	start := s.pos
+	if err := s.skip(); err != nil {
+		return err
+	}
+	if value := s.line[start:s.pos]; value != "null" && p.wantField(s.path) {
+		p.addField(fields, s.path, value)
+	}
+	return nil
+}
+
+// string scans a string and returns it unquoted.
+func (s *jsonScanner) string() (string, error) {
+	start := s.pos
+	if err := s.skipString(); err != nil {
+		return "", err
+	}
+	raw := s.line[start:s.pos]
+	if strings.IndexByte(raw, '\\') < 0 {
+		return raw[1 : len(raw)-1], nil
+	}
+	var value string
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return "", errInvalidJSON
+	}
+	return value, nil
+}
+
+func (s *jsonScanner) skipString() error {
+	if s.pos >= len(s.line) || s.line[s.pos] != '"' {
+		return errInvalidJSON
+	}
+	for i := s.pos + 1; i < len(s.line); i++ {
+		switch s.line[i] {
+		case '\\':
+			i++
+		case '"':
+			s.pos = i + 1
+			return nil
+		}
+	}
+	return errInvalidJSON
+}
+
+// skip skips any value, including nested objects and arrays.
+func (s *jsonScanner) skip() error {
+	if s.pos >= len(s.line) {
+		return errInvalidJSON
+	}
+	switch s.line[s.pos] {
+	case '"':
+		return s.skipString()
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.line) {
+			switch s.line[s.pos] {
+			case '"':
+				if err := s.skipString(); err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					s.pos++
+					return nil
+				}
+			}
+			s.pos++
+		}
+		return errInvalidJSON
+	}
+
+	// A number, true, false or null.
+	start := s.pos
+	for s.pos < len(s.line) && !isJSONDelimiter(s.line[s.pos]) {
+		s.pos++
+	}
+	if s.pos == start {
+		return errInvalidJSON
+	}
+	return nil
+}
+
+func isJSONDelimiter(c byte) bool {
+	switch c {
+	case ',', '}', ']', ' ', '\t', '\n', '\r':
+		return true
+	default:
+		return false
+	}
+}
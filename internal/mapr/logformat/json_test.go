@@ -0,0 +1,174 @@
+package logformat
+
+import (
+	"testing"
+
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+func TestJSONLogFormat(t *testing.T) {
+	parser, err := NewParser("json", nil)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	input := `{"time":"2021-10-02T07:23:42Z","level":"info","msg":"a \"quoted\" é",` +
+		`"req":{"path":"/api","size":1.5e3,"headers":{"host":"example.org"}},` +
+		`"resp":{"status":200,"ok":true},"tags":["a", "b"],"trace":null}`
+	fields, err := parser.MakeFields(input, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+
+	for field, want := range map[string]string{
+		"level":            "info",
+		"msg":              `a "quoted" é`,
+		"req.path":         "/api",
+		"req.size":         "1.5e3",
+		"req.headers.host": "example.org",
+		"resp.status":      "200",
+		"resp.ok":          "true",
+		"tags":             `["a", "b"]`,
+		"$time":            "2021-10-02T07:23:42Z",
+		"$date":            "20211002",
+		"$hour":            "07",
+		"$minute":          "23",
+		"$second":          "42",
+	} {
+		if val, ok := fields[field]; !ok {
+			t.Errorf("Expected field '%s', but no such field there", field)
+		} else if val != want {
+			t.Errorf("Expected '%s' stored in field '%s', but got '%s'", want, field, val)
+		}
+	}
+	for _, field := range []string{"trace", "req", "resp"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected field '%s' to be absent", field)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"INFO|20211002-072342|1|json_test.go:0|MAPREDUCE:STATS|foo=bar",
+		`{"foo":"bar"`,
+		`{"foo":"bar",}`,
+		`{"foo" "bar"}`,
+		`{"foo":{"bar":1}`,
+		`["foo"]`,
+		`{"foo":"bar"} trailing`,
+	} {
+		if _, err := parser.MakeFields(input, ""); err != ErrIgnoreFields {
+			t.Errorf("Expected to ignore invalid line '%s', got %v", input, err)
+		}
+	}
+}
+
+func TestJSONLogFormatQuerySpecificFields(t *testing.T) {
+	q, err := mapr.NewQuery(`select count(req.path),$hour,ctx group by resp.status ` +
+		`where $hostname eq "testhost" logformat json`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	if q.Table != "." {
+		t.Errorf("Expected the json log format to default the table to '.', got '%s'", q.Table)
+	}
+
+	parser, err := NewParser("json", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	fields, err := parser.MakeFields(`{"time":"2021-10-02T07:23:42Z","req":{"path":"/api","size":10},`+
+		`"resp":{"status":200},"ctx":{"user":"paul"},"other":{"deep":{"x":[1,{"y":"}"}]}},"msg":"hi"}`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+
+	for field, want := range map[string]string{
+		"req.path":    "/api",
+		"resp.status": "200",
+		"ctx":         `{"user":"paul"}`,
+		"$hour":       "07",
+	} {
+		if val := fields[field]; val != want {
+			t.Errorf("Expected '%s' stored in query-specific field '%s', but got '%s'", want, field, val)
+		}
+	}
+	if _, ok := fields["$hostname"]; !ok {
+		t.Errorf("Expected query-specific field '$hostname' to be present")
+	}
+
+	for _, field := range []string{"req.size", "msg", "other.deep.x", "ctx.user", "$time", "$date"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected query-specific field '%s' to be omitted", field)
+		}
+	}
+}
+
+func TestJSONLogFormatTimeKey(t *testing.T) {
+	q, err := mapr.NewQuery(`select count($time) group by $date logformat json`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	parser, err := NewParser("json", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+	parser.(*jsonParser).timeKey = "meta.ts"
+	parser.(*jsonParser).configureObjects()
+
+	fields, err := parser.MakeFields(`{"time":"ignored","meta":{"ts":"2021-10-02 07:23:42"}}`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	if fields["$time"] != "2021-10-02 07:23:42" || fields["$date"] != "20211002" {
+		t.Errorf("Expected the time fields of the nested time key, got %v", fields)
+	}
+
+	// The time fields other than $time are only set for parseable timestamps.
+	fields, err = parser.MakeFields(`{"meta":{"ts":"yesterday"}}`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	if _, ok := fields["$date"]; ok || fields["$time"] != "yesterday" {
+		t.Errorf("Expected only $time for an unparseable timestamp, got %v", fields)
+	}
+}
+
+func BenchmarkJSONParserMakeFields(b *testing.B) {
+	input := `{"time":"2021-10-02T07:23:42Z","level":"info","msg":"request served",` +
+		`"req":{"path":"/api/v1/items","method":"GET","headers":{"host":"example.org"}},` +
+		`"resp":{"status":200,"bytes":5120},"took":"12ms"}`
+
+	b.Run("all_fields", func(b *testing.B) {
+		parser, err := NewParser("json", nil)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+
+	b.Run("query_specific", func(b *testing.B) {
+		q, err := mapr.NewQuery(`select count(req.path) group by resp.status logformat json`)
+		if err != nil {
+			b.Fatalf("Unable to create query: %s", err.Error())
+		}
+		parser, err := NewParser("json", q)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package logformat
+
+import "strings"
+
+// logfmtParser parses logfmt lines as written by Go and Heroku services, e.g.
+// level=info msg="user logged in" dur=12ms. Values may be quoted, with
+// backslash escapes inside the quotes. A bare key without a value is present
+// with an empty value, and the last of duplicate keys wins.
+//
+// The time or ts key is mapped onto $time, $date, $hour, $minute and $second,
+// and the level or lvl key onto $severity and $loglevel.
+type logfmtParser struct {
+	defaultParser
+}
+
+var _ Parser = (*logfmtParser)(nil)
+
+func newLogfmtParser(hostname, timeZoneName string, timeZoneOffset int) (*logfmtParser, error) {
+	defaultParser, err := newDefaultParser(hostname, timeZoneName, timeZoneOffset)
+	if err != nil {
+		return &logfmtParser{}, err
+	}
+	return &logfmtParser{defaultParser: *defaultParser}, nil
+}
+
+func (p *logfmtParser) MakeFields(maprLine, _ string) (map[string]string, error) {
+	fields := make(map[string]string, p.fieldsCapacity)
+	p.addDefaultFields(fields, maprLine)
+
+	for i := 0; i < len(maprLine); {
+		// Skip white space, stray characters and quoted strings without a
+		// key between the pairs.
+		if maprLine[i] == '"' {
+			_, i = scanLogfmtQuoted(maprLine, i)
+			continue
+		}
+		if !isLogfmtKeyChar(maprLine[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(maprLine) && isLogfmtKeyChar(maprLine[i]) {
+			i++
+		}
+		key := maprLine[start:i]
+		if i == len(maprLine) || maprLine[i] != '=' {
+			p.addField(fields, key, "")
+			continue
+		}
+
+		i++
+		var value string
+		if i < len(maprLine) && maprLine[i] == '"' {
+			value, i = scanLogfmtQuoted(maprLine, i)
+		} else {
+			start = i
+			for i < len(maprLine) && maprLine[i] > ' ' {
+				i++
+			}
+			value = maprLine[start:i]
+		}
+		p.addField(fields, key, value)
+	}
+
+	return fields, nil
+}
+
+// addField stores a key and its value, and maps the well known keys onto the
+// variables of the default log format.
+func (p *logfmtParser) addField(fields map[string]string, key, value string) {
+	switch key {
+	case "time", "ts":
+		p.addTimestampFields(fields, value, "")
+	case "level", "lvl":
+		if p.wantSeverity {
+			fields["$severity"] = value
+		}
+		if p.wantLogLevel {
+			fields["$loglevel"] = value
+		}
+	}
+	p.addDynamicField(fields, key, value)
+}
+
+// isLogfmtKeyChar returns true for the characters of a key, which are all
+// printable characters but '=' and '"'.
+func isLogfmtKeyChar(c byte) bool {
+	return c > ' ' && c != '=' && c != '"' && c != 0x7f
+}
+
+// scanLogfmtQuoted scans the quoted value starting at the quote at position i.
+// It returns the unquoted value and the position after the closing quote. An
+// unterminated value extends to the end of the line.
+func scanLogfmtQuoted(line string, i int) (string, int) {
+	start := i + 1
+	escaped := false
+	for i = start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			if !escaped {
+				return line[start:i], i + 1
+			}
+			return unescapeLogfmt(line[start:i]), i + 1
+		}
+	}
+	if !escaped {
+		return line[start:], len(line)
+	}
+	return unescapeLogfmt(line[start:]), len(line)
+}
+
+// unescapeLogfmt resolves the backslash escapes of a quoted value. Unknown
+// escapes are kept as they are.
+func unescapeLogfmt(value string) string {
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch value[i] {
+		case '"', '\\':
+			sb.WriteByte(value[i])
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String()
+}
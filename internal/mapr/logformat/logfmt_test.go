@@ -0,0 +1,148 @@
+package logformat
+
+import (
+	"testing"
+
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+func TestLogfmtLogFormat(t *testing.T) {
+	parser, err := NewParser("logfmt", nil)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	tests := []struct {
+		input  string
+		want   map[string]string
+		absent []string
+	}{
+		{
+			input: `level=info msg="user logged in" dur=12ms`,
+			want:  map[string]string{"level": "info", "msg": "user logged in", "dur": "12ms"},
+		},
+		{
+			input: `msg="say \"hi\" C:\\tmp\n" path=/a=b empty="" x=`,
+			want: map[string]string{"msg": "say \"hi\" C:\\tmp\n", "path": "/a=b",
+				"empty": "", "x": ""},
+		},
+		{
+			// Bare keys are present without a value, the last duplicate wins.
+			input: `debug status=200 status=500   cached`,
+			want:  map[string]string{"debug": "", "status": "500", "cached": ""},
+		},
+		{
+			// Stray characters are skipped, unterminated quotes end with the line.
+			input:  `= "junk" a=1 b="open \"quote`,
+			want:   map[string]string{"a": "1", "b": `open "quote`},
+			absent: []string{"junk", ""},
+		},
+		{
+			input: `unicode="grüße" ü=ok`,
+			want:  map[string]string{"unicode": "grüße", "ü": "ok"},
+		},
+		{
+			input: ``,
+			want:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		fields, err := parser.MakeFields(tt.input, "")
+		if err != nil {
+			t.Errorf("Parser unable to make fields of '%s': %s", tt.input, err.Error())
+			continue
+		}
+		for field, want := range tt.want {
+			if val, ok := fields[field]; !ok {
+				t.Errorf("Expected field '%s' in '%s', but no such field there", field, tt.input)
+			} else if val != want {
+				t.Errorf("Expected '%s' stored in field '%s', but got '%s' in '%s'",
+					want, field, val, tt.input)
+			}
+		}
+		for _, field := range tt.absent {
+			if _, ok := fields[field]; ok {
+				t.Errorf("Expected field '%s' to be absent in '%s'", field, tt.input)
+			}
+		}
+	}
+}
+
+func TestLogfmtLogFormatQuerySpecificFields(t *testing.T) {
+	q, err := mapr.NewQuery(`select count(msg),$severity,$hour group by status ` +
+		`where $hostname eq "testhost" logformat logfmt`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	if q.Table != "." {
+		t.Errorf("Expected the logfmt log format to default the table to '.', got '%s'", q.Table)
+	}
+
+	parser, err := NewParser("logfmt", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	fields, err := parser.MakeFields(`ts=2021-10-02T07:23:42Z lvl=warn msg="disk \"full\"" `+
+		`status=507 dur=3s`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+
+	for field, want := range map[string]string{
+		"msg":       `disk "full"`,
+		"status":    "507",
+		"$severity": "warn",
+		"$hour":     "07",
+	} {
+		if val := fields[field]; val != want {
+			t.Errorf("Expected '%s' stored in query-specific field '%s', but got '%s'", want, field, val)
+		}
+	}
+	if _, ok := fields["$hostname"]; !ok {
+		t.Errorf("Expected query-specific field '$hostname' to be present")
+	}
+	for _, field := range []string{"ts", "lvl", "dur", "$time", "$loglevel"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected query-specific field '%s' to be omitted", field)
+		}
+	}
+}
+
+func BenchmarkLogfmtParserMakeFields(b *testing.B) {
+	input := `time=2021-10-02T07:23:42Z level=info msg="request served" method=GET ` +
+		`path=/api/v1/items status=200 bytes=5120 dur=12ms`
+
+	b.Run("all_fields", func(b *testing.B) {
+		parser, err := NewParser("logfmt", nil)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+
+	b.Run("query_specific", func(b *testing.B) {
+		q, err := mapr.NewQuery(`select count(path) group by status logformat logfmt`)
+		if err != nil {
+			b.Fatalf("Unable to create query: %s", err.Error())
+		}
+		parser, err := NewParser("logfmt", q)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+}
@@ -34,6 +34,10 @@ type ParserFactory func(hostname, timeZoneName string, timeZoneOffset int) (Pars
 var parserFactories = make(map[string]ParserFactory)
 var parserFactoriesMu sync.RWMutex
 
+// The names of the built-in log formats, which can't be replaced by the log
+// formats declared in the config.
+var builtInParsers = make(map[string]struct{})
+
 func init() {
 	registerBuiltInParsers()
 }
@@ -61,10 +65,22 @@ func getParserFactory(logFormatName string) (ParserFactory, bool) {
 	return factory, found
 }
 
+// IsRegistered returns true if a parser is registered for the log format name.
+// Otherwise, NewParser falls back to the default parser.
+func IsRegistered(logFormatName string) bool {
+	_, found := getParserFactory(logFormatName)
+	return found
+}
+
 func registerBuiltInParsers() {
 	mustRegisterParser("generic", wrapParserFactory(newGenericParser))
 	mustRegisterParser("generickv", wrapParserFactory(newGenericKVParser))
 	mustRegisterParser("csv", wrapParserFactory(newCSVParser))
+	mustRegisterParser("json", wrapParserFactory(newJSONParser))
+	mustRegisterParser("logfmt", wrapParserFactory(newLogfmtParser))
+	mustRegisterParser("combined", wrapParserFactory(newCombinedParser))
+	mustRegisterParser("nginx", wrapParserFactory(newNginxParser))
+	mustRegisterParser("syslog", wrapParserFactory(newSyslogParser))
 	mustRegisterParser("mimecast", wrapParserFactory(newMimecastParser))
 	mustRegisterParser("mimecastgeneric", wrapParserFactory(newMimecastGenericParser))
 	mustRegisterParser("default", wrapParserFactory(newDefaultParser))
@@ -76,6 +92,12 @@ func mustRegisterParser(logFormatName string, factory ParserFactory) {
 	if err := RegisterParser(logFormatName, factory); err != nil {
 		panic(err)
 	}
+	builtInParsers[logFormatName] = struct{}{}
+}
+
+func isBuiltInParser(logFormatName string) bool {
+	_, found := builtInParsers[logFormatName]
+	return found
 }
 
 func wrapParserFactory[T Parser](factory func(string, string, int) (T, error)) ParserFactory {
@@ -0,0 +1,206 @@
+package logformat
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mimecast/dtail/internal/config"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
+)
+
+// The type hints of the capture groups of a regex log format.
+const (
+	regexTypeString   = "string"
+	regexTypeInt      = "int"
+	regexTypeFloat    = "float"
+	regexTypeDuration = "duration"
+	regexTypeBytes    = "bytes"
+)
+
+// regexFormat is a log format declared in the config, see config.LogFormat.
+type regexFormat struct {
+	regex *regexp.Regexp
+	// The field names and type hints by capture group index. Unnamed groups,
+	// including the whole match at index 0, have an empty name.
+	names []string
+	types []string
+	// The index of the capture group of the timestamp, or 0 if there is none.
+	timeGroup  int
+	timeLayout string
+}
+
+// The log formats registered by RegisterConfigFormats, guarded by
+// parserFactoriesMu.
+var regexFormats = make(map[string]*regexFormat)
+
+func newRegexFormat(format config.LogFormat) (*regexFormat, error) {
+	regex, err := regexp.Compile(format.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	f := &regexFormat{
+		regex:      regex,
+		names:      regex.SubexpNames(),
+		types:      make([]string, regex.NumSubexp()+1),
+		timeLayout: format.TimeLayout,
+	}
+
+	var named bool
+	for i, name := range f.names {
+		if name == "" {
+			continue
+		}
+		named = true
+		if name == format.TimeField && f.timeGroup == 0 {
+			f.timeGroup = i
+		}
+	}
+	if !named {
+		return nil, errors.New("regex without named capture groups")
+	}
+	if format.TimeField != "" && f.timeGroup == 0 {
+		return nil, fmt.Errorf("no capture group for the time field '%s'", format.TimeField)
+	}
+
+	for name, typ := range format.Types {
+		switch typ {
+		case regexTypeString, regexTypeInt, regexTypeFloat, regexTypeDuration, regexTypeBytes:
+		default:
+			return nil, fmt.Errorf("unknown type '%s' of field '%s'", typ, name)
+		}
+		found := false
+		for i, groupName := range f.names {
+			if groupName == name {
+				f.types[i] = typ
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no capture group for the typed field '%s'", name)
+		}
+	}
+	return f, nil
+}
+
+// RegisterConfigFormats registers a parser for every log format declared in
+// the config, see config.LogFormat. A declared format replaces an earlier one
+// of the same name, but never a built-in log format. Invalid formats are
+// skipped and reported in the returned error, the valid ones are registered
+// nevertheless.
+func RegisterConfigFormats(formats map[string]config.LogFormat) error {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		logFormatName := strings.TrimSpace(name)
+		if isBuiltInParser(logFormatName) {
+			errs = append(errs, fmt.Errorf("log format '%s': the name of a built-in log format", name))
+			continue
+		}
+		format, err := newRegexFormat(formats[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("log format '%s': %w", name, err))
+			continue
+		}
+		factory := func(hostname, timeZoneName string, timeZoneOffset int) (Parser, error) {
+			return newRegexParser(format, hostname, timeZoneName, timeZoneOffset)
+		}
+		if err := RegisterParser(logFormatName, factory); err != nil {
+			errs = append(errs, fmt.Errorf("log format '%s': %w", name, err))
+			continue
+		}
+		parserFactoriesMu.Lock()
+		regexFormats[logFormatName] = format
+		parserFactoriesMu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func getRegexFormat(logFormatName string) (*regexFormat, bool) {
+	parserFactoriesMu.RLock()
+	defer parserFactoriesMu.RUnlock()
+	format, found := regexFormats[logFormatName]
+	return format, found
+}
+
+// regexParser parses the lines of a log format declared in the config. Every
+// named capture group becomes a field, lines not matching are ignored.
+type regexParser struct {
+	defaultParser
+	format *regexFormat
+}
+
+var _ Parser = (*regexParser)(nil)
+
+func newRegexParser(format *regexFormat, hostname, timeZoneName string,
+	timeZoneOffset int) (*regexParser, error) {
+
+	defaultParser, err := newDefaultParser(hostname, timeZoneName, timeZoneOffset)
+	if err != nil {
+		return &regexParser{}, err
+	}
+	return &regexParser{defaultParser: *defaultParser, format: format}, nil
+}
+
+func (p *regexParser) MakeFields(maprLine, _ string) (map[string]string, error) {
+	match := p.format.regex.FindStringSubmatchIndex(maprLine)
+	if match == nil {
+		return nil, ErrIgnoreFields
+	}
+	fields := make(map[string]string, p.fieldsCapacity)
+	p.addDefaultFields(fields, maprLine)
+
+	for i, name := range p.format.names {
+		// Optional groups which didn't participate in the match are absent.
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+		value := maprLine[match[2*i]:match[2*i+1]]
+		if i == p.format.timeGroup {
+			p.addTimestampFields(fields, value, p.format.timeLayout)
+		}
+		if _, ok := p.dynamicFields[name]; !ok && !p.allDynamicFields {
+			continue
+		}
+		if value, ok := regexTypedValue(value, p.format.types[i]); ok {
+			fields[name] = value
+		}
+	}
+	return fields, nil
+}
+
+// regexTypedValue converts a captured value according to its type hint. It
+// returns false if the value isn't of the type.
+func regexTypedValue(value, typ string) (string, bool) {
+	switch typ {
+	case regexTypeInt:
+		_, err := strconv.ParseInt(value, 10, 64)
+		return value, err == nil
+	case regexTypeFloat:
+		_, err := strconv.ParseFloat(value, 64)
+		return value, err == nil
+	case regexTypeDuration:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return value, true
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64), true
+	case regexTypeBytes:
+		value = funcs.Bytes(value)
+		return value, value != ""
+	default:
+		return value, true
+	}
+}
@@ -0,0 +1,148 @@
+package logformat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mimecast/dtail/internal/config"
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+func TestRegexLogFormat(t *testing.T) {
+	err := RegisterConfigFormats(map[string]config.LogFormat{
+		"regextest": {
+			Regex: `^(?P<ts>\S+ \S+) \[(?P<level>\w+)\] took=(?P<took>\S+) ` +
+				`size=(?P<size>\S+) status=(?P<status>\S+)(?: user=(?P<user>\w+))?`,
+			TimeField:  "ts",
+			TimeLayout: "2006/01/02 15:04:05",
+			Types: map[string]string{
+				"took":   "duration",
+				"size":   "bytes",
+				"status": "int",
+				"level":  "string",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to register log format: %v", err)
+	}
+	if !IsRegistered("regextest") {
+		t.Fatalf("Expected log format 'regextest' to be registered")
+	}
+
+	parser, err := NewParser("regextest", nil)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+	fields, err := parser.MakeFields("2021/10/02 07:23:42 [WARN] took=1.5s size=2KiB status=200 user=paul", "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	for field, want := range map[string]string{
+		"ts":      "2021/10/02 07:23:42",
+		"level":   "WARN",
+		"took":    "1.5",
+		"size":    "2048",
+		"status":  "200",
+		"user":    "paul",
+		"$time":   "2021/10/02 07:23:42",
+		"$date":   "20211002",
+		"$hour":   "07",
+		"$minute": "23",
+		"$second": "42",
+	} {
+		if val, ok := fields[field]; !ok {
+			t.Errorf("Expected field '%s', but no such field there", field)
+		} else if val != want {
+			t.Errorf("Expected '%s' stored in field '%s', but got '%s'", want, field, val)
+		}
+	}
+
+	// Values which aren't of their type and optional groups which didn't
+	// match are absent.
+	fields, err = parser.MakeFields("2021/10/02 07:23:42 [INFO] took=soon size=big status=2xx", "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	for _, field := range []string{"took", "size", "status", "user"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected field '%s' to be absent, got '%s'", field, fields[field])
+		}
+	}
+
+	if _, err := parser.MakeFields("no match", ""); err != ErrIgnoreFields {
+		t.Errorf("Expected to ignore a line not matching the regex, got %v", err)
+	}
+}
+
+func TestRegexLogFormatQuerySpecificFields(t *testing.T) {
+	err := RegisterConfigFormats(map[string]config.LogFormat{
+		"regexquerytest": {Regex: `^(?P<time>\S+) (?P<path>\S+) (?P<status>\d+) (?P<bytes>\d+)$`, TimeField: "time"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to register log format: %v", err)
+	}
+	q, err := mapr.NewQuery(`select count(path),$hour group by status logformat regexquerytest`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	parser, err := NewParser("regexquerytest", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	fields, err := parser.MakeFields("2021-10-02T07:23:42Z /api 200 512", "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	for field, want := range map[string]string{"path": "/api", "status": "200", "$hour": "07"} {
+		if val := fields[field]; val != want {
+			t.Errorf("Expected '%s' stored in query-specific field '%s', but got '%s'", want, field, val)
+		}
+	}
+	for _, field := range []string{"bytes", "time", "$time"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected query-specific field '%s' to be omitted", field)
+		}
+	}
+
+	// The plan time warnings know the variables of the declared format.
+	q, err = mapr.NewQuery(`select $date,$severity logformat regexquerytest`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	warnings := PlanVariableWarnings(q, "regexquerytest")
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "$severity") {
+		t.Errorf("Expected a single warning for $severity, got %v", warnings)
+	}
+}
+
+func TestRegisterConfigFormatsErrors(t *testing.T) {
+	err := RegisterConfigFormats(map[string]config.LogFormat{
+		"regexvalid":       {Regex: `^(?P<a>\w+)`},
+		"regexinvalid":     {Regex: `^(?P<a>\w+`},
+		"regexunnamed":     {Regex: `^(\w+)`},
+		"regextimefield":   {Regex: `^(?P<a>\w+)`, TimeField: "ts"},
+		"regextypefield":   {Regex: `^(?P<a>\w+)`, Types: map[string]string{"b": "int"}},
+		"regextypeunknown": {Regex: `^(?P<a>\w+)`, Types: map[string]string{"a": "decimal"}},
+		"csv":              {Regex: `^(?P<a>\w+)`},
+	})
+	if err == nil {
+		t.Fatalf("Expected an error registering invalid log formats")
+	}
+	for _, name := range []string{"regexinvalid", "regexunnamed", "regextimefield",
+		"regextypefield", "regextypeunknown", "csv"} {
+		if !strings.Contains(err.Error(), "'"+name+"'") {
+			t.Errorf("Expected an error for log format '%s', got: %v", name, err)
+		}
+		if name != "csv" && IsRegistered(name) {
+			t.Errorf("Expected invalid log format '%s' not to be registered", name)
+		}
+	}
+	if !IsRegistered("regexvalid") {
+		t.Errorf("Expected the valid log format to be registered nevertheless")
+	}
+	if _, isRegex := getRegexFormat("csv"); isRegex {
+		t.Errorf("Expected the built-in csv log format not to be replaced")
+	}
+}
@@ -0,0 +1,289 @@
+package logformat
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidSyslog = errors.New("invalid syslog line")
+
+// The names of the syslog facilities and severities by their codes.
+var (
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	syslogSeverities = []string{
+		"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+	}
+)
+
+// syslogParser parses syslog lines, both in the RFC 5424 format, e.g.
+//
+//	<165>1 2003-10-11T22:14:15.003Z mymachine evntslog 1234 ID47 [exampleSDID@32473 iut="3"] An application event
+//
+// and in the BSD (RFC 3164) format of /var/log/messages, e.g.
+//
+//	<34>Oct 11 22:14:15 mymachine su[1234]: 'su root' failed for lonvick on /dev/pts/8
+//
+// whose priority is optional. The hostname of the line is mapped onto
+// $hostname, and the parameters of the RFC 5424 structured data onto fields
+// named after the element and the parameter, e.g. exampleSDID@32473.iut.
+type syslogParser struct {
+	defaultParser
+	// The current time and the time zone, to complete RFC 3164 timestamps
+	// which have neither a year nor a time zone.
+	now      func() time.Time
+	location *time.Location
+}
+
+var _ Parser = (*syslogParser)(nil)
+
+func newSyslogParser(hostname, timeZoneName string, timeZoneOffset int) (*syslogParser, error) {
+	defaultParser, err := newDefaultParser(hostname, timeZoneName, timeZoneOffset)
+	if err != nil {
+		return &syslogParser{}, err
+	}
+	return &syslogParser{defaultParser: *defaultParser, now: time.Now, location: time.Local}, nil
+}
+
+func (p *syslogParser) MakeFields(maprLine, _ string) (map[string]string, error) {
+	fields := make(map[string]string, p.fieldsCapacity)
+	p.addDefaultFields(fields, maprLine)
+
+	line := maprLine
+	if priority, rest, ok := parseSyslogPriority(line); ok {
+		p.addDynamicField(fields, "facility", syslogFacilities[priority/8])
+		p.addDynamicField(fields, "severity", syslogSeverities[priority%8])
+		line = rest
+	}
+
+	var err error
+	if strings.HasPrefix(line, "1 ") {
+		err = p.addRFC5424Fields(fields, line[2:])
+	} else {
+		err = p.addRFC3164Fields(fields, line)
+	}
+	if err != nil {
+		return nil, ErrIgnoreFields
+	}
+	return fields, nil
+}
+
+// parseSyslogPriority parses the priority at the beginning of a line, e.g.
+// <34>, and returns it and the rest of the line.
+func parseSyslogPriority(line string) (int, string, bool) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, line, false
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, line, false
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority < 0 || priority >= len(syslogFacilities)*8 {
+		return 0, line, false
+	}
+	return priority, line[end+1:], true
+}
+
+// addRFC5424Fields parses the header following the version, the structured
+// data and the message of an RFC 5424 line.
+func (p *syslogParser) addRFC5424Fields(fields map[string]string, line string) error {
+	timestamp, line, _ := strings.Cut(line, " ")
+	hostname, line, _ := strings.Cut(line, " ")
+	app, line, _ := strings.Cut(line, " ")
+	pid, line, _ := strings.Cut(line, " ")
+	msgID, line, ok := strings.Cut(line, " ")
+	if !ok || timestamp == "" || hostname == "" || app == "" || pid == "" || msgID == "" {
+		return errInvalidSyslog
+	}
+
+	if timestamp != "-" {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return errInvalidSyslog
+		}
+		p.addTimeFields(fields, t)
+	}
+	p.addHostname(fields, hostname)
+	p.addSyslogField(fields, "app", app)
+	p.addSyslogField(fields, "pid", pid)
+	p.addSyslogField(fields, "msgid", msgID)
+
+	message, err := p.addStructuredData(fields, line)
+	if err != nil {
+		return err
+	}
+	// The message may start with a byte order mark, if it's UTF-8.
+	message = strings.TrimPrefix(strings.TrimPrefix(message, " "), "\ufeff")
+	if message != "" {
+		p.addDynamicField(fields, "message", message)
+	}
+	return nil
+}
+
+// addStructuredData adds the parameters of the structured data elements, e.g.
+// [exampleSDID@32473 iut="3" eventSource="Application"], as fields and returns
+// the rest of the line.
+func (p *syslogParser) addStructuredData(fields map[string]string, line string) (string, error) {
+	if strings.HasPrefix(line, "-") {
+		return line[1:], nil
+	}
+	if !strings.HasPrefix(line, "[") {
+		return "", errInvalidSyslog
+	}
+	for strings.HasPrefix(line, "[") {
+		end := strings.IndexAny(line, " ]")
+		if end < 2 {
+			return "", errInvalidSyslog
+		}
+		id := line[1:end]
+		line = line[end:]
+		for {
+			line = strings.TrimLeft(line, " ")
+			if strings.HasPrefix(line, "]") {
+				line = line[1:]
+				break
+			}
+			name, rest, ok := strings.Cut(line, "=")
+			if !ok || name == "" || !strings.HasPrefix(rest, `"`) {
+				return "", errInvalidSyslog
+			}
+			value, rest, ok := cutSyslogParamValue(rest)
+			if !ok {
+				return "", errInvalidSyslog
+			}
+			line = rest
+			// Don't build the field name if the query wants no dynamic fields.
+			if p.allDynamicFields || len(p.dynamicFields) > 0 {
+				p.addDynamicField(fields, id+"."+name, value)
+			}
+		}
+	}
+	return line, nil
+}
+
+// cutSyslogParamValue returns the quoted value of a structured data
+// parameter, with the escapes \", \\ and \] resolved, and the rest of the line.
+func cutSyslogParamValue(line string) (string, string, bool) {
+	escaped := false
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			value := line[1:i]
+			if escaped {
+				value = unescapeSyslogParamValue(value)
+			}
+			return value, line[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func unescapeSyslogParamValue(value string) string {
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			switch value[i+1] {
+			case '"', '\\', ']':
+				i++
+			}
+		}
+		sb.WriteByte(value[i])
+	}
+	return sb.String()
+}
+
+// addRFC3164Fields parses a BSD syslog line following the priority, e.g.
+// Oct 11 22:14:15 mymachine su[1234]: message. The timestamp may also be in
+// the RFC 3339 format, as written by rsyslog's high precision template.
+func (p *syslogParser) addRFC3164Fields(fields map[string]string, line string) error {
+	var t time.Time
+	if len(line) > len(time.Stamp) && line[len(time.Stamp)] == ' ' {
+		stamp, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], p.location)
+		if err != nil {
+			return errInvalidSyslog
+		}
+		t = p.inferYear(stamp)
+		line = line[len(time.Stamp)+1:]
+	} else {
+		timestamp, rest, _ := strings.Cut(line, " ")
+		var err error
+		if t, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return errInvalidSyslog
+		}
+		line = rest
+	}
+	p.addTimeFields(fields, t)
+
+	hostname, line, _ := strings.Cut(line, " ")
+	if hostname == "" {
+		return errInvalidSyslog
+	}
+	p.addHostname(fields, hostname)
+
+	// The tag, e.g. su[1234]: or su:, is optional.
+	if i := strings.IndexAny(line, "[: "); i > 0 && line[i] != ' ' {
+		app, pid, rest := line[:i], "", line[i:]
+		if rest[0] == '[' {
+			if end := strings.IndexByte(rest, ']'); end > 0 {
+				pid, rest = rest[1:end], rest[end+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			p.addDynamicField(fields, "app", app)
+			if pid != "" {
+				p.addDynamicField(fields, "pid", pid)
+			}
+			line = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	if line != "" {
+		p.addDynamicField(fields, "message", line)
+	}
+	return nil
+}
+
+// inferYear completes an RFC 3164 timestamp without a year with the current
+// year, or with the previous year if the timestamp would be in the future,
+// e.g. for a line of December read in January. A day of clock skew between
+// the hosts is tolerated.
+func (p *syslogParser) inferYear(stamp time.Time) time.Time {
+	now := p.now().In(p.location)
+	t := time.Date(now.Year(), stamp.Month(), stamp.Day(), stamp.Hour(), stamp.Minute(),
+		stamp.Second(), 0, p.location)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = time.Date(now.Year()-1, stamp.Month(), stamp.Day(), stamp.Hour(), stamp.Minute(),
+			stamp.Second(), 0, p.location)
+	}
+	return t
+}
+
+// addHostname maps the hostname of the line onto $hostname, instead of the
+// hostname of the server. $server remains the hostname of the server.
+func (p *syslogParser) addHostname(fields map[string]string, hostname string) {
+	if !p.wantHostname {
+		return
+	}
+	if hostname == "-" {
+		delete(fields, "$hostname")
+		return
+	}
+	fields["$hostname"] = hostname
+}
+
+// addSyslogField adds a field of the RFC 5424 header, which is absent if it's
+// the nil value "-".
+func (p *syslogParser) addSyslogField(fields map[string]string, key, value string) {
+	if value != "-" {
+		p.addDynamicField(fields, key, value)
+	}
+}
@@ -0,0 +1,205 @@
+package logformat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mimecast/dtail/internal/mapr"
+)
+
+func TestSyslogLogFormat(t *testing.T) {
+	parser, err := NewParser("syslog", nil)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+	syslog := parser.(*syslogParser)
+	syslog.location = time.UTC
+	syslog.now = func() time.Time { return time.Date(2021, time.October, 12, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		input  string
+		want   map[string]string
+		absent []string
+	}{
+		{
+			input: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 ` +
+				`[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"]` +
+				`[examplePriority@32473 class="high \"x\" [y\]"] ` + "\ufeff" + `An application event log entry...`,
+			want: map[string]string{
+				"$time":                         "20031011-221415",
+				"$date":                         "20031011",
+				"$hostname":                     "mymachine.example.com",
+				"facility":                      "local4",
+				"severity":                      "notice",
+				"app":                           "evntslog",
+				"msgid":                         "ID47",
+				"exampleSDID@32473.iut":         "3",
+				"exampleSDID@32473.eventSource": "Application",
+				"examplePriority@32473.class":   `high "x" [y]`,
+				"message":                       "An application event log entry...",
+			},
+			absent: []string{"pid"},
+		},
+		{
+			input: `<34>1 2021-10-02T07:23:42+02:00 web1 sshd 4242 - - Accepted publickey`,
+			want: map[string]string{
+				"$time": "20211002-072342", "$hostname": "web1", "facility": "auth",
+				"severity": "crit", "app": "sshd", "pid": "4242", "message": "Accepted publickey",
+			},
+			absent: []string{"msgid"},
+		},
+		{
+			input: `<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`,
+			want: map[string]string{
+				"$time": "20211011-221415", "$hostname": "mymachine", "facility": "auth",
+				"severity": "crit", "app": "su", "message": "'su root' failed for lonvick on /dev/pts/8",
+			},
+			absent: []string{"pid"},
+		},
+		{
+			// /var/log/messages without a priority. The timestamp of December
+			// is from the previous year.
+			input: `Dec  2 07:23:42 web1 kernel[0]: [12345.678] eth0: link up`,
+			want: map[string]string{
+				"$time": "20201202-072342", "$hostname": "web1", "app": "kernel", "pid": "0",
+				"message": "[12345.678] eth0: link up",
+			},
+			absent: []string{"facility", "severity"},
+		},
+		{
+			// A line without a tag, and rsyslog's high precision timestamp.
+			input: `2021-10-02T07:23:42.123456+00:00 web1 just a message: with colon`,
+			want: map[string]string{
+				"$time": "20211002-072342", "$hostname": "web1", "message": "just a message: with colon",
+			},
+			absent: []string{"app", "pid"},
+		},
+	}
+
+	for _, tt := range tests {
+		fields, err := parser.MakeFields(tt.input, "")
+		if err != nil {
+			t.Errorf("Parser unable to make fields of '%s': %s", tt.input, err.Error())
+			continue
+		}
+		for field, want := range tt.want {
+			if val, ok := fields[field]; !ok {
+				t.Errorf("Expected field '%s' in '%s', but no such field there", field, tt.input)
+			} else if val != want {
+				t.Errorf("Expected '%s' stored in field '%s', but got '%s' in '%s'",
+					want, field, val, tt.input)
+			}
+		}
+		for _, field := range tt.absent {
+			if _, ok := fields[field]; ok {
+				t.Errorf("Expected field '%s' to be absent in '%s'", field, tt.input)
+			}
+		}
+		if fields["$server"] == fields["$hostname"] {
+			t.Errorf("Expected $server to remain the hostname of the server in '%s'", tt.input)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"INFO|20211002-072342|1|syslog_test.go:0|MAPREDUCE:STATS|foo=bar",
+		`<34>1 2021-10-02T07:23:42Z web1 sshd`,
+		`<34>1 yesterday web1 sshd 4242 - - message`,
+		`<34>1 2021-10-02T07:23:42Z web1 sshd 4242 - [id a="1" message`,
+		`<34>1 2021-10-02T07:23:42Z web1 sshd 4242 - [id a=1] message`,
+		`<34>Oct 99 22:14:15 mymachine su: message`,
+	} {
+		if _, err := parser.MakeFields(input, ""); err != ErrIgnoreFields {
+			t.Errorf("Expected to ignore invalid line '%s', got %v", input, err)
+		}
+	}
+}
+
+func TestSyslogInferYear(t *testing.T) {
+	p := syslogParser{location: time.UTC}
+	for _, tt := range []struct {
+		now, stamp, want string
+	}{
+		{"2021-10-12T00:00:00Z", "0000-10-11T22:14:15Z", "2021-10-11T22:14:15Z"},
+		// Up to a day in the future is clock skew.
+		{"2021-10-12T00:00:00Z", "0000-10-12T23:00:00Z", "2021-10-12T23:00:00Z"},
+		{"2021-10-12T00:00:00Z", "0000-10-14T00:00:00Z", "2020-10-14T00:00:00Z"},
+		{"2022-01-01T00:10:00Z", "0000-12-31T23:59:59Z", "2021-12-31T23:59:59Z"},
+	} {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		stamp, _ := time.Parse(time.RFC3339, tt.stamp)
+		p.now = func() time.Time { return now }
+		if got := p.inferYear(stamp).Format(time.RFC3339); got != tt.want {
+			t.Errorf("Got %s for %s at %s, want %s", got, tt.stamp, tt.now, tt.want)
+		}
+	}
+}
+
+func TestSyslogLogFormatQuerySpecificFields(t *testing.T) {
+	q, err := mapr.NewQuery(`select count(app),$hostname group by severity,exampleSDID@32473.iut ` +
+		`logformat syslog`)
+	if err != nil {
+		t.Fatalf("Unable to create query: %s", err.Error())
+	}
+	if q.Table != "." {
+		t.Errorf("Expected the syslog log format to default the table to '.', got '%s'", q.Table)
+	}
+	parser, err := NewParser("syslog", q)
+	if err != nil {
+		t.Fatalf("Unable to create parser: %s", err.Error())
+	}
+
+	fields, err := parser.MakeFields(`<165>1 2003-10-11T22:14:15.003Z mymachine evntslog 42 ID47 `+
+		`[exampleSDID@32473 iut="3" eventSource="Application"] An application event`, "")
+	if err != nil {
+		t.Fatalf("Parser unable to make fields: %s", err.Error())
+	}
+	for field, want := range map[string]string{
+		"app": "evntslog", "$hostname": "mymachine", "severity": "notice", "exampleSDID@32473.iut": "3",
+	} {
+		if val := fields[field]; val != want {
+			t.Errorf("Expected '%s' stored in query-specific field '%s', but got '%s'", want, field, val)
+		}
+	}
+	for _, field := range []string{"pid", "facility", "message", "exampleSDID@32473.eventSource", "$time"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("Expected query-specific field '%s' to be omitted", field)
+		}
+	}
+}
+
+func BenchmarkSyslogParserMakeFields(b *testing.B) {
+	input := `<34>Oct 11 22:14:15 web1 sshd[4242]: Accepted publickey for paul from 10.0.0.1 port 22 ssh2`
+
+	b.Run("all_fields", func(b *testing.B) {
+		parser, err := NewParser("syslog", nil)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+
+	b.Run("query_specific", func(b *testing.B) {
+		q, err := mapr.NewQuery(`select count(app) group by severity logformat syslog`)
+		if err != nil {
+			b.Fatalf("Unable to create query: %s", err.Error())
+		}
+		parser, err := NewParser("syslog", q)
+		if err != nil {
+			b.Fatalf("Unable to create parser: %s", err.Error())
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parser.MakeFields(input, ""); err != nil {
+				b.Fatalf("Unable to parse input: %s", err.Error())
+			}
+		}
+	})
+}
@@ -49,6 +49,39 @@ var defaultOnlyVariables = map[string]struct{}{
 	"$uptime":     {},
 }
 
+// timestampVariables are the $-variables of the parsers which map a timestamp
+// of their lines onto the time variables of the default parser (see
+// defaultParser.addTimestampFields and defaultParser.addTimeFields).
+var timestampVariables = map[string]struct{}{
+	"$time":   {},
+	"$date":   {},
+	"$hour":   {},
+	"$minute": {},
+	"$second": {},
+}
+
+// severityVariables are the $-variables of the parsers which map a level key
+// of their lines onto the severity variables of the default parser.
+var severityVariables = map[string]struct{}{
+	"$severity": {},
+	"$loglevel": {},
+}
+
+// unionVariables returns the union of the given $-variable sets.
+func unionVariables(sets ...map[string]struct{}) map[string]struct{} {
+	var size int
+	for _, set := range sets {
+		size += len(set)
+	}
+	known := make(map[string]struct{}, size)
+	for _, set := range sets {
+		for name := range set {
+			known[name] = struct{}{}
+		}
+	}
+	return known
+}
+
 // knownVariables returns the set of $-variables the named parser can populate,
 // and whether that set is enumerable at all. For log formats whose variable set
 // cannot be determined statically (proprietary, stub or unknown formats) it
@@ -57,18 +90,22 @@ var defaultOnlyVariables = map[string]struct{}{
 func knownVariables(logFormatName string) (map[string]struct{}, bool) {
 	switch logFormatName {
 	case "default":
-		known := make(map[string]struct{}, len(commonVariables)+len(defaultOnlyVariables))
-		for name := range commonVariables {
-			known[name] = struct{}{}
-		}
-		for name := range defaultOnlyVariables {
-			known[name] = struct{}{}
-		}
-		return known, true
+		return unionVariables(commonVariables, defaultOnlyVariables), true
 	case "generic", "generickv", "csv":
 		return commonVariables, true
+	case "json", "combined", "nginx", "syslog":
+		return unionVariables(commonVariables, timestampVariables), true
+	case "logfmt":
+		return unionVariables(commonVariables, timestampVariables, severityVariables), true
 	default:
-		return nil, false
+		format, found := getRegexFormat(logFormatName)
+		if !found {
+			return nil, false
+		}
+		if format.timeGroup == 0 {
+			return commonVariables, true
+		}
+		return unionVariables(commonVariables, timestampVariables), true
 	}
 }
 
@@ -66,6 +66,24 @@ func TestPlanVariableWarnings(t *testing.T) {
 			wantVars:   []string{"$bogus"},
 			unwantVars: []string{"$line"},
 		},
+		{
+			name:       "logfmt maps time and level keys but not other keys",
+			query:      "select $severity,count($msg) group by $hour where $time ne never logformat logfmt",
+			wantVars:   []string{"$msg"},
+			unwantVars: []string{"$severity", "$hour", "$time"},
+		},
+		{
+			name:       "access logs map the request time but have no severity",
+			query:      "select count(path),$severity group by $date logformat nginx",
+			wantVars:   []string{"$severity"},
+			unwantVars: []string{"$date", "path"},
+		},
+		{
+			name:       "json maps the time key but has no severity",
+			query:      "select $date,$loglevel logformat json",
+			wantVars:   []string{"$loglevel"},
+			unwantVars: []string{"$date"},
+		},
 	}
 
 	for _, tc := range tests {
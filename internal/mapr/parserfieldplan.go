@@ -32,7 +32,8 @@ func (q *Query) ParserFieldPlan() ParserFieldPlan {
 		return ParserFieldPlan{AllFields: true}
 	}
 
-	fields := make(map[string]struct{}, len(q.Select)+len(q.GroupBy)+len(q.Where)*2+len(q.Set))
+	where := q.Where.conditions()
+	fields := make(map[string]struct{}, len(q.Select)+len(q.GroupBy)+len(where)*2+len(q.Set))
 	producedBySet := make(map[string]struct{}, len(q.Set))
 
 	add := func(field string) {
@@ -46,7 +47,7 @@ func (q *Query) ParserFieldPlan() ParserFieldPlan {
 		return ok
 	}
 
-	for _, wc := range q.Where {
+	for _, wc := range where {
 		if wc.lType == Field {
 			add(wc.lString)
 		}
@@ -56,10 +57,9 @@ func (q *Query) ParserFieldPlan() ParserFieldPlan {
 	}
 
 	for _, sc := range q.Set {
-		switch sc.rType {
-		case Field, FunctionStack:
-			if !isProduced(sc.rString) {
-				add(sc.rString)
+		for _, field := range sc.fields() {
+			if !isProduced(field) {
+				add(field)
 			}
 		}
 		producedBySet[sc.lString] = struct{}{}
@@ -71,10 +71,15 @@ func (q *Query) ParserFieldPlan() ParserFieldPlan {
 		}
 	}
 
-	for _, sc := range q.Select {
+	for _, sc := range q.Aggregations() {
 		if !isProduced(sc.Field) {
 			add(sc.Field)
 		}
+		// The line timestamp orders the values of the first aggregation and
+		// times the samples of the rate aggregation.
+		if (sc.Operation == First || sc.Operation == Rate) && !isProduced(FirstOrderField) {
+			add(FirstOrderField)
+		}
 	}
 
 	return ParserFieldPlan{Fields: fields}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 )
 
 const (
@@ -13,6 +15,21 @@ const (
 	unexpectedEnd string = "Unexpected end of query"
 )
 
+// DefaultDistinctLimit is the default maximum number of distinct value
+// combinations a 'select distinct' query keeps, see Query.Distinct.
+const DefaultDistinctLimit = 10000
+
+// tablelessLogFormats are the log formats whose lines don't carry a
+// MAPREDUCE:TABLE marker, so their queries match all lines by default.
+var tablelessLogFormats = map[string]struct{}{
+	"csv":      {},
+	"json":     {},
+	"logfmt":   {},
+	"combined": {},
+	"nginx":    {},
+	"syslog":   {},
+}
+
 // Outfile represents the output file of a mapreduce query.
 type Outfile struct {
 	FilePath   string
@@ -24,43 +41,81 @@ func (o *Outfile) String() string {
 	return fmt.Sprintf("Outfile(FilePath:%v,AppendMode:%v)", o.FilePath, o.AppendMode)
 }
 
+// OrderKey is a sort key of the 'order by' clause.
+type OrderKey struct {
+	Field string
+	// Ascending sorts the smallest value first. Unless given explicitly with
+	// 'asc' or 'desc', 'order by' sorts descending and 'rorder by' ascending.
+	Ascending bool
+}
+
+// String returns the string representation of OrderKey.
+func (k OrderKey) String() string {
+	return fmt.Sprintf("OrderKey(Field:%s,Ascending:%v)", k.Field, k.Ascending)
+}
+
 // Query represents a parsed mapr query.
 type Query struct {
-	Select       []selectCondition
-	Table        string
-	Where        []whereCondition
-	Set          []setCondition
-	GroupBy      []string
-	OrderBy      string
-	ReverseOrder bool
-	GroupKey     string
-	Interval     time.Duration
-	Limit        int
-	Outfile      *Outfile
-	RawQuery     string
-	tokens       []token
-	LogFormat    string
+	Select []selectCondition
+	// Distinct is set by 'select distinct', which lists the distinct value
+	// combinations of the selected fields. It groups by all selected fields.
+	Distinct bool
+	Table    string
+	Where    *whereExpr
+	Set      []setCondition
+	GroupBy  []string
+	Having   *whereExpr
+	OrderBy  []OrderKey
+	GroupKey string
+	Interval time.Duration
+	Limit    int
+	// The fields of the 'limit N per FIELD' clause, which limits the rows per
+	// distinct value of these fields instead of all rows.
+	LimitPer []string
+	Offset   int
+	Outfile  *Outfile
+	RawQuery string
+	// Explain is set by a leading 'explain' keyword, to describe how the
+	// query is run instead of running it.
+	Explain bool
+	// Units is set by the 'units' keyword to the unit durations are
+	// normalised to, e.g. time.Millisecond, or zero without it. Field values
+	// with a unit, e.g. 123ms or 4.2MB, are then numbers for the float
+	// conditions and the numeric aggregations.
+	Units     time.Duration
+	tokens    []token
+	LogFormat string
+	// Function calls used as fields in the 'group by' clause.
+	groupByCalls []string
+	// All aggregations to compute, see Aggregations.
+	aggregations []selectCondition
 }
 
 // String returns the string representation of Query.
 func (q *Query) String() string {
 	return fmt.Sprintf("Query(Select:%v,Table:%s,Where:%v,Set:%vGroupBy:%v,"+
-		"GroupKey:%s,OrderBy:%v,ReverseOrder:%v,Interval:%v,Limit:%d,Outfile:%s,"+
-		"RawQuery:%s,tokens:%v,LogFormat:%s)",
+		"GroupKey:%s,Having:%v,OrderBy:%v,Interval:%v,Limit:%d,LimitPer:%v,Offset:%d,"+
+		"Outfile:%s,"+
+		"RawQuery:%s,Explain:%v,tokens:%v,LogFormat:%s,Distinct:%v,Units:%v)",
 		q.Select,
 		q.Table,
 		q.Where,
 		q.Set,
 		q.GroupBy,
 		q.GroupKey,
+		q.Having,
 		q.OrderBy,
-		q.ReverseOrder,
 		q.Interval,
 		q.Limit,
+		q.LimitPer,
+		q.Offset,
 		q.Outfile,
 		q.RawQuery,
+		q.Explain,
 		q.tokens,
-		q.LogFormat)
+		q.LogFormat,
+		q.Distinct,
+		q.Units)
 }
 
 // NewQuery returns a new mapreduce query.
@@ -68,7 +123,10 @@ func NewQuery(queryStr string) (*Query, error) {
 	if queryStr == "" {
 		return nil, nil
 	}
-	tokens := tokenize(queryStr)
+	tokens, err := tokenize(queryStr)
+	if err != nil {
+		return nil, withQuery(err, queryStr)
+	}
 	q := Query{
 		RawQuery: queryStr,
 		tokens:   tokens,
@@ -78,14 +136,15 @@ func NewQuery(queryStr string) (*Query, error) {
 
 	// Parse the query tokens to populate all fields including LogFormat and Table.
 	if err := q.parse(tokens); err != nil {
-		return nil, err
+		return nil, withQuery(err, queryStr)
 	}
 
-	// If the log format is CSV and no explicit FROM table was provided, default
-	// the table to "." so that all lines are processed without file filtering.
-	// This check must run after parse() because LogFormat is only populated
-	// once parseTokens() has processed the "logformat" keyword.
-	if q.LogFormat == "csv" && q.Table == "" {
+	// If the log format has no MAPREDUCE:TABLE marker (e.g. CSV) and no explicit
+	// FROM table was provided, default the table to "." so that all lines are
+	// processed without file filtering. This check must run after parse()
+	// because LogFormat is only populated once parseTokens() has processed the
+	// "logformat" keyword.
+	if _, ok := tablelessLogFormats[q.LogFormat]; ok && q.Table == "" {
 		q.Table = "."
 	}
 
@@ -102,6 +161,23 @@ func (q *Query) Has(what string) bool {
 	return strings.Contains(q.RawQuery, what)
 }
 
+// UnitValue returns a field value with a unit, e.g. 123ms or 4.2MB, as a plain
+// number if the 'units' keyword is used, see Units. Other values are returned
+// as they are.
+func (q *Query) UnitValue(value string) string {
+	if q.Units == 0 {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	f, ok := funcs.ParseUnitValue(value, q.Units)
+	if !ok {
+		return value
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 func (q *Query) parse(tokens []token) error {
 	if _, err := q.parseTokens(tokens); err != nil {
 		return fmt.Errorf("failed to parse query tokens: %w", err)
@@ -112,25 +188,218 @@ func (q *Query) parse(tokens []token) error {
 			"clause but got none")
 	}
 
+	q.aggregations = makeAggregations(q.Select)
+
+	if q.Distinct {
+		if err := q.groupByDistinct(); err != nil {
+			return err
+		}
+	}
+
 	if len(q.GroupBy) == 0 {
 		field := q.Select[0].Field
 		q.GroupBy = append(q.GroupBy, field)
+		if q.Select[0].fieldIsCall {
+			q.groupByCalls = append(q.groupByCalls, field)
+		}
 	}
 
-	if q.OrderBy != "" {
-		var orderFieldIsValid bool
-		for _, sc := range q.Select {
-			if q.OrderBy == sc.FieldStorage {
-				orderFieldIsValid = true
-				break
+	if err := q.addFunctionFields(); err != nil {
+		return err
+	}
+
+	if q.Units != 0 {
+		q.Where.setUnits(q.Units)
+	}
+
+	for _, wc := range q.Having.conditions() {
+		for _, field := range wc.fields() {
+			if !q.hasSelectStorage(field) {
+				return errors.New(invalidQuery + fmt.Sprintf("Can not use '%s' in 'having' "+
+					"clause, must be present in 'select' clause", field))
 			}
 		}
-		if !orderFieldIsValid {
+	}
+
+	// Aliases are resolved, so the result is ordered and limited by the values
+	// stored for the select conditions.
+	for i, field := range q.LimitPer {
+		storage, ok := q.selectStorage(field)
+		if !ok {
+			return errors.New(invalidQuery + fmt.Sprintf("Can not 'limit per' '%s', "+
+				"must be present in 'select' clause", field))
+		}
+		q.LimitPer[i] = storage
+	}
+
+	for i, key := range q.OrderBy {
+		storage, ok := q.selectStorage(key.Field)
+		if !ok {
 			return errors.New(invalidQuery + fmt.Sprintf("Can not '(r)order by' '%s',"+
-				"must be present in 'select' clause", q.OrderBy))
+				"must be present in 'select' clause", key.Field))
+		}
+		q.OrderBy[i].Field = storage
+	}
+
+	return nil
+}
+
+// groupByDistinct groups a 'select distinct' query by all selected fields, so
+// that there is one group per distinct value combination.
+func (q *Query) groupByDistinct() error {
+	if len(q.GroupBy) > 0 {
+		return errors.New(invalidQuery + "Can not use 'group by' with 'select distinct', " +
+			"which groups by the selected fields")
+	}
+	for _, sc := range q.Select {
+		if sc.Operation != Last || sc.Field != sc.FieldStorage {
+			return errors.New(invalidQuery + fmt.Sprintf("Can not use aggregation '%s' "+
+				"with 'select distinct', expected a field", sc.FieldStorage))
+		}
+		q.GroupBy = append(q.GroupBy, sc.Field)
+		if sc.fieldIsCall {
+			q.groupByCalls = append(q.groupByCalls, sc.Field)
+		}
+	}
+	q.GroupKey = strings.Join(q.GroupBy, ",")
+	return nil
+}
+
+// Aggregations returns all aggregations the servers compute and the client
+// merges. These are the select conditions, whereas arithmetic expressions are
+// replaced by the aggregations they are computed from, e.g. sum(bytes) and
+// count(req) for sum(bytes)/count(req).
+func (q *Query) Aggregations() []selectCondition {
+	return q.aggregations
+}
+
+func makeAggregations(sel []selectCondition) []selectCondition {
+	var aggregations []selectCondition
+	seen := make(map[string]struct{}, len(sel))
+	add := func(sc selectCondition) {
+		if _, ok := seen[sc.FieldStorage]; ok {
+			return
+		}
+		seen[sc.FieldStorage] = struct{}{}
+		aggregations = append(aggregations, sc)
+	}
+
+	for _, sc := range sel {
+		if sc.Operation != Expression {
+			add(sc)
+			continue
+		}
+		for _, operand := range sc.expr.operands() {
+			if o, ok := operand.(*aggregationOperand); ok {
+				add(o.sc)
+			}
+		}
+	}
+	return aggregations
+}
+
+// addFunctionFields adds a set condition for every function call used as a
+// field in the 'select' or 'group by' clause, e.g. bucket($time,1m). The result
+// of each call is stored under the call expression itself, so that it can be
+// aggregated and grouped like any field extracted from the log line. They are
+// evaluated after the explicit set conditions, so they can use their results.
+func (q *Query) addFunctionFields() error {
+	calls := make([]string, 0, len(q.groupByCalls))
+	for _, sc := range q.aggregations {
+		if sc.fieldIsCall {
+			calls = append(calls, sc.Field)
+		}
+	}
+	calls = append(calls, q.groupByCalls...)
+
+	added := make(map[string]struct{}, len(calls))
+	for _, expression := range calls {
+		if _, ok := added[expression]; ok {
+			continue
+		}
+		added[expression] = struct{}{}
+		sc, err := newFunctionSetCondition(expression)
+		if err != nil {
+			return err
+		}
+		q.Set = append(q.Set, sc)
+	}
+	return nil
+}
+
+// isOrderKey returns true if the result is sorted by the given select storage.
+func (q *Query) isOrderKey(storage string) bool {
+	for _, key := range q.OrderBy {
+		if key.Field == storage {
+			return true
 		}
 	}
+	return false
+}
 
+// makeOrderKeys parses the tokens of the 'order by' clause, e.g.
+// "$hostname asc, count(x) desc". Every sort key can be followed by its
+// direction, otherwise the given default direction is used.
+func makeOrderKeys(tokens []token, ascending bool) ([]OrderKey, error) {
+	var keys []OrderKey
+	directed := false
+	for _, t := range joinArithTokens(tokens) {
+		if !t.isOperator("asc") && !t.isOperator("desc") {
+			keys = append(keys, OrderKey{Field: t.str, Ascending: ascending})
+			directed = false
+			continue
+		}
+		if len(keys) == 0 || directed {
+			return nil, newTokenError(t, "Expected field before '"+t.str+
+				"' in 'order by' clause")
+		}
+		keys[len(keys)-1].Ascending = t.isOperator("asc")
+		directed = true
+	}
+	if len(keys) == 0 {
+		return nil, errors.New(invalidQuery + unexpectedEnd)
+	}
+	return keys, nil
+}
+
+// hasSelectStorage returns true if the select clause stores a value under the
+// given name, e.g. "count(path)", or if the name is an alias.
+func (q *Query) hasSelectStorage(storage string) bool {
+	_, ok := q.selectStorage(storage)
+	return ok
+}
+
+// selectStorage returns the name the select clause stores the value of the
+// given name under, which differs from the name if it is an alias.
+func (q *Query) selectStorage(name string) (string, bool) {
+	for _, sc := range q.Select {
+		if sc.FieldStorage == name || sc.Alias == name {
+			return sc.FieldStorage, true
+		}
+	}
+	return "", false
+}
+
+// parseOffset parses the 'offset' at the end of the 'limit' clause, e.g. of
+// "limit 20 offset 40". The rest are the tokens following the 'limit' clause.
+func (q *Query) parseOffset(found, rest []token) error {
+	if len(found) == 0 {
+		if len(rest) > 0 {
+			return newTokenError(rest[0], unexpectedEnd)
+		}
+		return newEndError(unexpectedEnd)
+	}
+	if len(found) > 1 {
+		return newTokenError(found[1], "Unexpected token after 'offset': "+found[1].str)
+	}
+	i, err := strconv.Atoi(found[0].str)
+	if err != nil {
+		return newTokenError(found[0], err.Error())
+	}
+	if i < 0 {
+		return newTokenError(found[0], "'offset' must not be negative")
+	}
+	q.Offset = i
 	return nil
 }
 
@@ -142,26 +411,51 @@ func (q *Query) parseTokens(tokens []token) ([]token, error) {
 	var err error
 	var found []token
 
+	// The arguments of a clause are missing before the next keyword, or at
+	// the end of the query.
+	missing := func(rest []token) error {
+		if len(rest) > 0 {
+			return newTokenError(rest[0], unexpectedEnd)
+		}
+		return newEndError(unexpectedEnd)
+	}
+
 	for len(tokens) > 0 {
-		switch strings.ToLower(tokens[0].str) {
+		keyword := tokens[0]
+		switch strings.ToLower(keyword.str) {
 		case "select":
 			tokens, found = tokensConsume(tokens[1:])
+			if len(found) > 1 && found[0].isOperator("distinct") {
+				q.Distinct = true
+				found = found[1:]
+			}
 			q.Select, err = makeSelectConditions(found)
 			if err != nil {
 				return tokens, err
 			}
+		case "explain":
+			tokens = tokens[1:]
+			q.Explain = true
 		case "from":
 			tokens, found = tokensConsume(tokens[1:])
 			if len(found) == 0 {
-				return tokens, errors.New(invalidQuery + "expected table name after 'from'")
+				return tokens, newTokenError(keyword, "expected table name after 'from'")
 			}
 			if len(found) > 1 {
-				return tokens, errors.New(invalidQuery + "expected only one table name after 'from'")
+				return tokens, newTokenError(found[1], "expected only one table name after 'from'")
 			}
 			q.Table = strings.ToUpper(found[0].str)
 		case "where":
 			tokens, found = tokensConsume(tokens[1:])
-			if q.Where, err = makeWhereConditions(found); err != nil {
+			if q.Where, err = makeWhereExpr(found); err != nil {
+				return tokens, err
+			}
+		case "having":
+			tokens, found = tokensConsume(tokens[1:])
+			if len(found) == 0 {
+				return tokens, missing(tokens)
+			}
+			if q.Having, err = makeWhereExpr(found); err != nil {
 				return tokens, err
 			}
 		case "set":
@@ -172,50 +466,80 @@ func (q *Query) parseTokens(tokens []token) ([]token, error) {
 		case "group":
 			tokens = tokensConsumeOptional(tokens[1:], "by")
 			if len(tokens) < 1 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
+				return tokens, missing(tokens)
+			}
+			tokens, found = tokensConsume(tokens)
+			q.GroupBy = nil
+			for _, t := range found {
+				q.GroupBy = append(q.GroupBy, t.str)
+				if !t.quotesStripped && funcs.IsCall(t.str) {
+					q.groupByCalls = append(q.groupByCalls, t.str)
+				}
 			}
-			tokens, q.GroupBy = tokensConsumeStr(tokens)
 			q.GroupKey = strings.Join(q.GroupBy, ",")
 		case "rorder":
 			tokens = tokensConsumeOptional(tokens[1:], "by")
-			if len(tokens) < 1 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
-			}
 			tokens, found = tokensConsume(tokens)
 			if len(found) == 0 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
+				return tokens, missing(tokens)
+			}
+			if q.OrderBy, err = makeOrderKeys(found, true); err != nil {
+				return tokens, err
 			}
-			q.OrderBy = found[0].str
-			q.ReverseOrder = true
 		case "order":
 			tokens = tokensConsumeOptional(tokens[1:], "by")
-			if len(tokens) < 1 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
-			}
 			tokens, found = tokensConsume(tokens)
 			if len(found) == 0 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
+				return tokens, missing(tokens)
+			}
+			if q.OrderBy, err = makeOrderKeys(found, false); err != nil {
+				return tokens, err
 			}
-			q.OrderBy = found[0].str
 		case "interval":
 			tokens, found = tokensConsume(tokens[1:])
 			if len(found) > 0 {
 				i, err := strconv.Atoi(found[0].str)
 				if err != nil {
-					return tokens, errors.New(invalidQuery + err.Error())
+					return tokens, newTokenError(found[0], err.Error())
 				}
 				q.Interval = time.Second * time.Duration(i)
 			}
 		case "limit":
 			tokens, found = tokensConsume(tokens[1:])
 			if len(found) == 0 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
+				return tokens, missing(tokens)
 			}
 			i, err := strconv.Atoi(found[0].str)
 			if err != nil {
-				return tokens, errors.New(invalidQuery + err.Error())
+				return tokens, newTokenError(found[0], err.Error())
 			}
 			q.Limit = i
+			found = found[1:]
+			// 'offset' is no keyword of its own, but part of the 'limit'
+			// clause. So it can still be used as a field name elsewhere.
+			for j, t := range found {
+				if !t.isOperator("offset") {
+					continue
+				}
+				if err := q.parseOffset(found[j+1:], tokens); err != nil {
+					return tokens, err
+				}
+				found = found[:j]
+				break
+			}
+			if len(found) > 0 {
+				if !found[0].isOperator("per") {
+					return tokens, newTokenError(found[0],
+						"Unexpected token in 'limit' clause: "+found[0].str)
+				}
+				if len(found) < 2 {
+					return tokens, missing(tokens)
+				}
+				q.LimitPer = nil
+				for _, t := range found[1:] {
+					q.LimitPer = append(q.LimitPer, t.str)
+				}
+			}
 		case "outfile":
 			tokens, found = tokensConsume(tokens[1:])
 			switch len(found) {
@@ -225,21 +549,42 @@ func (q *Query) parseTokens(tokens []token) ([]token, error) {
 				if found[0].str == "append" {
 					q.Outfile = &Outfile{FilePath: found[1].str, AppendMode: true}
 				} else {
-					return tokens, errors.New(invalidQuery + invalidQuery)
+					return tokens, newTokenError(found[0],
+						"Expected 'append' or file name in 'outfile' clause: "+found[0].str)
 				}
 			default:
-				return tokens, errors.New(invalidQuery + invalidQuery)
+				return tokens, newTokenError(keyword, "Expected [append] file name after 'outfile'")
 			}
 		case "logformat":
 			tokens, found = tokensConsume(tokens[1:])
 			if len(found) == 0 {
-				return tokens, errors.New(invalidQuery + unexpectedEnd)
+				return tokens, missing(tokens)
 			}
 			q.LogFormat = found[0].str
+		case "units":
+			tokens, found = tokensConsume(tokens[1:])
+			q.Units = time.Second
+			if len(found) > 1 {
+				return tokens, newTokenError(found[1], "Unexpected token in 'units' clause: "+
+					found[1].str)
+			}
+			if len(found) == 1 {
+				if !isDurationUnit(found[0].str) {
+					return tokens, newTokenError(found[0], "Unknown duration unit in 'units' "+
+						"clause: "+found[0].str)
+				}
+				q.Units, _ = time.ParseDuration("1" + found[0].str)
+			}
 		default:
-			return tokens, errors.New(invalidQuery + "Unexpected keyword " + tokens[0].str)
+			return tokens, newTokenError(keyword, "Unexpected keyword "+keyword.str)
 		}
 	}
 
 	return tokens, nil
 }
+
+// isDurationUnit returns true if str is the unit of a duration, e.g. ms.
+func isDurationUnit(str string) bool {
+	_, err := time.ParseDuration("1" + str)
+	return err == nil && !strings.ContainsAny(str, "0123456789.")
+}
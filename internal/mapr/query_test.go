@@ -154,33 +154,34 @@ func TestParseQueryDeep(t *testing.T) {
 		}
 
 		// 'where' clause
-		if len(q.Where) != 2 {
+		where := q.Where.conditions()
+		if len(where) != 2 {
 			t.Errorf("Expected two elements in 'where' clause but got '%v': %s\n%v",
 				q.Where, queryStr, q)
 		}
-		if q.Where[0].lString != "w1" {
+		if where[0].lString != "w1" {
 			t.Errorf("Expected w1 as first element in 'where' clause but got '%v': %s\n%v",
-				q.Where[0].lString, queryStr, q)
+				where[0].lString, queryStr, q)
 		}
-		if q.Where[0].Operation != FloatEq {
+		if where[0].Operation != FloatEq {
 			t.Errorf("Expected FloatEq operation in first 'where' condition but got "+
-				"'%v': %s\n%v", q.Where[0].Operation, queryStr, q)
+				"'%v': %s\n%v", where[0].Operation, queryStr, q)
 		}
-		if q.Where[0].rFloat != 2 {
+		if where[0].rFloat != 2 {
 			t.Errorf("Expected '2' as float argument in first 'where' condition but "+
-				"got '%v': %s\n%v", q.Where[0].rFloat, queryStr, q)
+				"got '%v': %s\n%v", where[0].rFloat, queryStr, q)
 		}
-		if q.Where[1].lString != "w2" {
+		if where[1].lString != "w2" {
 			t.Errorf("Expected w2 as second element in 'where' clause but got '%v': "+
-				"%s\n%v", q.Where[1].lString, queryStr, q)
+				"%s\n%v", where[1].lString, queryStr, q)
 		}
-		if q.Where[1].Operation != StringEq {
+		if where[1].Operation != StringEq {
 			t.Errorf("Expected StringEq operation in second 'where' condition but got "+
-				"'%v': %s\n%v", q.Where[0].Operation, queryStr, q)
+				"'%v': %s\n%v", where[0].Operation, queryStr, q)
 		}
-		if q.Where[1].rString != "free beer" {
+		if where[1].rString != "free beer" {
 			t.Errorf("Expected 'free beer' as string argument in second 'where' "+
-				"condition but got '%v': %s\n%v", q.Where[0].rString, queryStr, q)
+				"condition but got '%v': %s\n%v", where[0].rString, queryStr, q)
 		}
 
 		// 'group by' clause
@@ -202,7 +203,7 @@ func TestParseQueryDeep(t *testing.T) {
 		}
 
 		// 'order by' clause
-		if q.OrderBy != "count(s3)" {
+		if len(q.OrderBy) != 1 || q.OrderBy[0].Field != "count(s3)" {
 			t.Errorf("Expected 'count(s3)' as element in 'order by' clause but got "+
 				"'%v': %s\n%v", q.OrderBy, queryStr, q)
 		}
@@ -224,9 +225,9 @@ func TestParseQueryDeep(t *testing.T) {
 			t.Errorf("Expected '$foo' lvalue in first 'set' condition clause but got "+
 				"'%v': %s\n%v", q.Set[0].lString, queryStr, q)
 		}
-		if q.Set[0].rString != "bar" {
-			t.Errorf("Expected 'bar' rvalue in first 'set' condition clause but got "+
-				"'%v': %s\n%v", q.Set[0].rString, queryStr, q)
+		if fields := q.Set[0].fields(); len(fields) != 1 || fields[0] != "bar" {
+			t.Errorf("Expected 'bar' as field read by first 'set' condition clause but got "+
+				"'%v': %s\n%v", fields, queryStr, q)
 		}
 		if q.Set[1].lString != "$baz" {
 			t.Errorf("Expected '$baz' lvalue in second 'set' condition clause but got "+
@@ -370,7 +371,7 @@ func TestParseQueryPercentageAndPercentile(t *testing.T) {
 			q.Select[1].FieldStorage, queryStr, q)
 	}
 
-	if q.OrderBy != "percentile($value)" {
+	if len(q.OrderBy) != 1 || q.OrderBy[0].Field != "percentile($value)" {
 		t.Errorf("Expected order by percentile($value) but got '%v': %s\n%v",
 			q.OrderBy, queryStr, q)
 	}
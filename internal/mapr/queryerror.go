@@ -0,0 +1,80 @@
+package mapr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// endOfQuery is the offset of errors at the end of the query, e.g. when a
+// clause is missing its arguments. NewQuery sets it to the query's length.
+const endOfQuery = -2
+
+// QueryError is an error parsing a query, which knows where in the query the
+// problem is.
+type QueryError struct {
+	// Query is the query string which was parsed.
+	Query string
+	// Offset is the byte offset of the offending token in the query, or -1
+	// if it is unknown.
+	Offset int
+	msg    string
+}
+
+// newTokenError returns an error at the position of the given token.
+func newTokenError(t token, msg string) error {
+	return &QueryError{Offset: t.pos, msg: invalidQuery + msg}
+}
+
+// newEndError returns an error at the end of the query.
+func newEndError(msg string) error {
+	return &QueryError{Offset: endOfQuery, msg: invalidQuery + msg}
+}
+
+// withQuery sets the query string of a query error, if err is one.
+func withQuery(err error, queryStr string) error {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		qe.Query = queryStr
+		if qe.Offset == endOfQuery {
+			qe.Offset = len(queryStr)
+		}
+	}
+	return err
+}
+
+func (e *QueryError) Error() string {
+	if e.Offset < 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("%s (at offset %d)", e.msg, e.Offset)
+}
+
+// FormatError formats a query error for the user: the error message followed
+// by the line of the query the error is in, with a caret under the offending
+// token. Any other error is returned as it is.
+func FormatError(err error) string {
+	var qe *QueryError
+	if !errors.As(err, &qe) || qe.Offset < 0 || qe.Offset > len(qe.Query) {
+		return err.Error()
+	}
+
+	lineStart := strings.LastIndexByte(qe.Query[:qe.Offset], '\n') + 1
+	lineEnd := len(qe.Query)
+	if i := strings.IndexByte(qe.Query[qe.Offset:], '\n'); i >= 0 {
+		lineEnd = qe.Offset + i
+	}
+
+	// Tabs are kept, so the caret lines up with the query regardless of the
+	// tab width of the terminal.
+	var caret strings.Builder
+	for _, r := range qe.Query[lineStart:qe.Offset] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	return fmt.Sprintf("%s\n%s\n%s", err.Error(), qe.Query[lineStart:lineEnd], caret.String())
+}
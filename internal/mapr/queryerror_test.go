@@ -0,0 +1,66 @@
+package mapr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQueryErrorOffset(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"select count(foo) from stats where bar == \"baz", 42},
+		{"select nosuchagg(foo) from stats", 7},
+		{"select foo from stats where foo ~= 1", 32},
+		{"select foo from stats where foo == 1 and", 40},
+		{"select foo from stats where (foo == 1", 28},
+		{"select foo from stats set foo = 1", 26},
+		{"select foo from stats limit x", 28},
+		{"select foo from stats group by", 30},
+		{"select foo from stats bogus", 22},
+	}
+
+	for _, tt := range tests {
+		_, err := NewQuery(tt.query)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("Query %q: expected a query error, got %v", tt.query, err)
+			continue
+		}
+		if qe.Offset != tt.offset || qe.Query != tt.query {
+			t.Errorf("Query %q: got error at offset %d (%v), want offset %d",
+				tt.query, qe.Offset, err, tt.offset)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewQuery("select count(foo) from stats\n\twhere bar =~ \"(\"")
+	if err == nil {
+		t.Fatalf("Expected an error parsing an invalid regex")
+	}
+	lines := strings.Split(FormatError(err), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected the message, query line and caret, got %q", lines)
+	}
+	if !strings.Contains(lines[0], invalidQuery+"Invalid regex") {
+		t.Errorf("Unexpected error message %q", lines[0])
+	}
+	if lines[1] != "\twhere bar =~ \"(\"" {
+		t.Errorf("Expected the second query line, got %q", lines[1])
+	}
+	if lines[2] != "\t             ^" {
+		t.Errorf("Caret doesn't point at the regex: %q", lines[2])
+	}
+
+	plain := errors.New("some error")
+	if FormatError(plain) != "some error" {
+		t.Errorf("Expected other errors to be formatted as they are")
+	}
+}
@@ -0,0 +1,154 @@
+package mapr
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// whereOperators are the operators of the where conditions as written in the
+// query, used to explain the query.
+var whereOperators = map[QueryOperation]string{
+	StringEq:           "eq",
+	StringNe:           "ne",
+	StringContains:     "contains",
+	StringNotContains:  "ncontains",
+	StringHasPrefix:    "hasprefix",
+	StringNotHasPrefix: "nhasprefix",
+	StringHasSuffix:    "hassuffix",
+	StringNotHasSuffix: "nhassuffix",
+	StringMatches:      "=~",
+	StringNotMatches:   "!~",
+	StringIn:           "in",
+	StringNotIn:        "not in",
+	FloatEq:            "==",
+	FloatNe:            "!=",
+	FloatLt:            "<",
+	FloatLe:            "<=",
+	FloatGt:            ">",
+	FloatGe:            ">=",
+}
+
+// WriteExplain writes how the query was parsed, one clause per line, e.g. for
+// 'dmap -explain'. Implicit parts of the query are included, such as the
+// default 'group by' field and the 'set' conditions of function calls.
+func (q *Query) WriteExplain(w io.Writer) {
+	line := func(clause, format string, args ...any) {
+		fmt.Fprintf(w, "%-10s %s\n", clause+":", fmt.Sprintf(format, args...))
+	}
+
+	selected := make([]string, 0, len(q.Select))
+	for _, sc := range q.Select {
+		if sc.Alias != "" {
+			selected = append(selected, sc.FieldStorage+" as "+sc.Alias)
+			continue
+		}
+		selected = append(selected, sc.FieldStorage)
+	}
+	if q.Distinct {
+		line("Select", "distinct %s", strings.Join(selected, ", "))
+	} else {
+		line("Select", "%s", strings.Join(selected, ", "))
+	}
+
+	if q.Table != "" {
+		line("From", "%s", q.Table)
+	}
+	if q.Where != nil {
+		line("Where", "%s", q.Where.explain())
+	}
+	if len(q.Set) > 0 {
+		set := make([]string, 0, len(q.Set))
+		for _, sc := range q.Set {
+			set = append(set, sc.lString+" = "+explainValue(sc.rString, sc.rType))
+		}
+		line("Set", "%s", strings.Join(set, ", "))
+	}
+	line("Group by", "%s", strings.Join(q.GroupBy, ", "))
+	if q.Having != nil {
+		line("Having", "%s", q.Having.explain())
+	}
+	if len(q.OrderBy) > 0 {
+		keys := make([]string, 0, len(q.OrderBy))
+		for _, key := range q.OrderBy {
+			direction := "desc"
+			if key.Ascending {
+				direction = "asc"
+			}
+			keys = append(keys, key.Field+" "+direction)
+		}
+		line("Order by", "%s", strings.Join(keys, ", "))
+	}
+	if q.Limit != -1 {
+		if len(q.LimitPer) > 0 {
+			line("Limit", "%d per %s", q.Limit, strings.Join(q.LimitPer, ", "))
+		} else {
+			line("Limit", "%d", q.Limit)
+		}
+	}
+	if q.Offset > 0 {
+		line("Offset", "%d", q.Offset)
+	}
+	line("Interval", "%v", q.Interval)
+	if q.Units != 0 {
+		line("Units", "%v", q.Units)
+	}
+	if q.HasOutfile() {
+		if q.Outfile.AppendMode {
+			line("Outfile", "%s (append)", q.Outfile.FilePath)
+		} else {
+			line("Outfile", "%s", q.Outfile.FilePath)
+		}
+	}
+}
+
+// explain returns the expression as it could be written in the query, with
+// parentheses around all nested 'and' and 'or' expressions.
+func (e *whereExpr) explain() string {
+	switch e.op {
+	case whereLeaf:
+		return e.condition.explain()
+	case whereNot:
+		return "not " + e.children[0].explainNested()
+	}
+	separator := " and "
+	if e.op == whereOr {
+		separator = " or "
+	}
+	children := make([]string, 0, len(e.children))
+	for _, child := range e.children {
+		children = append(children, child.explainNested())
+	}
+	return strings.Join(children, separator)
+}
+
+func (e *whereExpr) explainNested() string {
+	if e.op == whereAnd || e.op == whereOr {
+		return "(" + e.explain() + ")"
+	}
+	return e.explain()
+}
+
+func (wc *whereCondition) explain() string {
+	switch wc.Operation {
+	case FieldExists:
+		return "exists(" + wc.lString + ")"
+	case FieldMissing:
+		return "missing(" + wc.lString + ")"
+	}
+	rValue := explainValue(wc.rString, wc.rType)
+	if wc.rType == List {
+		rValue = "(" + wc.rString + ")"
+	}
+	return fmt.Sprintf("%s %s %s", explainValue(wc.lString, wc.lType),
+		whereOperators[wc.Operation], rValue)
+}
+
+// explainValue quotes string literals, so they can be told apart from fields.
+func explainValue(value string, t fieldType) string {
+	if t == String {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,42 @@
+package mapr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQueryWriteExplain(t *testing.T) {
+	t.Parallel()
+
+	query, err := NewQuery(`explain select path,count(path) as hits from stats ` +
+		`where status in (500, "502") and not (path hasprefix "/api" or bytes > 10) ` +
+		`set $kb = bytes / 1024 group by path having hits > 1 ` +
+		`order by hits, path asc limit 2 per path offset 1 outfile append "out.csv"`)
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if !query.Explain {
+		t.Errorf("Expected the query to be explained")
+	}
+
+	var buf bytes.Buffer
+	query.WriteExplain(&buf)
+	want := []string{
+		"Select:    path, count(path) as hits",
+		"From:      STATS",
+		`Where:     status in (500,"502") and not (path hasprefix "/api" or bytes > 10)`,
+		"Set:       $kb = bytes / 1024",
+		"Group by:  path",
+		"Having:    hits > 1",
+		"Order by:  count(path) desc, path asc",
+		"Limit:     2 per path",
+		"Offset:    1",
+		"Interval:  5s",
+		"Outfile:   out.csv (append)",
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got explanation\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
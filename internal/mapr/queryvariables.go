@@ -60,13 +60,13 @@ func (q *Query) ReferencedVariables() []string {
 		referenced[name] = struct{}{}
 	}
 
-	for _, sc := range q.Select {
+	for _, sc := range q.Aggregations() {
 		add(sc.Field)
 	}
 	for _, groupBy := range q.GroupBy {
 		add(groupBy)
 	}
-	for _, wc := range q.Where {
+	for _, wc := range q.Where.conditions() {
 		if wc.lType == Field {
 			add(wc.lString)
 		}
@@ -75,8 +75,8 @@ func (q *Query) ReferencedVariables() []string {
 		}
 	}
 	for _, sc := range q.Set {
-		if sc.rType == Field || sc.rType == FunctionStack {
-			add(sc.rString)
+		for _, field := range sc.fields() {
+			add(field)
 		}
 	}
 
@@ -52,6 +52,12 @@ func (s *SafeAggregateSet) Clone() *AggregateSet {
 	for k, v := range s.set.SValues {
 		clone.SValues[k] = v
 	}
+	for k, h := range s.set.distinct {
+		clone.distinctSketch(k).merge(h)
+	}
+	for k, d := range s.set.quantiles {
+		clone.quantileSketch(k).merge(d)
+	}
 
 	return clone
 }
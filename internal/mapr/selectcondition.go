@@ -3,7 +3,10 @@ package mapr
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 )
 
 // AggregateOperation is to specify the aggregate operation type.
@@ -21,28 +24,127 @@ const (
 	Len                     AggregateOperation = iota
 	Percentage              AggregateOperation = iota
 	Percentile              AggregateOperation = iota
+	CountDistinct           AggregateOperation = iota
+	Quantile                AggregateOperation = iota
+	Variance                AggregateOperation = iota
+	StdDev                  AggregateOperation = iota
+	First                   AggregateOperation = iota
+	Histogram               AggregateOperation = iota
+	Delta                   AggregateOperation = iota
+	Rate                    AggregateOperation = iota
+	// Expression is an arithmetic expression of aggregations, e.g.
+	// sum(bytes)/count(req), computed once the results were merged.
+	Expression AggregateOperation = iota
 )
 
+// IsNumeric returns true if the operation aggregates the values of a field as
+// numbers, unlike e.g. count, last or count_distinct.
+func (op AggregateOperation) IsNumeric() bool {
+	switch op {
+	case UndefAggregateOperation, Count, Last, Len, CountDistinct, First, Expression:
+		return false
+	default:
+		return true
+	}
+}
+
+// FirstOrderField is the field ordering the values of the first aggregation,
+// the timestamp of the log line.
+const FirstOrderField = "$time"
+
+// The quantile shorthand aggregations, e.g. p99(latency) for
+// quantile(latency,0.99).
+var quantileShorthands = map[string]float64{
+	"p50":  0.5,
+	"p90":  0.9,
+	"p95":  0.95,
+	"p99":  0.99,
+	"p999": 0.999,
+}
+
 // Represents a parsed "select" clause, used by mapr.Query.
 type selectCondition struct {
 	Field        string
 	FieldStorage string
 	Operation    AggregateOperation
+	// The alias of the 'as' keyword, e.g. hits for count(path) as hits.
+	Alias string
+	// Whether the field is a function call, e.g. bucket($time,1m), which is
+	// evaluated for every line.
+	fieldIsCall bool
+	// The quantile (between 0 and 1) of the quantile aggregation.
+	quantile float64
+	// The buckets of the histogram aggregations.
+	histogram histogramBuckets
+	// The arithmetic expression of the Expression operation.
+	expr *arithExpr
 }
 
 func (sc selectCondition) String() string {
-	return fmt.Sprintf("selectCondition(Field:%s,FieldStorage:%s,Operation:%v)",
+	return fmt.Sprintf("selectCondition(Field:%s,FieldStorage:%s,Operation:%v,Alias:%s)",
 		sc.Field,
 		sc.FieldStorage,
-		sc.Operation)
+		sc.Operation,
+		sc.Alias)
 }
 
+// name returns the name of the column in the result, which is the alias if
+// there is one.
+func (sc selectCondition) name() string {
+	if sc.Alias != "" {
+		return sc.Alias
+	}
+	return sc.FieldStorage
+}
+
+// HistogramBucket returns the upper bound of the bucket a value is counted in
+// by a histogram aggregation.
+func (sc selectCondition) HistogramBucket(value float64) float64 {
+	return sc.histogram.bucket(value)
+}
+
+// errNotAggregation is returned for a plain field used in an arithmetic
+// expression of a 'select' clause, which can only combine aggregations.
+var errNotAggregation = errors.New(invalidQuery + "Expected aggregation in 'select' expression")
+
 func makeSelectConditions(tokens []token) ([]selectCondition, error) {
 	var sel []selectCondition
+	var parse func(token token) (selectCondition, error)
+
+	// Parse an arithmetic expression of aggregations, e.g. sum(foo)/count(bar)
+	parseExpression := func(token token) (selectCondition, error) {
+		expr, err := parseArithExpr(token.str, func(operand string) (arithOperand, error) {
+			sc, err := parse(newBarewordToken(operand))
+			if err != nil {
+				return nil, err
+			}
+			if !strings.Contains(operand, "(") {
+				return nil, errNotAggregation
+			}
+			return &aggregationOperand{sc: sc}, nil
+		})
+		if err != nil {
+			return selectCondition{}, err
+		}
+		return selectCondition{
+			FieldStorage: token.str,
+			Operation:    Expression,
+			expr:         expr,
+		}, nil
+	}
+
 	// Parse select aggregation, e.g. sum(foo)
-	parse := func(token token) (selectCondition, error) {
+	parse = func(token token) (selectCondition, error) {
 		var sc selectCondition
 
+		if !token.quotesStripped && hasArithOperator(token.str) {
+			sc, err := parseExpression(token)
+			// Otherwise it's a field name containing an operator, e.g. foo-bar.
+			if err != errNotAggregation || strings.ContainsAny(token.str, "()") {
+				return sc, err
+			}
+		}
+
 		// With quotes stripped: We got a quoted select expression, e.g.
 		// "select `count($foo)` ...", which will literaly look for field
 		// "count($foo)" without performing the count aggregation.
@@ -53,20 +155,14 @@ func makeSelectConditions(tokens []token) ([]selectCondition, error) {
 			return sc, nil
 		}
 
-		a := strings.Split(token.str, "(")
-		if len(a) != 2 {
-			return sc, errors.New(invalidQuery + "Can't parse 'select' aggregation: " +
+		index := strings.IndexByte(token.str, '(')
+		if index <= 0 || !strings.HasSuffix(token.str, ")") {
+			return sc, newTokenError(token, "Can't parse 'select' aggregation: "+
 				token.str)
 		}
-		agg := a[0] // Aggregation, e.g. 'sum'
-
-		b := strings.Split(a[1], ")")
-		if len(b) != 2 {
-			return sc, errors.New(invalidQuery + "Can't parse 'select' field name " +
-				"from aggregation: " + token.str)
-		}
-		sc.Field = b[0]             // Field name, e.g. 'foo'
-		sc.FieldStorage = token.str // e.g. 'sum(foo)'
+		agg := token.str[:index]                         // Aggregation, e.g. 'sum'
+		sc.Field = token.str[index+1 : len(token.str)-1] // Field name, e.g. 'foo'
+		sc.FieldStorage = token.str                      // e.g. 'sum(foo)'
 
 		switch agg {
 		case "count":
@@ -87,18 +183,101 @@ func makeSelectConditions(tokens []token) ([]selectCondition, error) {
 			sc.Operation = Percentage
 		case "percentile":
 			sc.Operation = Percentile
+		case "variance":
+			sc.Operation = Variance
+		case "stddev":
+			sc.Operation = StdDev
+		case "first":
+			sc.Operation = First
+		case "count_distinct":
+			sc.Operation = CountDistinct
+		case "quantile":
+			sc.Operation = Quantile
+			// The quantile is the last argument, e.g. quantile(latency,0.99).
+			comma := strings.LastIndexByte(sc.Field, ',')
+			if comma < 0 {
+				return sc, newTokenError(token, "Expected field and quantile in 'select' "+
+					"aggregation: "+token.str)
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(sc.Field[comma+1:]), 64)
+			if err != nil || q < 0 || q > 1 {
+				return sc, newTokenError(token, "Expected quantile between 0 and 1 in "+
+					"'select' aggregation: "+token.str)
+			}
+			sc.Field = strings.TrimSpace(sc.Field[:comma])
+			sc.quantile = q
+		case "delta":
+			sc.Operation = Delta
+		case "rate":
+			sc.Operation = Rate
+		case "histogram", "histogram_exp":
+			sc.Operation = Histogram
+			// The buckets are the last arguments, e.g. histogram(latency,10,50).
+			field, buckets, ok := parseHistogramBuckets(sc.Field, agg == "histogram_exp")
+			if !ok {
+				return sc, newTokenError(token, "Expected field and ascending bucket bounds, "+
+					"or a base of at least "+strconv.FormatFloat(histogramExpMinBase, 'g', -1, 64)+
+					", in 'select' aggregation: "+token.str)
+			}
+			sc.Field = field
+			sc.histogram = buckets
 		default:
-			return sc, errors.New(invalidQuery + "Unknown aggregation in 'select' clause: " + agg)
+			if q, ok := quantileShorthands[agg]; ok {
+				sc.Operation = Quantile
+				sc.quantile = q
+				break
+			}
+			// Not an aggregation but a function call, e.g. bucket($time,1m). It
+			// selects the function result like any other field.
+			if funcs.IsCall(token.str) {
+				sc.Field = token.str
+				sc.Operation = Last
+				sc.fieldIsCall = true
+				return sc, nil
+			}
+			return sc, newTokenError(token, "Unknown aggregation in 'select' clause: "+agg)
+		}
+
+		// The field itself can be a function call, e.g. count(bucket($time,1m)).
+		if strings.ContainsAny(sc.Field, "()") {
+			if !funcs.IsCall(sc.Field) {
+				return sc, newTokenError(token, "Can't parse 'select' field name "+
+					"from aggregation: "+token.str)
+			}
+			sc.fieldIsCall = true
 		}
 		return sc, nil
 	}
 
-	for _, token := range tokens {
-		sc, err := parse(token)
+	joined := joinArithTokens(tokens)
+	for i := 0; i < len(joined); i++ {
+		sc, err := parse(joined[i])
 		if err != nil {
 			return nil, err
 		}
+		// An optional alias, e.g. count(path) as hits.
+		if i+1 < len(joined) && joined[i+1].isOperator("as") {
+			if i+2 >= len(joined) {
+				return nil, newTokenError(joined[i+1], "Expected alias after 'as' in 'select' "+
+					"clause: "+joined[i].str)
+			}
+			sc.Alias = joined[i+2].str
+			i += 2
+		}
 		sel = append(sel, sc)
 	}
+
+	// An alias must name a single column only.
+	for i, sc := range sel {
+		if sc.Alias == "" {
+			continue
+		}
+		for j, other := range sel {
+			if i != j && (sc.Alias == other.Alias || sc.Alias == other.FieldStorage) {
+				return nil, errors.New(invalidQuery + "Ambiguous alias in 'select' clause: " +
+					sc.Alias)
+			}
+		}
+	}
 	return sel, nil
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,6 +14,7 @@ import (
 	"github.com/mimecast/dtail/internal/io/dlog"
 	"github.com/mimecast/dtail/internal/io/pool"
 	"github.com/mimecast/dtail/internal/mapr"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 	"github.com/mimecast/dtail/internal/mapr/logformat"
 )
 
@@ -35,6 +37,18 @@ type Aggregate struct {
 	// Group sets are swapped out during serialization to avoid clone-heavy flushes.
 	groupMu   sync.Mutex
 	groupSets map[string]*mapr.AggregateSet
+	// The group keys of a 'select distinct' query sent already or about to be
+	// sent, so every distinct value combination is sent only once. Guarded by
+	// groupMu.
+	distinctSeen    map[string]struct{}
+	distinctLimit   int
+	distinctDropped bool
+	// The last samples of the counters of the delta and rate aggregations,
+	// which are kept across serializations, and the number of the current
+	// interval, see pruneCountersLocked. Guarded by groupMu.
+	counters        map[counterKey]counterSample
+	counterInterval uint64
+	untimedSkipped  bool
 	// serializeMu ensures only one serialization runs at a time.
 	serializeMu sync.Mutex
 	// Batch processing
@@ -77,6 +91,23 @@ type rawLine struct {
 	sourceID string
 }
 
+// counterKey identifies a counter of the delta and rate aggregations. The
+// samples of every source are compared separately, as e.g. every log file
+// may be written by a process with counters of its own.
+type counterKey struct {
+	storage  string
+	groupKey string
+	sourceID string
+}
+
+// counterSample is a value of a counter, its time in Unix seconds and the
+// interval it was sampled in.
+type counterSample struct {
+	value    float64
+	time     float64
+	interval uint64
+}
+
 func (a *Aggregate) stopping() bool {
 	select {
 	case <-a.done.Done():
@@ -130,12 +161,24 @@ func NewAggregate(queryStr string, defaultLogFormat string) (*Aggregate, error)
 		query:         query,
 		parser:        logParser,
 		groupSets:     make(map[string]*mapr.AggregateSet),
+		distinctSeen:  make(map[string]struct{}),
+		distinctLimit: distinctLimit(),
+		counters:      make(map[counterKey]counterSample),
 		batchSize:     100, // Process 100 lines at a time
 		batch:         make([]rawLine, 0, 100),
 		started:       make(chan struct{}),
 	}, nil
 }
 
+// distinctLimit returns the configured maximum number of distinct value
+// combinations of a 'select distinct' query.
+func distinctLimit() int {
+	if config.Common != nil && config.Common.MapreduceDistinctLimit > 0 {
+		return config.Common.MapreduceDistinctLimit
+	}
+	return mapr.DefaultDistinctLimit
+}
+
 // countGroups returns the current number of groups in the aggregation.
 func (a *Aggregate) countGroups() int {
 	a.groupMu.Lock()
@@ -312,25 +355,54 @@ func (a *Aggregate) processLine(lineContent *bytes.Buffer, sourceID string) erro
 	}
 
 	// Aggregate the fields
-	a.aggregate(parsedFields)
+	a.aggregate(parsedFields, sourceID)
 	return nil
 }
 
 // aggregate adds fields to the appropriate group. The set is only created (or
 // looked up) after at least one select field matches, preventing empty sets with
 // Samples==0 from entering the map and causing 0/0 = NaN on the client for Avg.
-func (a *Aggregate) aggregate(fields map[string]string) {
+func (a *Aggregate) aggregate(fields map[string]string, sourceID string) {
 	groupKey := buildGroupKey(a.query.GroupBy, fields)
 	a.groupMu.Lock()
+	if a.query.Distinct && !a.isFirstSeenLocked(groupKey) {
+		a.groupMu.Unlock()
+		return
+	}
 
 	var set *mapr.AggregateSet
 	var addedSample bool
 
-	for _, sc := range a.query.Select {
+	for _, sc := range a.query.Aggregations() {
 		val, ok := fields[sc.Field]
 		if !ok {
 			continue
 		}
+		if sc.Operation.IsNumeric() {
+			val = a.query.UnitValue(val)
+		}
+		var previous, current counterSample
+		if sc.Operation == mapr.Delta || sc.Operation == mapr.Rate {
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				dlog.Server.Error("Aggregate aggregation error", err, "field", sc.Field, "operation", sc.Operation)
+				continue
+			}
+			current = counterSample{value: f, interval: a.counterInterval}
+			if sc.Operation == mapr.Rate {
+				if current.time, ok = sampleTime(fields); !ok {
+					a.skipUntimedLocked(sc.Field)
+					continue
+				}
+			}
+			key := counterKey{storage: sc.FieldStorage, groupKey: groupKey, sourceID: sourceID}
+			previous, ok = a.counters[key]
+			a.counters[key] = current
+			if !ok {
+				// The first sample of a counter has nothing to compare with.
+				continue
+			}
+		}
 		// Lazily look up or allocate the aggregate set on the first matching
 		// field so that lines with no matching fields never create empty entries.
 		if set == nil {
@@ -340,6 +412,27 @@ func (a *Aggregate) aggregate(fields map[string]string) {
 				a.groupSets[groupKey] = set
 			}
 		}
+		if sc.Operation == mapr.First {
+			set.AggregateFirst(sc.FieldStorage, val, fields[mapr.FirstOrderField])
+			addedSample = true
+			continue
+		}
+		if sc.Operation == mapr.Delta || sc.Operation == mapr.Rate {
+			set.AggregateCounter(sc.FieldStorage, sc.Operation, previous.value, current.value,
+				previous.time, current.time)
+			addedSample = true
+			continue
+		}
+		if sc.Operation == mapr.Histogram {
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				dlog.Server.Error("Aggregate aggregation error", err, "field", sc.Field, "operation", sc.Operation)
+				continue
+			}
+			set.AggregateHistogram(sc.FieldStorage, sc.HistogramBucket(f))
+			addedSample = true
+			continue
+		}
 		if err := set.Aggregate(sc.FieldStorage, sc.Operation, val, false); err != nil {
 			dlog.Server.Error("Aggregate aggregation error", err, "field", sc.Field, "operation", sc.Operation)
 			continue
@@ -348,10 +441,53 @@ func (a *Aggregate) aggregate(fields map[string]string) {
 	}
 	if addedSample {
 		set.Samples++
+		if a.query.Distinct {
+			a.distinctSeen[groupKey] = struct{}{}
+		}
 	}
 	a.groupMu.Unlock()
 }
 
+// sampleTime returns the time of a counter sample in Unix seconds, which is the
+// time of the log line, or false if the line has none.
+func sampleTime(fields map[string]string) (float64, bool) {
+	t, ok := funcs.ParseTime(fields[mapr.FirstOrderField])
+	if !ok {
+		return 0, false
+	}
+	return float64(t.UnixNano()) / float64(time.Second), true
+}
+
+// skipUntimedLocked warns once that the samples of a rate aggregation of lines
+// without a time are skipped. The time the line was read at can't be used
+// instead, e.g. all lines of a file read at once would be just milliseconds
+// apart. The caller must hold groupMu.
+func (a *Aggregate) skipUntimedLocked(field string) {
+	if !a.untimedSkipped {
+		a.untimedSkipped = true
+		dlog.Server.Warn("Skipping the rate samples of lines without a time", "field", field)
+	}
+}
+
+// isFirstSeenLocked returns true if the value combination of a 'select
+// distinct' query wasn't seen before and is within the limit. Combinations
+// beyond the limit are dropped, so that a field of high cardinality can't
+// exhaust the memory. The caller must hold groupMu.
+func (a *Aggregate) isFirstSeenLocked(groupKey string) bool {
+	if _, ok := a.distinctSeen[groupKey]; ok {
+		return false
+	}
+	if len(a.distinctSeen) < a.distinctLimit {
+		return true
+	}
+	if !a.distinctDropped {
+		a.distinctDropped = true
+		dlog.Server.Warn("Reached the limit of distinct value combinations, dropping "+
+			"further ones", "limit", a.distinctLimit)
+	}
+	return false
+}
+
 // serializationLoop handles periodic serialization.
 func (a *Aggregate) serializationLoop(ctx context.Context) {
 	// Start stores serializeTicker before launching this goroutine, so the load
@@ -442,29 +578,11 @@ func (a *Aggregate) mergeRemainingLocked(remaining map[string]*mapr.AggregateSet
 
 func mergeCancelledSnapshot(query *mapr.Query, live, snapshot *mapr.AggregateSet) {
 	live.Samples += snapshot.Samples
-	for _, sc := range query.Select {
+	for _, sc := range query.Aggregations() {
 		storage := sc.FieldStorage
 		switch sc.Operation {
-		case mapr.Count, mapr.Sum, mapr.Avg, mapr.Percentage, mapr.Percentile:
+		case mapr.Count, mapr.Sum, mapr.Percentage, mapr.Percentile:
 			live.FValues[storage] += snapshot.FValues[storage]
-		case mapr.Min:
-			liveValue, ok := live.FValues[storage]
-			if !ok {
-				live.FValues[storage] = snapshot.FValues[storage]
-				continue
-			}
-			if snapshotValue := snapshot.FValues[storage]; snapshotValue < liveValue {
-				live.FValues[storage] = snapshotValue
-			}
-		case mapr.Max:
-			liveValue, ok := live.FValues[storage]
-			if !ok {
-				live.FValues[storage] = snapshot.FValues[storage]
-				continue
-			}
-			if snapshotValue := snapshot.FValues[storage]; snapshotValue > liveValue {
-				live.FValues[storage] = snapshotValue
-			}
 		case mapr.Last:
 			if _, ok := live.SValues[storage]; !ok {
 				if snapshotValue, ok := snapshot.SValues[storage]; ok {
@@ -478,6 +596,11 @@ func mergeCancelledSnapshot(query *mapr.Query, live, snapshot *mapr.AggregateSet
 					live.FValues[storage] = snapshot.FValues[storage]
 				}
 			}
+		case mapr.Avg, mapr.Min, mapr.Max, mapr.Variance, mapr.StdDev, mapr.First,
+			mapr.CountDistinct, mapr.Quantile, mapr.Histogram, mapr.Delta, mapr.Rate:
+			if err := live.MergeOperation(storage, sc.Operation, snapshot); err != nil {
+				dlog.Server.Error("Aggregate re-merge failed", "storage", storage, err)
+			}
 		default:
 			dlog.Server.Error("Aggregate re-merge encountered unsupported aggregation",
 				"operation", sc.Operation, "storage", storage)
@@ -488,6 +611,7 @@ func mergeCancelledSnapshot(query *mapr.Query, live, snapshot *mapr.AggregateSet
 func (a *Aggregate) swapGroupSets() map[string]*mapr.AggregateSet {
 	a.groupMu.Lock()
 	defer a.groupMu.Unlock()
+	a.pruneCountersLocked()
 
 	if len(a.groupSets) == 0 {
 		return nil
@@ -498,6 +622,19 @@ func (a *Aggregate) swapGroupSets() map[string]*mapr.AggregateSet {
 	return snapshot
 }
 
+// pruneCountersLocked ends the current interval and drops the counters which
+// weren't sampled during all of it, so that the counters of group keys of a
+// high cardinality don't pile up on a long running tail. The caller must hold
+// groupMu.
+func (a *Aggregate) pruneCountersLocked() {
+	for key, sample := range a.counters {
+		if sample.interval < a.counterInterval {
+			delete(a.counters, key)
+		}
+	}
+	a.counterInterval++
+}
+
 // AggregateProcessor implements the line processor interface for aggregation.
 type AggregateProcessor struct {
 	aggregate *Aggregate
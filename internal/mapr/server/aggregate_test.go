@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -12,6 +13,8 @@ import (
 	"github.com/mimecast/dtail/internal/config"
 	"github.com/mimecast/dtail/internal/io/dlog"
 	"github.com/mimecast/dtail/internal/mapr"
+	"github.com/mimecast/dtail/internal/mapr/logformat"
+	"github.com/mimecast/dtail/internal/protocol"
 	"github.com/mimecast/dtail/internal/source"
 )
 
@@ -712,3 +715,397 @@ func waitForAggregateStart(t *testing.T, aggregate *Aggregate) {
 		t.Fatal("aggregate did not finish Start initialization")
 	}
 }
+
+// TestAggregateBucketGroupBy verifies that a function call such as
+// bucket($time,1m) can be used in the select, group by and order by clauses to
+// build a per-minute time series. The spaces of the calls don't matter.
+func TestAggregateBucketGroupBy(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select bucket($time, 1m),count($line) ` +
+		`group by bucket($time,1m) rorder by bucket( $time , 1m )`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	for _, timestamp := range []string{"1002-071143", "1002-071159", "1002-071001",
+		"1002-071200", "1002-071143"} {
+		line := "INFO|" + timestamp + "|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+			"MAPREDUCE:STATS|currentConnections=0|lifetimeConnections=1"
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	group := mapr.NewGroupSet()
+	for groupKey, set := range agg.swapGroupSets() {
+		*group.GetSet(groupKey) = *set
+	}
+	result, numRows, err := group.Result(agg.query, -1, nil)
+	if err != nil {
+		t.Fatalf("Result failed: %v", err)
+	}
+	if numRows != 3 {
+		t.Fatalf("Expected 3 one-minute buckets, got %d:\n%s", numRows, result)
+	}
+	if len(agg.query.Set) != 1 {
+		t.Errorf("Expected a single set field for the bucket() call, got %v", agg.query.Set)
+	}
+
+	var buckets []string
+	for _, line := range strings.Split(strings.TrimSpace(result), "\n")[2:] {
+		buckets = append(buckets, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{"1002-071000 | 1", "1002-071100 | 3", "1002-071200 | 1"}
+	if strings.Join(buckets, ",") != strings.Join(want, ",") {
+		t.Errorf("Got buckets %q, want %q", buckets, want)
+	}
+}
+
+func TestAggregateSelectDistinct(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select distinct currentConnections,lifetimeConnections`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+	agg.distinctLimit = 3
+
+	process := func(connections ...string) []string {
+		for _, current := range connections {
+			line := "INFO|1002-071143|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+				"MAPREDUCE:STATS|currentConnections=" + current + "|lifetimeConnections=1"
+			if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+				t.Fatalf("processLine failed: %v", err)
+			}
+		}
+		var keys []string
+		for groupKey := range agg.swapGroupSets() {
+			keys = append(keys, strings.Split(groupKey, protocol.AggregateGroupKeyCombinator)[0])
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if got := process("0", "0", "1"); strings.Join(got, ",") != "0,1" {
+		t.Errorf("Expected the distinct combinations 0 and 1, got %v", got)
+	}
+	// Combinations sent already aren't sent again.
+	if got := process("0", "2", "1"); strings.Join(got, ",") != "2" {
+		t.Errorf("Expected only the new combination 2, got %v", got)
+	}
+	// Combinations beyond the limit are dropped.
+	if got := process("3", "4"); len(got) != 0 {
+		t.Errorf("Expected combinations beyond the limit to be dropped, got %v", got)
+	}
+}
+
+func TestAggregateHistogram(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select histogram(currentConnections,1,10),histogram_exp(currentConnections,2) ` +
+		`group by lifetimeConnections`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	for _, current := range []string{"0", "3", "5", "12", "x"} {
+		line := "INFO|1002-071143|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+			"MAPREDUCE:STATS|currentConnections=" + current + "|lifetimeConnections=1"
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	set := agg.swapGroupSets()["1"]
+	if set == nil {
+		t.Fatalf("Expected a group for lifetimeConnections=1")
+	}
+	explicit, exponential := agg.query.Select[0].FieldStorage, agg.query.Select[1].FieldStorage
+	for key, want := range map[string]float64{
+		explicit:              4,
+		explicit + "#le1":     1,
+		explicit + "#le10":    2,
+		explicit + "#le+Inf":  1,
+		exponential:           4,
+		exponential + "#le0":  1,
+		exponential + "#le4":  1,
+		exponential + "#le8":  1,
+		exponential + "#le16": 1,
+	} {
+		if got := set.FValues[key]; got != want {
+			t.Errorf("Got %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestAggregateRateDelta(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select delta(lifetimeConnections),rate(lifetimeConnections) ` +
+		`group by currentConnections`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	process := func(sourceID string, samples ...string) {
+		for _, sample := range samples {
+			timeAndValue := strings.Split(sample, " ")
+			line := "INFO|" + timeAndValue[0] + "|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+				"MAPREDUCE:STATS|currentConnections=0|lifetimeConnections=" + timeAndValue[1]
+			if err := agg.processLine(bytes.NewBufferString(line), sourceID); err != nil {
+				t.Fatalf("processLine failed: %v", err)
+			}
+		}
+	}
+
+	// The first sample of every source has nothing to compare with.
+	process("a.log", "1002-071100 100")
+	process("b.log", "1002-071100 5000")
+	if sets := agg.swapGroupSets(); len(sets) != 0 {
+		t.Fatalf("Expected no groups after the first samples, got %v", sets)
+	}
+
+	// The counters are compared to the samples of the last interval, and the
+	// counter of a.log was reset.
+	process("a.log", "1002-071110 160", "1002-071120 20")
+	process("b.log", "1002-071120 5100")
+	set := agg.swapGroupSets()["0"]
+	if set == nil {
+		t.Fatalf("Expected a group for currentConnections=0")
+	}
+	delta, rate := agg.query.Select[0].FieldStorage, agg.query.Select[1].FieldStorage
+	if got := set.FValues[delta]; got != 180 {
+		t.Errorf("Got delta %v, want 180", got)
+	}
+	if got := set.Rate(rate); got != 9 {
+		t.Errorf("Got rate %v, want 9", got)
+	}
+
+	// Lines without a time count for delta, but not for rate.
+	process("a.log", "1002-071130 30", "soon 50", "1002-071140 70")
+	set = agg.swapGroupSets()["0"]
+	if got := set.FValues[delta]; got != 50 {
+		t.Errorf("Got delta %v, want 50", got)
+	}
+	if got := set.Rate(rate); got != 2.5 {
+		t.Errorf("Got rate %v, want 2.5", got)
+	}
+}
+
+// TestAggregateCountersPruned verifies that the counters of the delta and rate
+// aggregations which weren't sampled during a whole interval are dropped, so
+// that they don't pile up for group keys of a high cardinality.
+func TestAggregateCountersPruned(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	agg, err := NewAggregate(`from STATS select delta(lifetimeConnections) group by currentConnections`,
+		config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+	process := func(group string) {
+		line := "INFO|1002-071100|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+			"MAPREDUCE:STATS|currentConnections=" + group + "|lifetimeConnections=1"
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	process("1")
+	process("2")
+	agg.swapGroupSets()
+	// Group 2 isn't sampled during the second interval.
+	process("1")
+	if len(agg.counters) != 2 {
+		t.Fatalf("Expected the counters of both groups within the interval, got %v", agg.counters)
+	}
+	agg.swapGroupSets()
+	if len(agg.counters) != 1 {
+		t.Errorf("Expected the counter of group 2 to be dropped, got %v", agg.counters)
+	}
+	agg.swapGroupSets()
+	if len(agg.counters) != 0 {
+		t.Errorf("Expected all counters to be dropped, got %v", agg.counters)
+	}
+}
+
+func TestAggregateMissingValues(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select avg(currentConnections),min(currentConnections) ` +
+		`where missing(skipped) group by lifetimeConnections`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	for _, fields := range []string{
+		"currentConnections=4|lifetimeConnections=1",
+		"lifetimeConnections=1",
+		"currentConnections=8|lifetimeConnections=1",
+		"currentConnections=0|lifetimeConnections=1|skipped=",
+	} {
+		line := "INFO|1002-071143|1|stats.go:56|8|15|7|0.21|471h0m21s|MAPREDUCE:STATS|" + fields
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	set := agg.swapGroupSets()["1"]
+	if set == nil {
+		t.Fatalf("Expected a group for lifetimeConnections=1")
+	}
+	avg, minimum := agg.query.Select[0].FieldStorage, agg.query.Select[1].FieldStorage
+	if got := set.FValues[avg] / set.AvgCount(avg); got != 6 {
+		t.Errorf("Got average %v, want 6", got)
+	}
+	if got := set.FValues[minimum]; got != 4 {
+		t.Errorf("Got minimum %v, want 4", got)
+	}
+}
+
+// TestAggregateMissingCallFields verifies that the aggregations of a function
+// call skip the lines without the fields of the call.
+func TestAggregateMissingCallFields(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select sum(bytes(size)),count(bucket(started,1m)) ` +
+		`group by lifetimeConnections`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	for _, fields := range []string{
+		"size=4KB|started=20211002-071143|lifetimeConnections=1",
+		"lifetimeConnections=1",
+		"size=2KB|lifetimeConnections=1",
+	} {
+		line := "INFO|1002-071143|1|stats.go:56|8|15|7|0.21|471h0m21s|MAPREDUCE:STATS|" + fields
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	set := agg.swapGroupSets()["1"]
+	if set == nil {
+		t.Fatalf("Expected a group for lifetimeConnections=1")
+	}
+	sum, count := agg.query.Select[0].FieldStorage, agg.query.Select[1].FieldStorage
+	if got := set.FValues[sum]; got != 6000 {
+		t.Errorf("Got sum %v, want 6000", got)
+	}
+	if got := set.FValues[count]; got != 1 {
+		t.Errorf("Got count %v, want 1", got)
+	}
+}
+
+func TestAggregateUnits(t *testing.T) {
+	ensureTestServerConfig(t)
+
+	queryStr := `from STATS select avg(took),max(size),last(took) where size > 1KB ` +
+		`group by lifetimeConnections units ms`
+	agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+	if err != nil {
+		t.Fatalf("NewAggregate failed: %v", err)
+	}
+
+	for _, fields := range []string{"took=1.5s|size=2KB", "took=500ms|size=1MiB", "took=1s|size=10b"} {
+		line := "INFO|1002-071143|1|stats.go:56|8|15|7|0.21|471h0m21s|MAPREDUCE:STATS|" +
+			"lifetimeConnections=1|" + fields
+		if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+			t.Fatalf("processLine failed: %v", err)
+		}
+	}
+
+	set := agg.swapGroupSets()["1"]
+	if set == nil {
+		t.Fatalf("Expected a group for lifetimeConnections=1")
+	}
+	avg := agg.query.Select[0].FieldStorage
+	if got := set.FValues[avg] / set.AvgCount(avg); got != 1000 {
+		t.Errorf("Got average %vms, want 1000ms", got)
+	}
+	if got := set.FValues[agg.query.Select[1].FieldStorage]; got != 1<<20 {
+		t.Errorf("Got maximum size %v, want %v", got, 1<<20)
+	}
+	// The values of the non-numeric aggregations are kept as they are.
+	if got := set.SValues[agg.query.Select[2].FieldStorage]; got != "500ms" {
+		t.Errorf("Got last value %q, want 500ms", got)
+	}
+}
+
+// TestAggregateFirstAcrossLogFormats verifies that first() orders the lines by
+// their time for every log format, regardless of whether the format keeps the
+// timestamp as logged or normalises it, and of whether it sorts as text.
+func TestAggregateFirstAcrossLogFormats(t *testing.T) {
+	ensureTestServerConfig(t)
+	if err := logformat.RegisterConfigFormats(map[string]config.LogFormat{
+		"firsttestformat": {
+			Regex:      `^\[(?P<time>[^\]]+)\] (?P<status>\S+)$`,
+			TimeField:  "time",
+			TimeLayout: "02/Jan/2006:15:04:05 -0700",
+		},
+	}); err != nil {
+		t.Fatalf("Unable to register log format: %v", err)
+	}
+
+	// The late line comes first in every log, and its timestamp sorts before
+	// the one of the early line as text, except for json and default.
+	tests := []struct {
+		logFormat   string
+		late, early string
+	}{
+		{logFormat: "default",
+			late: "INFO|1001-010000|1|stats.go:56|8|15|7|0.21|471h0m21s|MAPREDUCE:STATS|status=late",
+			early: "INFO|0930-230000|1|stats.go:56|8|15|7|0.21|471h0m21s|" +
+				"MAPREDUCE:STATS|status=early"},
+		{logFormat: "json",
+			late:  `{"time":"2021-10-01T01:00:00Z","status":"late"}`,
+			early: `{"time":"2021-09-30T23:00:00Z","status":"early"}`},
+		{logFormat: "logfmt",
+			late:  `time="Oct  1 01:00:00" status=late`,
+			early: `time="Sep 30 23:00:00" status=early`},
+		{logFormat: "firsttestformat",
+			late:  `[01/Oct/2021:01:00:00 +0000] late`,
+			early: `[30/Sep/2021:23:00:00 +0000] early`},
+		{logFormat: "combined",
+			late:  `127.0.0.1 - - [01/Oct/2021:01:00:00 +0000] "GET /late HTTP/1.0" 200 1`,
+			early: `127.0.0.1 - - [30/Sep/2021:23:00:00 +0000] "GET /early HTTP/1.0" 200 1`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.logFormat, func(t *testing.T) {
+			field := "status"
+			if tc.logFormat == "combined" {
+				field = "path"
+			}
+			queryStr := "select first(" + field + ") group by $hostname logformat " + tc.logFormat
+			if tc.logFormat == "default" {
+				queryStr = "from STATS " + queryStr
+			}
+			agg, err := NewAggregate(queryStr, config.Server.MapreduceLogFormat)
+			if err != nil {
+				t.Fatalf("NewAggregate failed: %v", err)
+			}
+			for _, line := range []string{tc.late, tc.early} {
+				if err := agg.processLine(bytes.NewBufferString(line), "test"); err != nil {
+					t.Fatalf("processLine failed: %v", err)
+				}
+			}
+			sets := agg.swapGroupSets()
+			if len(sets) != 1 {
+				t.Fatalf("Expected a single group, got %v", sets)
+			}
+			for _, set := range sets {
+				if got := set.SValues[agg.query.Select[0].FieldStorage]; !strings.HasSuffix(got, "early") {
+					t.Errorf("Got first value %q, want the one of the early line", got)
+				}
+			}
+		})
+	}
+}
@@ -1,16 +1,38 @@
 package mapr
 
-// SetClause interprets the set clause of the mapreduce query.
+import "math"
+
+// SetClause interprets the set clause of the mapreduce query. A function call
+// or an arithmetic expression without a result, e.g. of an absent field, leaves
+// the field absent, so that the aggregations skip it. An expression which is a
+// field name too, e.g. user-agent, takes the value of that field instead if the
+// line has it.
 func (q *Query) SetClause(fields map[string]string) error {
 	for _, sc := range q.Set {
-		value, ok := fields[sc.rString]
-		if !ok {
-			value = sc.rString
-		}
 		switch sc.rType {
-		case FunctionStack:
-			fields[sc.lString] = sc.functionStack.Call(value)
+		case FunctionCall:
+			value, ok := sc.call.Lookup(fields)
+			if !ok {
+				delete(fields, sc.lString)
+				continue
+			}
+			fields[sc.lString] = value
+		case Arithmetic:
+			if value, ok := fields[sc.rString]; ok && sc.exprIsField {
+				fields[sc.lString] = value
+				continue
+			}
+			f := sc.expr.eval(fields)
+			if math.IsNaN(f) {
+				delete(fields, sc.lString)
+				continue
+			}
+			fields[sc.lString] = formatArithValue(f)
 		default:
+			value, ok := fields[sc.rString]
+			if !ok {
+				value = sc.rString
+			}
 			fields[sc.lString] = value
 		}
 	}
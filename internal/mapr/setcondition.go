@@ -20,15 +20,64 @@ type setCondition struct {
 	// For now only text functions are supported.
 	// Maybe in the future we can have typed functions too
 	// so that a float input/output is possible.
-	functionStack funcs.FunctionStack
+	call *funcs.Call
+	// The arithmetic expression, e.g. of "set $kb = bytes / 1024".
+	expr *arithExpr
+	// Whether the arithmetic expression is a field name too, e.g. user-agent,
+	// whose field takes precedence over the expression.
+	exprIsField bool
 }
 
 func (sc *setCondition) String() string {
-	return fmt.Sprintf("setCondition(lString:%s,rString:%s,rType:%s,functionStack:%v)",
-		sc.lString, sc.rString, sc.rType.String(), sc.functionStack)
+	return fmt.Sprintf("setCondition(lString:%s,rString:%s,rType:%s,call:%v)",
+		sc.lString, sc.rString, sc.rType.String(), sc.call)
+}
+
+// fields returns the field names the condition reads its value from.
+func (sc *setCondition) fields() []string {
+	switch sc.rType {
+	case Field:
+		return []string{sc.rString}
+	case FunctionCall:
+		return sc.call.Fields()
+	case Arithmetic:
+		var fields []string
+		if sc.exprIsField {
+			fields = append(fields, sc.rString)
+		}
+		for _, operand := range sc.expr.operands() {
+			switch o := operand.(type) {
+			case fieldOperand:
+				fields = append(fields, string(o))
+			case callOperand:
+				fields = append(fields, o.call.Fields()...)
+			}
+		}
+		return fields
+	default:
+		return nil
+	}
+}
+
+// newFunctionSetCondition returns a condition which stores the result of a
+// function call, e.g. bucket($time,1m), under its own expression as the
+// field name. This allows to use function calls directly in the 'select' and
+// 'group by' clauses.
+func newFunctionSetCondition(expression string) (setCondition, error) {
+	call, err := funcs.NewCall(expression)
+	if err != nil {
+		return setCondition{}, errors.New(invalidQuery + err.Error())
+	}
+	return setCondition{
+		lString: expression,
+		rType:   FunctionCall,
+		rString: expression,
+		call:    call,
+	}, nil
 }
 
 func makeSetConditions(tokens []token) (set []setCondition, err error) {
+	tokens = joinArithTokens(tokens)
 
 	parse := func(tokens []token) (setCondition, []token, error) {
 		var sc setCondition
@@ -44,15 +93,28 @@ func makeSetConditions(tokens []token) (set []setCondition, err error) {
 			return sc, tokens[3:], nil
 		}
 
+		// Seems like an arithmetic expression? E.g.: "set $kb = bytes / 1024"
+		if tokens[2].isBareword {
+			expr, isField, err := parseSetArithExpr(sc.rString)
+			if err != nil {
+				return sc, nil, err
+			}
+			if expr != nil {
+				sc.expr = expr
+				sc.exprIsField = isField
+				sc.rType = Arithmetic
+				return sc, tokens[3:], nil
+			}
+		}
+
 		// Seems like a function call?
 		if strings.HasSuffix(sc.rString, ")") {
-			functionStack, functionArg, err := funcs.NewFunctionStack(tokens[2].str)
+			call, err := funcs.NewCall(tokens[2].str)
 			if err != nil {
 				return sc, nil, err
 			}
-			sc.functionStack = functionStack
-			sc.rType = FunctionStack
-			sc.rString = functionArg
+			sc.call = call
+			sc.rType = FunctionCall
 			return sc, tokens[3:], nil
 		}
 
@@ -79,9 +141,50 @@ func makeSetConditions(tokens []token) (set []setCondition, err error) {
 	return
 }
 
+// parseSetArithExpr parses the value of a 'set' clause as an arithmetic
+// expression, it returns nil if the value is none. Operators with spaces around
+// them, e.g. bytes / 1024, always make one. Without spaces, a value of numbers
+// only, e.g. 2021-10-02, is a literal, and a value which doesn't parse is a
+// field name. A value with a field operand, e.g. bytes/1024 or user-agent, is
+// both an expression and a field name: isField is true then, and the field is
+// used for the log lines which have it, see Query.SetClause.
+func parseSetArithExpr(value string) (expr *arithExpr, isField bool, err error) {
+	indexes := arithOperatorIndexes(value)
+	if len(indexes) == 0 {
+		return nil, false, nil
+	}
+	for _, i := range indexes {
+		if value[i-1] == ' ' || (i+1 < len(value) && value[i+1] == ' ') {
+			expr, err := parseArithExpr(value, makeLineOperand)
+			return expr, false, err
+		}
+	}
+
+	expr, err = parseArithExpr(value, makeLineOperand)
+	if err != nil {
+		return nil, false, nil
+	}
+	var numbers int
+	operands := expr.operands()
+	for _, operand := range operands {
+		switch o := operand.(type) {
+		case arithNumber:
+			numbers++
+		case fieldOperand:
+			if !strings.HasPrefix(string(o), "$") {
+				isField = true
+			}
+		}
+	}
+	if numbers == len(operands) {
+		return nil, false, nil
+	}
+	return expr, isField, nil
+}
+
 func initSetConditions(sc *setCondition, tokens []token) error {
 	if len(tokens) < 3 {
-		return errors.New(invalidQuery + "Not enough arguments in 'set' clause")
+		return newEndError("Not enough arguments in 'set' clause")
 	}
 
 	sc.lString = tokens[0].str
@@ -90,13 +193,13 @@ func initSetConditions(sc *setCondition, tokens []token) error {
 
 	switch {
 	case tokens[1].str != "=":
-		return errors.New(invalidQuery + "Unknown operation in 'set' clause: " + tokens[1].str)
+		return newTokenError(tokens[1], "Unknown operation in 'set' clause: "+tokens[1].str)
 	case !tokens[0].isBareword:
-		return errors.New(invalidQuery + "Expected bareword at 'set' clause's lValue: " +
+		return newTokenError(tokens[0], "Expected bareword at 'set' clause's lValue: "+
 			tokens[0].str)
 	case !strings.HasPrefix(sc.lString, "$"):
-		return errors.New(invalidQuery + "Expected field variable name (starting with $) " +
-			"at 'set' clause's lValue: " + tokens[0].str)
+		return newTokenError(tokens[0], "Expected field variable name (starting with $) "+
+			"at 'set' clause's lValue: "+tokens[0].str)
 	}
 
 	return nil
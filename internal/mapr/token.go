@@ -2,9 +2,11 @@ package mapr
 
 import (
 	"strings"
+
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 )
 
-var keywords = [...]string{"select", "from", "where", "set", "group", "rorder",
+var keywords = [...]string{"explain", "select", "from", "where", "set", "group", "having", "rorder",
 	"order", "interval", "limit", "outfile", "logformat"}
 
 // Represents a parsed token, used to parse the mapr query.
@@ -12,39 +14,148 @@ type token struct {
 	str            string
 	isBareword     bool
 	quotesStripped bool
+	// The byte offset of the token in the query, -1 if it is unknown.
+	pos int
+	// Whether the token directly follows a comma, e.g. b of "a, b".
+	afterComma bool
 }
 
-// tokenize parses a query string into tokens.
-func tokenize(queryStr string) []token {
+// tokenize splits a query string into tokens. Tokens are separated by spaces
+// and commas, unless they are quoted: a literal enclosed in double quotes, or
+// in single quotes at the start of a token, is a single token, e.g. "a, b".
+// See funcs.Unquote for the escape sequences of quoted literals.
+//
+// A parenthesis which directly follows a word, e.g. count(foo), opens a
+// function call: everything up to the matching closing parenthesis, including
+// commas, spaces and quoted literals, stays part of that token, e.g.
+// bucket($time, 5m). Any other parenthesis, e.g. in "where (a == 1 or b == 2)",
+// is a grouping parenthesis and is emitted as a token of its own. So is the
+// parenthesis following the "in" operator or a boolean operator, e.g.
+// status in(500,502) or not(status == 500), which is never a function call. The spaces of a call outside its quoted
+// literals are dropped, so that e.g. bucket($time, 1m) and bucket($time,1m)
+// are the same field.
+func tokenize(queryStr string) ([]token, error) {
 	var tokens []token
-	for i, part := range strings.Split(queryStr, "\"") {
-		// Even i, means that it is not a quoted string
-		if i%2 == 0 {
-			commasStripped := strings.Replace(part, ",", " ", -1)
-			for _, tokenStr := range strings.Fields(commasStripped) {
-				token := token{
-					str:        tokenStr,
-					isBareword: true,
+	// Start of the current bareword token, -1 if there is none.
+	start := -1
+	// Number of open function call parentheses in the current token.
+	callDepth := 0
+	// Whether there was a comma since the last token.
+	comma := false
+	add := func(t token) {
+		t.afterComma = comma
+		comma = false
+		tokens = append(tokens, t)
+	}
+	flush := func(end int) {
+		if start >= 0 {
+			str := queryStr[start:end]
+			if open := strings.IndexByte(str, '('); open > 0 {
+				str = str[:open] + compactCallArgs(str[open:])
+			}
+			add(token{str: str, isBareword: true, pos: start})
+			start = -1
+		}
+	}
+
+	for i := 0; i < len(queryStr); i++ {
+		c := queryStr[i]
+		if callDepth > 0 {
+			switch {
+			case funcs.IsQuote(c):
+				end := funcs.QuotedEnd(queryStr, i)
+				if end < 0 {
+					return nil, &QueryError{Offset: i, msg: invalidQuery + "Unterminated quoted string"}
 				}
-				tokens = append(tokens, token)
+				i = end
+			case c == '(':
+				callDepth++
+			case c == ')':
+				callDepth--
 			}
 			continue
 		}
-		// Add whole quoted string as a token
-		token := token{
-			str:        part,
-			isBareword: false,
+
+		switch {
+		case c == '"' || (c == '\'' && start < 0):
+			flush(i)
+			end := funcs.QuotedEnd(queryStr, i)
+			if end < 0 {
+				return nil, &QueryError{Offset: i, msg: invalidQuery + "Unterminated quoted string"}
+			}
+			str, _ := funcs.Unquote(queryStr[i : end+1])
+			add(token{str: str, isBareword: false, pos: i})
+			i = end
+		case c == ',':
+			flush(i)
+			comma = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush(i)
+		case c == '(' && start < 0:
+			add(token{str: "(", isBareword: true, pos: i})
+		case c == '(' && isGroupingWord(queryStr[start:i]):
+			flush(i)
+			add(token{str: "(", isBareword: true, pos: i})
+		case c == '(':
+			callDepth = 1
+		case c == ')':
+			// Closes a grouping parenthesis, or is an unbalanced one which is
+			// left for the clause parsers to report.
+			flush(i)
+			add(token{str: ")", isBareword: true, pos: i})
+		case start < 0:
+			start = i
 		}
-		tokens = append(tokens, token)
 	}
-	return tokens
+	flush(len(queryStr))
+	return tokens, nil
+}
+
+// isGroupingWord returns true if a parenthesis directly following the word
+// isn't a function call, e.g. of "in(" or "or(".
+func isGroupingWord(word string) bool {
+	switch strings.ToLower(word) {
+	case "in", "not", "and", "or":
+		return true
+	}
+	return false
+}
+
+// compactCallArgs drops the spaces of a function call's argument list, e.g.
+// ($time, 1m), which aren't within a quoted literal.
+func compactCallArgs(args string) string {
+	if !strings.ContainsAny(args, " \t\n\r") {
+		return args
+	}
+	var sb strings.Builder
+	sb.Grow(len(args))
+	for i := 0; i < len(args); i++ {
+		switch c := args[i]; {
+		case funcs.IsQuote(c):
+			end := funcs.QuotedEnd(args, i)
+			if end < 0 {
+				end = len(args) - 1
+			}
+			sb.WriteString(args[i : end+1])
+			i = end
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// newBarewordToken returns a bare (unquoted) token.
+func newBarewordToken(str string) token {
+	return token{str: str, isBareword: true, pos: -1}
 }
 
 func tokensConsume(tokens []token) ([]token, []token) {
 	//dlog.Common.Trace("=====================")
 	var consumed []token
 	for i, t := range tokens {
-		if t.isKeyword() {
+		if t.isKeyword() || (t.isOperator("units") && isUnitsClause(tokens, i)) {
 			//dlog.Common.Trace("keyword", t)
 			return tokens[i:], consumed
 		}
@@ -60,6 +171,7 @@ func tokensConsume(tokens []token) ([]token, []token) {
 				str:            stripped,
 				isBareword:     t.isBareword,
 				quotesStripped: true,
+				pos:            t.pos,
 			}
 			consumed = append(consumed, t)
 			continue
@@ -71,15 +183,6 @@ func tokensConsume(tokens []token) ([]token, []token) {
 	return nil, consumed
 }
 
-func tokensConsumeStr(tokens []token) ([]token, []string) {
-	var strings []string
-	tokens, found := tokensConsume(tokens)
-	for _, token := range found {
-		strings = append(strings, token.str)
-	}
-	return tokens, strings
-}
-
 func tokensConsumeOptional(tokens []token, optional string) []token {
 	if len(tokens) < 1 {
 		return tokens
@@ -91,6 +194,49 @@ func tokensConsumeOptional(tokens []token, optional string) []token {
 	return tokens
 }
 
+// isUnitsClause returns true if the 'units' token at tokens[i] starts the
+// 'units' clause. 'units' is no reserved keyword, so that it can still be used
+// as a field name: it only starts the clause if it follows the arguments of the
+// clause before, i.e. it isn't the first argument and follows neither a comma
+// nor an operator, and if no operator follows it, e.g. "group by path units ms"
+// but neither "group by path, units" nor "where a == 1 units == 2".
+func isUnitsClause(tokens []token, i int) bool {
+	if i == 0 || tokens[i].afterComma || expectsOperand(tokens[i-1]) {
+		return false
+	}
+	return i+1 == len(tokens) || !isConditionOperator(tokens[i+1])
+}
+
+// isConditionOperator returns true if the token is the operator of a 'where' or
+// a 'set' condition, e.g. == or not of "not in".
+func isConditionOperator(t token) bool {
+	if !t.isBareword || t.quotesStripped {
+		return false
+	}
+	str := strings.ToLower(t.str)
+	if _, ok := whereOperations[str]; ok {
+		return true
+	}
+	return str == "=" || str == "in" || str == "not"
+}
+
+// expectsOperand returns true if the token is an operator or a word which is
+// followed by an operand, e.g. == or as.
+func expectsOperand(t token) bool {
+	if !t.isBareword || t.quotesStripped {
+		return false
+	}
+	str := strings.ToLower(t.str)
+	if _, ok := whereOperations[str]; ok {
+		return true
+	}
+	switch str {
+	case "=", "(", "in", "not", "and", "or", "as", "per", "by", "append":
+		return true
+	}
+	return false
+}
+
 func (t token) isKeyword() bool {
 	if !t.isBareword {
 		return false
@@ -103,6 +249,12 @@ func (t token) isKeyword() bool {
 	return false
 }
 
+// isOperator returns true if the token is the given bare (unquoted and not
+// backtick escaped) operator word or symbol, e.g. "and" or "(".
+func (t token) isOperator(operator string) bool {
+	return t.isBareword && !t.quotesStripped && strings.EqualFold(t.str, operator)
+}
+
 func (t token) String() string {
 	return t.str
 }
@@ -55,7 +55,10 @@ func TestTokensConsumeBacktickStripping(t *testing.T) {
 				}
 			}()
 
-			tokens := tokenize(tc.input)
+			tokens, err := tokenize(tc.input)
+			if err != nil {
+				t.Fatalf("Unable to tokenize %q: %v", tc.input, err)
+			}
 			_, got := tokensConsume(tokens)
 
 			if len(got) != len(tc.want) {
@@ -75,3 +78,88 @@ func TestTokensConsumeBacktickStripping(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizeGroupingParentheses(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "count(foo)", want: []string{"count(foo)"}},
+		{input: "(a == 1)", want: []string{"(", "a", "==", "1", ")"}},
+		{input: "not (a == 1 or (b eq \"x)\"))",
+			want: []string{"not", "(", "a", "==", "1", "or", "(", "b", "eq", "x)", ")", ")"}},
+		{input: "(count(foo) > 10)", want: []string{"(", "count(foo)", ">", "10", ")"}},
+		{input: "a,(b)", want: []string{"a", "(", "b", ")"}},
+		{input: `md5sum("a) b", c) == 1`, want: []string{`md5sum("a) b",c)`, "==", "1"}},
+		{input: `a in (1, "x,y")`, want: []string{"a", "in", "(", "1", "x,y", ")"}},
+		{input: "a not in(1,2)", want: []string{"a", "not", "in", "(", "1", "2", ")"}},
+		{input: "not(a == 1) or(b == 2)", want: []string{"not", "(", "a", "==", "1", ")", "or", "(", "b", "==", "2", ")"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			tokens, err := tokenize(tc.input)
+			if err != nil {
+				t.Fatalf("Unable to tokenize %q: %v", tc.input, err)
+			}
+			if len(tokens) != len(tc.want) {
+				t.Fatalf("Got tokens %v, want %v", tokens, tc.want)
+			}
+			for i, want := range tc.want {
+				if tokens[i].str != want {
+					t.Errorf("Token %d: got %q, want %q", i, tokens[i].str, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenizeQuotedStrings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input string
+		want  []string
+		pos   []int
+	}{
+		{input: `a == "x y"`, want: []string{"a", "==", "x y"}, pos: []int{0, 2, 5}},
+		{input: `a == 'x y'`, want: []string{"a", "==", "x y"}, pos: []int{0, 2, 5}},
+		{input: `a == "say \"hi\""`, want: []string{"a", "==", `say "hi"`}, pos: []int{0, 2, 5}},
+		{input: `a == 'it\'s'`, want: []string{"a", "==", "it's"}, pos: []int{0, 2, 5}},
+		{input: `a == "tab\there\\"`, want: []string{"a", "==", "tab\there\\"}, pos: []int{0, 2, 5}},
+		{input: `a == "x'y"`, want: []string{"a", "==", "x'y"}, pos: []int{0, 2, 5}},
+		{input: `don't stop`, want: []string{"don't", "stop"}, pos: []int{0, 6}},
+		{input: `f(a, 'x)') == 1`, want: []string{"f(a,'x)')", "==", "1"}, pos: []int{0, 11, 14}},
+	}
+
+	for _, tc := range tests {
+		tokens, err := tokenize(tc.input)
+		if err != nil {
+			t.Errorf("Unable to tokenize %q: %v", tc.input, err)
+			continue
+		}
+		if len(tokens) != len(tc.want) {
+			t.Errorf("Input %q: got tokens %v, want %v", tc.input, tokens, tc.want)
+			continue
+		}
+		for i, want := range tc.want {
+			if tokens[i].str != want || tokens[i].pos != tc.pos[i] {
+				t.Errorf("Input %q token %d: got %q at %d, want %q at %d",
+					tc.input, i, tokens[i].str, tokens[i].pos, want, tc.pos[i])
+			}
+		}
+	}
+
+	for input, offset := range map[string]int{
+		`a == "foo`:     5,
+		`a == 'foo`:     5,
+		`a == "foo\"`:   5,
+		`f(a, "x) == 1`: 5,
+	} {
+		_, err := tokenize(input)
+		qe, ok := err.(*QueryError)
+		if !ok || qe.Offset != offset {
+			t.Errorf("Input %q: got error %v, want an error at offset %d", input, err, offset)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mapr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryUnits(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]string{"took": "1m3s", "size": "4.2MB", "plain": "2000"}
+	tests := []struct {
+		query string
+		units time.Duration
+		want  bool
+	}{
+		// Literals with a unit work without the 'units' keyword, and so do the
+		// field values compared with them, in seconds.
+		{query: "select count(plain) where plain > 1KB", want: true},
+		{query: "select count(plain) where plain < 1s", want: false},
+		{query: "select count(took) where took > 1m", want: true},
+		{query: "select count(took) where took > 100ms and 1h > took", want: true},
+		{query: "select count(took) where took < 63", want: false},
+		{query: "select count(size) where size > 4000000", want: false},
+		{query: "select count(took) where took > 1m units", units: time.Second, want: true},
+		{query: "select count(took) where took == 63000 units ms", units: time.Millisecond, want: true},
+		{query: "select count(took) where took < 1m5s units ms", units: time.Millisecond, want: true},
+		{query: "select count(size) where size >= 4MB and size < 5MB units", units: time.Second,
+			want: true},
+		{query: "select count(size) where 1MiB > size units", units: time.Second, want: false},
+	}
+	for _, tt := range tests {
+		q, err := NewQuery(tt.query)
+		if err != nil {
+			t.Fatalf("Unable to parse query %q: %v", tt.query, err)
+		}
+		if q.Units != tt.units {
+			t.Errorf("Query %q: got units %v, want %v", tt.query, q.Units, tt.units)
+		}
+		if got := q.WhereClause(fields); got != tt.want {
+			t.Errorf("Query %q: WhereClause() = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	q, err := NewQuery("select avg(took) units ms")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	for value, want := range map[string]string{"1.5s": "1500", "42": "42", "4KB": "4000", "n/a": "n/a"} {
+		if got := q.UnitValue(value); got != want {
+			t.Errorf("UnitValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+
+	for _, queryStr := range []string{"select avg(took) units 5ms", "select avg(took) units parsec",
+		"select avg(took) units ms s"} {
+		if _, err := NewQuery(queryStr); err == nil {
+			t.Errorf("Expected an error parsing query %q", queryStr)
+		}
+	}
+}
+
+// TestQueryUnitsField verifies that 'units' only starts the 'units' clause after
+// the arguments of another clause, so that it can be used as a field name too.
+func TestQueryUnitsField(t *testing.T) {
+	t.Parallel()
+
+	q, err := NewQuery("select path,units,max(units) as maxunits where units > 1 and " +
+		"path ne units group by path, units order by units limit 5 offset 2 units ms")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if q.Units != time.Millisecond {
+		t.Errorf("Got units %v, want %v", q.Units, time.Millisecond)
+	}
+	if q.GroupKey != "path,units" || q.Select[1].Field != "units" || q.Select[2].Field != "units" {
+		t.Errorf("Expected units as a field, got %v", q)
+	}
+	if len(q.OrderBy) != 1 || q.OrderBy[0].Field != "units" || q.Limit != 5 || q.Offset != 2 {
+		t.Errorf("Unexpected order by %v limit %d offset %d", q.OrderBy, q.Limit, q.Offset)
+	}
+	if !q.Where.eval(map[string]string{"path": "/", "units": "2"}) ||
+		q.Where.eval(map[string]string{"path": "/", "units": "0"}) {
+		t.Errorf("Expected a where condition on units, got %v", q.Where)
+	}
+
+	q, err = NewQuery("select max(took) where a == 1 units == 2")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	if q.Units != 0 || !q.Where.eval(map[string]string{"a": "1", "units": "2"}) ||
+		q.Where.eval(map[string]string{"a": "1", "units": "3"}) {
+		t.Errorf("Expected a where condition on units, got %v", q.Where)
+	}
+
+	for queryStr, want := range map[string]time.Duration{
+		"select units from stats units":                           time.Second,
+		"select max(units) group by units units s":                time.Second,
+		"select max(units) group by path units ms":                time.Millisecond,
+		"select max(units) where units > 1 units":                 time.Second,
+		"select units group by units":                             0,
+		"select max(took) as units group by path":                 0,
+		"select max(took) where a == 1 units in (2, 3)":           0,
+		"select max(took) where a == 1 units not in (2) units ms": time.Millisecond,
+	} {
+		q, err := NewQuery(queryStr)
+		if err != nil {
+			t.Fatalf("Unable to parse query %q: %v", queryStr, err)
+		}
+		if q.Units != want {
+			t.Errorf("Query %q: got units %v, want %v", queryStr, q.Units, want)
+		}
+	}
+}
@@ -2,34 +2,42 @@ package mapr
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/mimecast/dtail/internal/io/dlog"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
 )
 
 // WhereClause interprets the where clause of the mapreduce query.
 func (q *Query) WhereClause(fields map[string]string) bool {
-	for _, wc := range q.Where {
-		if wc.Operation > FloatOperation {
-			if !whereClauseFloatValues(fields, wc) {
-				return false
-			}
-			continue
-		}
-		if !whereClauseStringValues(fields, wc) {
-			return false
-		}
+	if q.Where == nil {
+		return true
 	}
-	return true
+	return q.Where.eval(fields)
+}
+
+// eval evaluates a single where condition.
+func (wc *whereCondition) eval(fields map[string]string) bool {
+	switch {
+	case wc.Operation == FieldExists || wc.Operation == FieldMissing:
+		_, ok := fields[wc.lString]
+		return ok == (wc.Operation == FieldExists)
+	case wc.Operation > FloatOperation:
+		return whereClauseFloatValues(fields, *wc)
+	case wc.rType == List:
+		return whereClauseListValues(fields, *wc)
+	}
+	return whereClauseStringValues(fields, *wc)
 }
 
 func whereClauseFloatValues(fields map[string]string, wc whereCondition) bool {
 	var lValue, rValue float64
 	var ok bool
 
-	if lValue, ok = whereClauseFloatValue(fields, wc.lString, wc.lFloat, wc.lType); !ok {
+	if lValue, ok = whereClauseFloatValue(fields, wc.lString, wc.lFloat, wc.lType, wc.units); !ok {
 		return false
 	}
-	if rValue, ok = whereClauseFloatValue(fields, wc.rString, wc.rFloat, wc.rType); !ok {
+	if rValue, ok = whereClauseFloatValue(fields, wc.rString, wc.rFloat, wc.rType, wc.units); !ok {
 		return false
 	}
 	if ok = wc.floatClause(lValue, rValue); !ok {
@@ -40,7 +48,7 @@ func whereClauseFloatValues(fields map[string]string, wc whereCondition) bool {
 }
 
 func whereClauseFloatValue(fields map[string]string, str string, float float64,
-	t fieldType) (float64, bool) {
+	t fieldType, units time.Duration) (float64, bool) {
 
 	switch t {
 	case Float:
@@ -50,6 +58,9 @@ func whereClauseFloatValue(fields map[string]string, str string, float float64,
 		if !ok {
 			return 0, false
 		}
+		if units != 0 {
+			return funcs.ParseUnitValue(value, units)
+		}
 		f, err := strconv.ParseFloat(value, 64)
 		if err != nil {
 			return 0, false
@@ -78,6 +89,14 @@ func whereClauseStringValues(fields map[string]string, wc whereCondition) bool {
 	return true
 }
 
+func whereClauseListValues(fields map[string]string, wc whereCondition) bool {
+	lValue, ok := whereClauseStringValue(fields, wc.lString, wc.lType)
+	if !ok {
+		return false
+	}
+	return wc.listClause(lValue)
+}
+
 func whereClauseStringValue(fields map[string]string, str string,
 	t fieldType) (string, bool) {
 
@@ -1,12 +1,14 @@
 package mapr
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mimecast/dtail/internal/io/dlog"
+	"github.com/mimecast/dtail/internal/mapr/funcs"
+	"github.com/mimecast/dtail/internal/regex"
 )
 
 // QueryOperation determines the mapreduce operation.
@@ -23,6 +25,12 @@ const (
 	StringNotHasPrefix  QueryOperation = iota
 	StringHasSuffix     QueryOperation = iota
 	StringNotHasSuffix  QueryOperation = iota
+	StringMatches       QueryOperation = iota
+	StringNotMatches    QueryOperation = iota
+	StringIn            QueryOperation = iota
+	StringNotIn         QueryOperation = iota
+	FieldExists         QueryOperation = iota
+	FieldMissing        QueryOperation = iota
 	FloatOperation      QueryOperation = iota
 	FloatEq             QueryOperation = iota
 	FloatNe             QueryOperation = iota
@@ -43,6 +51,16 @@ type whereCondition struct {
 	rType   fieldType
 	rString string
 	rFloat  float64
+	// The compiled rValue of the =~ and !~ operations.
+	rRegex regex.Regex
+	// The list of the "in" and "not in" operations as hash sets. Numeric list
+	// items are also added to rFloatSet, so that e.g. 500.0 is in (500).
+	rSet      map[string]struct{}
+	rFloatSet map[float64]struct{}
+	// The unit durations of field values are normalised to with the 'units'
+	// keyword, see Query.Units. Without it, seconds if a literal of the
+	// condition has a unit, e.g. 100ms, or zero otherwise.
+	units time.Duration
 }
 
 func (wc *whereCondition) String() string {
@@ -52,73 +70,153 @@ func (wc *whereCondition) String() string {
 		wc.rFloat, wc.rType.String())
 }
 
-func makeWhereConditions(tokens []token) (where []whereCondition, err error) {
+// fields returns the field names the condition reads its arguments from.
+func (wc *whereCondition) fields() []string {
+	var fields []string
+	if wc.lType == Field {
+		fields = append(fields, wc.lString)
+	}
+	if wc.rType == Field {
+		fields = append(fields, wc.rString)
+	}
+	return fields
+}
 
-	// Helper to parse a where condition.
-	parse := func(tokens []token) (whereCondition, []token, error) {
-		var wc whereCondition
-		if len(tokens) < 3 {
-			return wc, nil, errors.New(invalidQuery + "Not enough arguments in 'where' clause")
-		}
+// The operations of the 'where' conditions by their operators.
+var whereOperations = map[string]QueryOperation{
+	"==":         FloatEq,
+	"!=":         FloatNe,
+	"<":          FloatLt,
+	"<=":         FloatLe,
+	"=<":         FloatLe,
+	">":          FloatGt,
+	">=":         FloatGe,
+	"=>":         FloatGe,
+	"eq":         StringEq,
+	"ne":         StringNe,
+	"contains":   StringContains,
+	"lacks":      StringNotContains,
+	"ncontains":  StringNotContains,
+	"hasprefix":  StringHasPrefix,
+	"nhasprefix": StringNotHasPrefix,
+	"hassuffix":  StringHasSuffix,
+	"nhassuffix": StringNotHasSuffix,
+	"=~":         StringMatches,
+	"!~":         StringNotMatches,
+}
+
+// parseWhereCondition parses a single where condition, e.g. "foo == 42", from
+// the beginning of tokens and returns the remaining tokens.
+func parseWhereCondition(tokens []token) (whereCondition, []token, error) {
+	var wc whereCondition
+	// The "in" and "not in" operations take a list instead of a single rValue.
+	switch {
+	case tokens[0].isBareword && isFieldPredicate(tokens[0].str):
+		return parseFieldPredicate(tokens[0], tokens[1:])
+	case len(tokens) >= 2 && tokens[1].isOperator("in"):
+		return parseWhereListCondition(tokens[0], StringIn, tokens[2:])
+	case len(tokens) >= 3 && tokens[1].isOperator("not") && tokens[2].isOperator("in"):
+		return parseWhereListCondition(tokens[0], StringNotIn, tokens[3:])
+	}
 
-		whereOp := strings.ToLower(tokens[1].str)
-		switch whereOp {
-		case "==":
-			wc.Operation = FloatEq
-		case "!=":
-			wc.Operation = FloatNe
-		case "<":
-			wc.Operation = FloatLt
-		case "<=":
-			wc.Operation = FloatLe
-		case "=<":
-			wc.Operation = FloatLe
-		case ">":
-			wc.Operation = FloatGt
-		case ">=":
-			wc.Operation = FloatGe
-		case "=>":
-			wc.Operation = FloatGe
-		case "eq":
-			wc.Operation = StringEq
-		case "ne":
-			wc.Operation = StringNe
-		case "contains":
-			wc.Operation = StringContains
-		case "lacks":
-			fallthrough
-		case "ncontains":
-			wc.Operation = StringNotContains
-		case "hasprefix":
-			wc.Operation = StringHasPrefix
-		case "nhasprefix":
-			wc.Operation = StringNotHasPrefix
-		case "hassuffix":
-			wc.Operation = StringHasSuffix
-		case "nhassuffix":
-			wc.Operation = StringNotHasSuffix
-		default:
-			return wc, nil, errors.New(invalidQuery +
-				"Unknown operation in 'where' clause: " + whereOp)
+	if len(tokens) < 3 {
+		return wc, nil, newTokenError(tokens[0], "Not enough arguments in 'where' clause")
+	}
+	for _, t := range tokens[:3] {
+		if t.isOperator("(") || t.isOperator(")") {
+			return wc, nil, newTokenError(t, "Not enough arguments in 'where' clause")
 		}
+	}
 
-		var err error
-		tokens, err = wc.fill(tokens)
-		return wc, tokens, err
+	whereOp := strings.ToLower(tokens[1].str)
+	op, ok := whereOperations[whereOp]
+	if !ok {
+		return wc, nil, newTokenError(tokens[1],
+			"Unknown operation in 'where' clause: "+whereOp)
 	}
+	wc.Operation = op
 
-	// Consume all where conditions.
-	for len(tokens) > 0 {
-		var wc whereCondition
-		var err error
-		wc, tokens, err = parse(tokens)
-		if err != nil {
-			return nil, err
+	var err error
+	tokens, err = wc.fill(tokens)
+	return wc, tokens, err
+}
+
+// parseWhereListCondition parses the parenthesised list of an "in" or
+// "not in" condition, e.g. (500, 502, "foo"), from the beginning of tokens and
+// returns the remaining tokens. The list items must be numbers or quoted
+// strings, they are compiled into hash sets for constant time lookups.
+func parseWhereListCondition(lValue token, op QueryOperation,
+	tokens []token) (whereCondition, []token, error) {
+
+	wc := whereCondition{
+		lString:   lValue.str,
+		lType:     Field,
+		Operation: op,
+		rType:     List,
+		rSet:      make(map[string]struct{}),
+		rFloatSet: make(map[float64]struct{}),
+	}
+	if !lValue.isBareword {
+		wc.lType = String
+	}
+
+	if len(tokens) == 0 {
+		return wc, nil, newEndError("Expected '(' after 'in' in 'where' clause")
+	}
+	if !tokens[0].isOperator("(") {
+		return wc, nil, newTokenError(tokens[0], "Expected '(' after 'in' in 'where' clause")
+	}
+	var items []string
+	for i := 1; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.isOperator(")") {
+			if len(items) == 0 {
+				return wc, nil, newTokenError(t, "Empty list in 'where' clause")
+			}
+			wc.rString = strings.Join(items, ",")
+			return wc, tokens[i+1:], nil
+		}
+		if t.isBareword {
+			f, err := strconv.ParseFloat(t.str, 64)
+			if err != nil {
+				return wc, nil, newTokenError(t,
+					"Expected number or quoted string in 'where' clause's list: "+t.str)
+			}
+			wc.rFloatSet[f] = struct{}{}
+		}
+		wc.rSet[t.str] = struct{}{}
+		if t.isBareword {
+			items = append(items, t.str)
+		} else {
+			items = append(items, strconv.Quote(t.str))
 		}
-		where = append(where, wc)
-		tokens = tokensConsumeOptional(tokens, "and")
 	}
-	return
+	return wc, nil, newTokenError(tokens[0], "Missing ')' in 'where' clause")
+}
+
+// isFieldPredicate returns true if the token is an exists(field) or a
+// missing(field) predicate.
+func isFieldPredicate(str string) bool {
+	name, _, ok := strings.Cut(str, "(")
+	name = strings.ToLower(name)
+	return ok && (name == "exists" || name == "missing") && strings.HasSuffix(str, ")")
+}
+
+// parseFieldPredicate parses an exists(field) or missing(field) predicate,
+// which tests whether a field is present in the line at all, regardless of
+// its value.
+func parseFieldPredicate(t token, tokens []token) (whereCondition, []token, error) {
+	name, field, _ := strings.Cut(t.str, "(")
+	field = strings.TrimSpace(strings.TrimSuffix(field, ")"))
+	wc := whereCondition{lString: field, lType: Field, Operation: FieldExists}
+	if strings.EqualFold(name, "missing") {
+		wc.Operation = FieldMissing
+	}
+	if field == "" || strings.ContainsAny(field, "(),\"'`") {
+		return wc, nil, newTokenError(t, "Expected a single field name in 'where' clause's "+
+			strings.ToLower(name)+"(): "+t.str)
+	}
+	return wc, tokens, nil
 }
 
 // Fill a where condition.
@@ -128,24 +226,26 @@ func (wc *whereCondition) fill(tokens []token) ([]token, error) {
 
 	if wc.Operation > FloatOperation {
 		if !tokens[0].isBareword {
-			return nil, errors.New(invalidQuery +
-				"Expected bareword at 'where' clause's lValue: " + tokens[0].str)
+			return nil, newTokenError(tokens[0],
+				"Expected bareword at 'where' clause's lValue: "+tokens[0].str)
 		}
 
-		if f, err := strconv.ParseFloat(wc.lString, 64); err == nil {
+		if f, ok := parseFloatLiteral(tokens[0]); ok {
 			wc.lFloat = f
 			wc.lType = Float
+			wc.setLiteralUnits(tokens[0].str)
 		} else {
 			wc.lType = Field
 		}
 
 		if !tokens[2].isBareword {
-			return nil, errors.New(invalidQuery +
-				"Expected bareword at 'where' clause's rValue: " + tokens[2].str)
+			return nil, newTokenError(tokens[2],
+				"Expected bareword at 'where' clause's rValue: "+tokens[2].str)
 		}
-		if f, err := strconv.ParseFloat(wc.rString, 64); err == nil {
+		if f, ok := parseFloatLiteral(tokens[2]); ok {
 			wc.rFloat = f
 			wc.rType = Float
+			wc.setLiteralUnits(tokens[2].str)
 		} else {
 			wc.rType = Field
 		}
@@ -164,9 +264,61 @@ func (wc *whereCondition) fill(tokens []token) ([]token, error) {
 		wc.rType = String
 	}
 
+	if wc.Operation == StringMatches || wc.Operation == StringNotMatches {
+		if wc.rType != String {
+			return nil, newTokenError(tokens[2],
+				"Expected quoted regex at 'where' clause's rValue: "+tokens[2].str)
+		}
+		// Both operations compile a positive regex, the negation is done in
+		// stringClause. This way the literal fast path of the regex package is
+		// used, and "!~" behaves correctly for match-all patterns such as ".*".
+		var err error
+		if wc.rRegex, err = regex.New(wc.rString, regex.Default); err != nil {
+			return nil, newTokenError(tokens[2], "Invalid regex in 'where' clause: "+
+				err.Error())
+		}
+	}
+
 	return tokens[3:], nil
 }
 
+// parseFloatLiteral parses a number of a float condition, which may carry a
+// unit, e.g. 100ms or 1MB. Durations are in seconds unless the 'units' keyword
+// gives another unit, see setUnits. Field names don't start with a digit.
+func parseFloatLiteral(t token) (float64, bool) {
+	if f, err := strconv.ParseFloat(t.str, 64); err == nil {
+		return f, true
+	}
+	if t.str == "" || t.str[0] < '0' || t.str[0] > '9' {
+		return 0, false
+	}
+	return funcs.ParseUnitValue(t.str, time.Second)
+}
+
+// setLiteralUnits enables the unit-aware parsing of the field values of a float
+// condition if its literal has a unit, e.g. of dur in "dur > 100ms", so that a
+// value such as 250ms is compared in seconds too.
+func (wc *whereCondition) setLiteralUnits(literal string) {
+	if _, err := strconv.ParseFloat(literal, 64); err != nil {
+		wc.units = time.Second
+	}
+}
+
+// setUnits enables the unit-aware parsing of the field values of a float
+// condition, and converts the duration literals to the given unit.
+func (wc *whereCondition) setUnits(unit time.Duration) {
+	if wc.Operation <= FloatOperation {
+		return
+	}
+	wc.units = unit
+	if wc.lType == Float {
+		wc.lFloat, _ = funcs.ParseUnitValue(wc.lString, unit)
+	}
+	if wc.rType == Float {
+		wc.rFloat, _ = funcs.ParseUnitValue(wc.rString, unit)
+	}
+}
+
 func (wc *whereCondition) floatClause(lValue float64, rValue float64) bool {
 	switch wc.Operation {
 	case FloatEq:
@@ -187,6 +339,19 @@ func (wc *whereCondition) floatClause(lValue float64, rValue float64) bool {
 	return false
 }
 
+func (wc *whereCondition) listClause(lValue string) bool {
+	_, found := wc.rSet[lValue]
+	if !found && len(wc.rFloatSet) > 0 {
+		if f, err := strconv.ParseFloat(lValue, 64); err == nil {
+			_, found = wc.rFloatSet[f]
+		}
+	}
+	if wc.Operation == StringNotIn {
+		return !found
+	}
+	return found
+}
+
 func (wc *whereCondition) stringClause(lValue string, rValue string) bool {
 	switch wc.Operation {
 	case StringEq:
@@ -205,6 +370,10 @@ func (wc *whereCondition) stringClause(lValue string, rValue string) bool {
 		return strings.HasSuffix(lValue, rValue)
 	case StringNotHasSuffix:
 		return !strings.HasSuffix(lValue, rValue)
+	case StringMatches:
+		return wc.rRegex.MatchString(lValue)
+	case StringNotMatches:
+		return !wc.rRegex.MatchString(lValue)
 	default:
 		dlog.Common.Error("Unknown string operation", lValue, wc.Operation, rValue)
 	}
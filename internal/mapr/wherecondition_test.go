@@ -0,0 +1,152 @@
+package mapr
+
+import "testing"
+
+func TestWhereConditionRegex(t *testing.T) {
+	fields := map[string]string{
+		"agent": "Mozilla/5.0 (compatible; Googlebot/2.1)",
+		"path":  "/api/v2/users/42",
+	}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{where: `agent =~ "Googlebot"`, want: true},
+		{where: `agent =~ "(?i)googlebot/\d"`, want: true},
+		{where: `agent !~ "bot"`, want: false},
+		{where: `path =~ "^/api/v[0-9]+/users/\d+$"`, want: true},
+		{where: `path !~ "^/web/"`, want: true},
+		{where: `path =~ ".*"`, want: true},
+		{where: `path !~ ".*"`, want: false},
+		{where: `missing =~ ".*"`, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.where, func(t *testing.T) {
+			q, err := NewQuery("select count(path) where " + tc.where)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %v", err)
+			}
+			if got := q.WhereClause(fields); got != tc.want {
+				t.Errorf("WhereClause() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWhereConditionRegexParseErrors(t *testing.T) {
+	errorClauses := []string{
+		`path =~ "([a-z"`,
+		`path !~ "*foo"`,
+		`path =~ pattern`,
+	}
+
+	for _, where := range errorClauses {
+		t.Run(where, func(t *testing.T) {
+			if q, err := NewQuery("select count(path) where " + where); err == nil {
+				t.Errorf("Expected a parse error but got query %v", q)
+			}
+		})
+	}
+}
+
+func TestWhereConditionIn(t *testing.T) {
+	fields := map[string]string{
+		"status":    "502",
+		"latency":   "1.50",
+		"$hostname": "b",
+	}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{where: "status in (500, 502, 503)", want: true},
+		{where: "status in(500,502,503)", want: true},
+		{where: "status IN (404)", want: false},
+		{where: "status not in (500, 502, 503)", want: false},
+		{where: `$hostname not in ("a","b")`, want: false},
+		{where: `$hostname in ("a", "b")`, want: true},
+		{where: "latency in (1.5, 2)", want: true},
+		{where: `"b" in ("a", "b")`, want: true},
+		{where: "missing in (1)", want: false},
+		{where: "missing not in (1)", want: false},
+		{where: "not status in (404) and $hostname in (\"b\")", want: true},
+		{where: "status in (404) or status in (502)", want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.where, func(t *testing.T) {
+			q, err := NewQuery("select count(status) where " + tc.where)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %v", err)
+			}
+			if got := q.WhereClause(fields); got != tc.want {
+				t.Errorf("WhereClause() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWhereConditionInParseErrors(t *testing.T) {
+	errorClauses := []string{
+		`status in`,
+		`status in 500`,
+		`status in ()`,
+		`status in (500, 502`,
+		`status in (500, foo)`,
+		`status not in ((500))`,
+	}
+
+	for _, where := range errorClauses {
+		t.Run(where, func(t *testing.T) {
+			if q, err := NewQuery("select count(status) where " + where); err == nil {
+				t.Errorf("Expected a parse error but got query %v", q)
+			}
+		})
+	}
+}
+
+func TestWhereConditionExists(t *testing.T) {
+	fields := map[string]string{
+		"status": "502",
+		"user":   "",
+	}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{where: "exists(status)", want: true},
+		{where: "exists(user)", want: true},
+		{where: "exists(latency)", want: false},
+		{where: "missing(latency)", want: true},
+		{where: "MISSING(user)", want: false},
+		{where: "not exists(latency)", want: true},
+		{where: "missing(latency) and status == 502", want: true},
+		// Comparisons with an absent field are never true, not even negated
+		// ones.
+		{where: "latency != 1", want: false},
+		{where: "latency ne 1", want: false},
+		{where: "missing(latency) or latency > 100", want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.where, func(t *testing.T) {
+			q, err := NewQuery("select count(status) where " + tc.where)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %v", err)
+			}
+			if got := q.WhereClause(fields); got != tc.want {
+				t.Errorf("WhereClause() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	for _, where := range []string{"exists()", "exists(a,b)", "missing(md5sum(a))"} {
+		if q, err := NewQuery("select count(status) where " + where); err == nil {
+			t.Errorf("Expected a parse error for %q but got query %v", where, q)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package mapr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// whereExprOp determines how a where expression node is evaluated.
+type whereExprOp int
+
+// The possible where expression node types.
+const (
+	whereLeaf whereExprOp = iota
+	whereAnd  whereExprOp = iota
+	whereOr   whereExprOp = iota
+	whereNot  whereExprOp = iota
+)
+
+// Represents a parsed "where" clause as an expression tree, used by
+// mapr.Query. Leaf nodes hold a single condition, all other nodes combine the
+// results of their children.
+type whereExpr struct {
+	op        whereExprOp
+	condition whereCondition
+	children  []*whereExpr
+}
+
+func (e *whereExpr) String() string {
+	if e == nil {
+		return "whereExpr()"
+	}
+	var name string
+	switch e.op {
+	case whereLeaf:
+		return e.condition.String()
+	case whereAnd:
+		name = "and"
+	case whereOr:
+		name = "or"
+	case whereNot:
+		name = "not"
+	}
+	children := make([]string, 0, len(e.children))
+	for _, child := range e.children {
+		children = append(children, child.String())
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(children, ","))
+}
+
+// eval evaluates the expression against the fields of a single line (or of a
+// single result row).
+func (e *whereExpr) eval(fields map[string]string) bool {
+	switch e.op {
+	case whereAnd:
+		for _, child := range e.children {
+			if !child.eval(fields) {
+				return false
+			}
+		}
+		return true
+	case whereOr:
+		for _, child := range e.children {
+			if child.eval(fields) {
+				return true
+			}
+		}
+		return false
+	case whereNot:
+		return !e.children[0].eval(fields)
+	default:
+		return e.condition.eval(fields)
+	}
+}
+
+// setUnits enables the unit-aware parsing of all float conditions of the
+// expression, see Query.Units.
+func (e *whereExpr) setUnits(unit time.Duration) {
+	if e == nil {
+		return
+	}
+	if e.op == whereLeaf {
+		e.condition.setUnits(unit)
+	}
+	for _, child := range e.children {
+		child.setUnits(unit)
+	}
+}
+
+// conditions returns all leaf conditions of the expression in query order.
+func (e *whereExpr) conditions() []whereCondition {
+	if e == nil {
+		return nil
+	}
+	if e.op == whereLeaf {
+		return []whereCondition{e.condition}
+	}
+	var conditions []whereCondition
+	for _, child := range e.children {
+		conditions = append(conditions, child.conditions()...)
+	}
+	return conditions
+}
+
+// makeWhereExpr parses the "where" clause tokens into an expression tree. The
+// operator precedence is "not" before "and" before "or". Conditions separated
+// by a comma (or by nothing at all) are implicitly combined with "and". An
+// empty clause results in a nil expression, which matches every line.
+func makeWhereExpr(tokens []token) (*whereExpr, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := whereParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, newTokenError(p.tokens[p.pos], "Unexpected token in 'where' clause: "+
+			p.tokens[p.pos].str)
+	}
+	return expr, nil
+}
+
+// Helper to parse the where clause tokens via recursive descent.
+type whereParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *whereParser) peekOperator(operator string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].isOperator(operator)
+}
+
+func (p *whereParser) parseOr() (*whereExpr, error) {
+	expr, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		expr = combineWhereExpr(whereOr, expr, right)
+	}
+	return expr, nil
+}
+
+func (p *whereParser) parseAnd() (*whereExpr, error) {
+	expr, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		if p.peekOperator("or") || p.peekOperator(")") {
+			break
+		}
+		if p.peekOperator("and") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		expr = combineWhereExpr(whereAnd, expr, right)
+	}
+	return expr, nil
+}
+
+func (p *whereParser) parseNot() (*whereExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, newEndError("Not enough arguments in 'where' clause")
+	}
+
+	switch {
+	case p.peekOperator("not"):
+		p.pos++
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &whereExpr{op: whereNot, children: []*whereExpr{child}}, nil
+	case p.peekOperator("("):
+		open := p.tokens[p.pos]
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekOperator(")") {
+			return nil, newTokenError(open, "Missing ')' in 'where' clause")
+		}
+		p.pos++
+		return expr, nil
+	case p.peekOperator(")"):
+		return nil, newTokenError(p.tokens[p.pos], "Unexpected ')' in 'where' clause")
+	}
+
+	wc, rest, err := parseWhereCondition(p.tokens[p.pos:])
+	if err != nil {
+		return nil, err
+	}
+	p.pos = len(p.tokens) - len(rest)
+	return &whereExpr{op: whereLeaf, condition: wc}, nil
+}
+
+// combineWhereExpr joins two expressions with the given operation. Nested
+// nodes of the same operation are flattened, e.g. "a and b and c" becomes a
+// single "and" node with three children.
+func combineWhereExpr(op whereExprOp, left, right *whereExpr) *whereExpr {
+	if left.op == op {
+		left.children = append(left.children, right)
+		return left
+	}
+	return &whereExpr{op: op, children: []*whereExpr{left, right}}
+}
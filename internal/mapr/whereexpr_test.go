@@ -0,0 +1,86 @@
+package mapr
+
+import "testing"
+
+func TestWhereExprEval(t *testing.T) {
+	fields := map[string]string{
+		"status": "503",
+		"path":   "/api/users",
+		"method": "GET",
+	}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{where: "status == 503", want: true},
+		{where: "status == 503, method eq \"GET\"", want: true},
+		{where: "status == 503 and method eq \"POST\"", want: false},
+		{where: "status == 500 or method eq \"GET\"", want: true},
+		{where: "status == 500 or method eq \"POST\"", want: false},
+		{where: "not status == 500", want: true},
+		{where: "not (status == 503 or status == 500)", want: false},
+		{where: "status == 500 or (status >= 400 and path hasprefix \"/api\")", want: true},
+		{where: "(status == 500 or status >= 400) and path hasprefix \"/web\"", want: false},
+		// "and" binds tighter than "or".
+		{where: "status == 500 and method eq \"POST\" or path contains \"users\"", want: true},
+		{where: "status == 500 and (method eq \"POST\" or path contains \"users\")", want: false},
+		{where: "not not status == 503", want: true},
+		{where: "((status == 503))", want: true},
+		// Boolean operators directly followed by a parenthesis.
+		{where: "not(status == 500)", want: true},
+		{where: "NOT(status == 503)", want: false},
+		{where: "status == 500 or(status == 503 and method eq \"GET\")", want: true},
+		{where: "status == 503 and(status == 500 or method eq \"POST\")", want: false},
+		{where: "not(status == 500)or(status == 503)", want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.where, func(t *testing.T) {
+			q, err := NewQuery("select count(status) where " + tc.where)
+			if err != nil {
+				t.Fatalf("Unable to parse query: %v", err)
+			}
+			if got := q.WhereClause(fields); got != tc.want {
+				t.Errorf("WhereClause() = %v, want %v (expression %v)", got, tc.want, q.Where)
+			}
+		})
+	}
+}
+
+func TestWhereExprParseErrors(t *testing.T) {
+	errorClauses := []string{
+		"(status == 503",
+		"status == 503)",
+		"status == 503 or",
+		"not",
+		"()",
+		"(status ==) 503",
+		"status == 503 and or method eq \"GET\"",
+	}
+
+	for _, where := range errorClauses {
+		t.Run(where, func(t *testing.T) {
+			if q, err := NewQuery("select count(status) where " + where); err == nil {
+				t.Errorf("Expected a parse error but got query %v", q)
+			}
+		})
+	}
+}
+
+func TestWhereExprEscapedOperatorField(t *testing.T) {
+	q, err := NewQuery("select count(status) where `or` eq \"yes\" or `not` eq \"yes\"")
+	if err != nil {
+		t.Fatalf("Unable to parse query: %v", err)
+	}
+	conditions := q.Where.conditions()
+	if len(conditions) != 2 {
+		t.Fatalf("Expected two conditions but got %v", conditions)
+	}
+	if conditions[0].lString != "or" || conditions[1].lString != "not" {
+		t.Errorf("Expected escaped fields 'or' and 'not' but got %v", conditions)
+	}
+	if !q.WhereClause(map[string]string{"or": "no", "not": "yes"}) {
+		t.Errorf("Expected where clause to match")
+	}
+}
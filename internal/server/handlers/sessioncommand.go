@@ -108,7 +108,7 @@ func validateSessionSpec(spec session.Spec) error {
 	}
 	if spec.Query != "" {
 		if _, err := mapr.NewQuery(spec.Query); err != nil {
-			return fmt.Errorf("invalid session spec")
+			return fmt.Errorf("invalid session query: %s", mapr.FormatError(err))
 		}
 	}
 
@@ -276,7 +276,9 @@ func TestHandleSessionCommandRejectsInvalidQuerySession(t *testing.T) {
 
 	handler.handleSessionCommand(context.Background(), lcontext.LContext{}, 3, []string{"SESSION", "START", payload}, func() {})
 
-	if message := readServerMessage(t, handler.serverMessages); message != sessionAckErrorPrefix+"invalid session spec" {
+	message := readServerMessage(t, handler.serverMessages)
+	if !strings.HasPrefix(message, sessionAckErrorPrefix+"invalid session query: ") ||
+		!strings.HasSuffix(message, "\nselect from\n       ^") {
 		t.Fatalf("unexpected invalid query-session error: %q", message)
 	}
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/mimecast/dtail/internal/config"
 	"github.com/mimecast/dtail/internal/io/dlog"
+	"github.com/mimecast/dtail/internal/mapr/logformat"
 	"github.com/mimecast/dtail/internal/server/handlers"
 	"github.com/mimecast/dtail/internal/ssh/server"
 	user "github.com/mimecast/dtail/internal/user/server"
@@ -59,6 +60,9 @@ func New(cfg config.RuntimeConfig) *Server {
 	}
 
 	dlog.Server.Info("Starting server", version.String())
+	if err := logformat.RegisterConfigFormats(cfg.Server.MapreduceLogFormats); err != nil {
+		dlog.Server.Error("Unable to register mapr log formats", err)
+	}
 
 	s := Server{
 		cfg: cfg,